@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/handlers"
+	"github.com/loidinhm31/go-bookings-system/internal/repository"
+	"log"
+	"time"
+)
+
+// holdSweepInterval is how often expired holds on rooms are released
+const holdSweepInterval = time.Minute
+
+// sweepHolds releases the expired holds in the background, so the rooms can be booked by others again,
+// and offers the rooms of the expired waitlist offers to the next guests
+func sweepHolds(repo *handlers.Repository) {
+	log.Println("Starting hold sweeper...")
+	go func() {
+		ticker := time.NewTicker(holdSweepInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			releaseExpiredHolds(repo.DB, now)
+			reofferWaitlist(repo, now)
+		}
+	}()
+}
+
+// releaseExpiredHolds releases the holds which expired by now, and returns how many were released
+func releaseExpiredHolds(db repository.DatabaseRepo, now time.Time) int {
+	released, err := db.DeleteExpiredHolds(now)
+	if err != nil {
+		errorLog.Println(err)
+		return 0
+	}
+	if released > 0 {
+		infoLog.Printf("Released %d expired holds\n", released)
+	}
+	return released
+}
+
+// reofferWaitlist offers the rooms of the waitlist offers which expired by now to the next guests,
+// and returns how many guests were notified
+func reofferWaitlist(repo *handlers.Repository, now time.Time) int {
+	notified, err := repo.ReofferExpiredWaitlistOffers(now)
+	if err != nil {
+		errorLog.Println(err)
+		return 0
+	}
+	if notified > 0 {
+		infoLog.Printf("Offered freed rooms to %d waitlisted guests\n", notified)
+	}
+	return notified
+}
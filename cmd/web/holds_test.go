@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/config"
+	"github.com/loidinhm31/go-bookings-system/internal/handlers"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"github.com/loidinhm31/go-bookings-system/internal/repository/dbrepo"
+	"github.com/loidinhm31/go-bookings-system/internal/tokens"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReleaseExpiredHolds(t *testing.T) {
+	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	db := dbrepo.NewTestingRepo(&config.AppConfig{})
+
+	if released := releaseExpiredHolds(db, time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)); released != 2 {
+		t.Errorf("expected 2 holds to be released but got %d", released)
+	}
+
+	// the sweeper carries on when the database fails
+	if released := releaseExpiredHolds(db, time.Date(2060, 1, 1, 12, 0, 0, 0, time.UTC)); released != 0 {
+		t.Errorf("expected no holds to be released but got %d", released)
+	}
+}
+
+func TestReofferWaitlist(t *testing.T) {
+	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	repo := handlers.NewTestRepo(&config.AppConfig{
+		ErrorLog:    errorLog,
+		MailChannel: make(chan models.MailData, 10),
+		Signer:      tokens.NewSigner([]byte("test-signing-key")),
+	})
+
+	if notified := reofferWaitlist(repo, time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)); notified != 1 {
+		t.Errorf("expected 1 guest to be notified but got %d", notified)
+	}
+
+	// the sweeper carries on when the database fails
+	if notified := reofferWaitlist(repo, time.Date(2060, 1, 1, 12, 0, 0, 0, time.UTC)); notified != 0 {
+		t.Errorf("expected no guests to be notified but got %d", notified)
+	}
+}
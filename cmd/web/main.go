@@ -12,7 +12,10 @@ import (
 	"github.com/loidinhm31/go-bookings-system/internal/handlers"
 	"github.com/loidinhm31/go-bookings-system/internal/helpers"
 	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"github.com/loidinhm31/go-bookings-system/internal/payments"
 	"github.com/loidinhm31/go-bookings-system/internal/render"
+	"github.com/loidinhm31/go-bookings-system/internal/storage"
+	"github.com/loidinhm31/go-bookings-system/internal/tokens"
 	"html/template"
 	"log"
 	"net/http"
@@ -23,6 +26,9 @@ import (
 
 const portNumber = ":8080"
 
+// uploadsDir is where uploaded files, like room photos, are stored
+const uploadsDir = "./uploads/"
+
 var app config.AppConfig
 var sessionManager *scs.SessionManager
 var infoLog *log.Logger
@@ -42,6 +48,7 @@ func main() {
 
 	defer close(app.MailChannel)
 	listenForMail()
+	sweepHolds(handlers.Repo)
 
 	log.Println(fmt.Sprintf("Starting application on port %s", portNumber))
 
@@ -60,6 +67,7 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
+	gob.Register([]models.Reservation{})
 
 	mailChannel := make(chan models.MailData)
 	app.MailChannel = mailChannel
@@ -101,6 +109,12 @@ func run() (*driver.DB, error) {
 	dbSsl := os.Getenv("DB_SSL")
 	dbName := os.Getenv("DB_NAME")
 
+	baseURL := os.Getenv("BASE_URL")
+	signingKey := os.Getenv("SIGNING_KEY")
+	if signingKey == "" {
+		log.Fatal("SIGNING_KEY must be set")
+	}
+
 	// production value
 	app.InProduction = productionMode
 
@@ -132,6 +146,12 @@ func run() (*driver.DB, error) {
 	app.TemplateCache = map[string]*template.Template{}
 	app.UseCache = useCache
 
+	app.Storage = storage.NewLocalStorage(uploadsDir, "/uploads")
+
+	app.BaseURL = baseURL
+	app.Signer = tokens.NewSigner([]byte(signingKey))
+	app.Payments = payments.NewFakeGateway()
+
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
 
@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/justinas/nosurf"
 	"github.com/loidinhm31/go-bookings-system/internal/helpers"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
 	"net/http"
 )
 
@@ -23,6 +24,7 @@ func SessionLoad(next http.Handler) http.Handler {
 	return sessionManager.LoadAndSave(next)
 }
 
+// Auth lets logged in users through, guests with an account as well as staff
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !helpers.IsAuthenticated(r) {
@@ -33,3 +35,39 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Staff lets only logged in staff through, guests with an account can't use the admin area
+func Staff(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !helpers.IsAuthenticated(r) {
+			sessionManager.Put(r.Context(), "error", "Please log in first")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		if !helpers.IsStaff(r) {
+			sessionManager.Put(r.Context(), "error", "You don't have access to this page")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Permit lets only the logged in users whose role has the permission through, it guards single admin routes
+func Permit(p models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !helpers.IsAuthenticated(r) {
+				sessionManager.Put(r.Context(), "error", "Please log in first")
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+			if !helpers.Can(r, p) {
+				sessionManager.Put(r.Context(), "error", "You don't have permission to do that")
+				http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
 	"net/http"
 	"testing"
 )
@@ -29,3 +30,27 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
 	}
 }
+
+func TestStaff(t *testing.T) {
+	var testH testHandler
+	h := Staff(&testH)
+
+	switch v := h.(type) {
+	case http.Handler:
+	// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
+	}
+}
+
+func TestPermit(t *testing.T) {
+	var testH testHandler
+	h := Permit(models.PermEditReservations)(&testH)
+
+	switch v := h.(type) {
+	case http.Handler:
+	// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/loidinhm31/go-bookings-system/internal/config"
 	"github.com/loidinhm31/go-bookings-system/internal/handlers"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
 	"net/http"
 )
 
@@ -19,13 +20,24 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/about", handlers.Repo.About)
 
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
 
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/reservations/manage/{token}", handlers.Repo.ManageReservation)
+	mux.Post("/reservations/manage/{token}/cancel", handlers.Repo.PostCancelReservation)
+	mux.Post("/reservations/manage/{token}/change", handlers.Repo.PostChangeReservation)
+	mux.Get("/reservations/manage/{token}/invoice.pdf", handlers.Repo.ReservationInvoice)
+
+	// keep the old room pages working
+	mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+	mux.Get("/api/v1/rooms/{id}/availability", handlers.Repo.RoomAvailabilityJSON)
+	mux.Post("/waitlist", handlers.Repo.PostWaitlist)
+	mux.Get("/waitlist/claim/{token}", handlers.Repo.ClaimWaitlist)
 
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
 
@@ -33,6 +45,8 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
+	mux.Get("/make-reservation/add-room", handlers.Repo.AddRoom)
+	mux.Get("/make-reservation/remove-room/{index}", handlers.Repo.RemoveRoom)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
 	mux.Get("/book-room", handlers.Repo.BookRoom)
@@ -40,24 +54,86 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
+	mux.Get("/user/register", handlers.Repo.ShowRegister)
+	mux.Post("/user/register", handlers.Repo.PostRegister)
+	mux.Get("/user/verify/{token}", handlers.Repo.VerifyEmail)
+	mux.Get("/user/forgot-password", handlers.Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/user/reset-password/{token}", handlers.Repo.ShowResetPassword)
+	mux.Post("/user/reset-password/{token}", handlers.Repo.PostResetPassword)
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
-	mux.Route("/admin", func(r chi.Router) {
-		r.Use(Auth)
-		r.Get("/dashboard", handlers.Repo.AdminDashboard)
+	uploadServer := http.FileServer(http.Dir(uploadsDir))
+	mux.Handle("/uploads/*", http.StripPrefix("/uploads", uploadServer))
 
-		r.Get("/reservations-new", handlers.Repo.AdminNewReservations)
-		r.Get("/reservations-all", handlers.Repo.AdminAllReservations)
-		r.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
-		r.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+	mux.Route("/account", func(r chi.Router) {
+		r.Use(Auth)
+		r.Get("/bookings", handlers.Repo.AccountBookings)
+	})
 
-		r.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
-		r.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+	mux.Route("/admin", func(r chi.Router) {
+		r.Use(Staff)
+		r.Get("/dashboard", handlers.Repo.AdminDashboard)
 
-		r.Get("/process-reservation/{src}/{id}/action", handlers.Repo.AdminProcessReservation)
-		r.Get("/delete-reservation/{src}/{id}/action", handlers.Repo.AdminDeleteReservation)
+		viewReservations := r.With(Permit(models.PermViewReservations))
+		viewReservations.Get("/reservations-new", handlers.Repo.AdminNewReservations)
+		viewReservations.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+		viewReservations.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+		viewReservations.Get("/reservations/lookup", handlers.Repo.AdminLookupReservation)
+		viewReservations.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
+		viewReservations.Get("/reservations/{id}/invoice.pdf", handlers.Repo.AdminReservationInvoice)
+
+		editReservations := r.With(Permit(models.PermEditReservations))
+		editReservations.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+		editReservations.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		editReservations.Get("/update-reservation-status/{src}/{id}/{status}/action", handlers.Repo.AdminUpdateReservationStatus)
+		editReservations.Get("/reservations/{src}/{id}/cancel", handlers.Repo.AdminDeleteReservation)
+		editReservations.Post("/reservations/{src}/{id}/cancel", handlers.Repo.AdminPostDeleteReservation)
+
+		r.With(Permit(models.PermCapturePayments)).Post("/reservations/{src}/{id}/capture", handlers.Repo.AdminPostCapturePayment)
+		r.With(Permit(models.PermRefundPayments)).Post("/reservations/{src}/{id}/refund", handlers.Repo.AdminPostRefundPayment)
+
+		r.With(Permit(models.PermViewRooms)).Get("/rooms", handlers.Repo.AdminRooms)
+
+		manageRooms := r.With(Permit(models.PermManageRooms))
+		manageRooms.Get("/rooms/{id}/show", handlers.Repo.AdminShowRoom)
+		manageRooms.Post("/rooms/{id}", handlers.Repo.AdminPostShowRoom)
+		manageRooms.Get("/delete-room/{id}/action", handlers.Repo.AdminDeleteRoom)
+		manageRooms.Get("/rooms/{id}/photos", handlers.Repo.AdminRoomPhotos)
+		manageRooms.Post("/rooms/{id}/photos", handlers.Repo.AdminPostRoomPhotos)
+		manageRooms.Post("/rooms/{id}/photos/update", handlers.Repo.AdminPostUpdateRoomPhotos)
+		manageRooms.Get("/delete-room-photo/{id}/{photoID}/action", handlers.Repo.AdminDeleteRoomPhoto)
+
+		manageRates := r.With(Permit(models.PermManageRates))
+		manageRates.Get("/rate-plans", handlers.Repo.AdminRatePlans)
+		manageRates.Get("/rate-plans/{id}/show", handlers.Repo.AdminShowRatePlan)
+		manageRates.Post("/rate-plans/{id}", handlers.Repo.AdminPostShowRatePlan)
+		manageRates.Post("/rate-plans/{id}/seasons", handlers.Repo.AdminPostRateSeason)
+		manageRates.Get("/delete-rate-season/{id}/{seasonID}/action", handlers.Repo.AdminDeleteRateSeason)
+		manageRates.Get("/stay-rules", handlers.Repo.AdminStayRules)
+		manageRates.Post("/stay-rules", handlers.Repo.AdminPostStayRules)
+		manageRates.Get("/delete-stay-rule/{id}/action", handlers.Repo.AdminDeleteStayRule)
+		manageRates.Get("/promo-codes", handlers.Repo.AdminPromoCodes)
+		manageRates.Post("/promo-codes", handlers.Repo.AdminPostPromoCodes)
+		manageRates.Get("/delete-promo-code/{id}/action", handlers.Repo.AdminDeletePromoCode)
+
+		managePolicies := r.With(Permit(models.PermManagePolicies))
+		managePolicies.Get("/cancellation-policies", handlers.Repo.AdminCancellationPolicies)
+		managePolicies.Post("/cancellation-policies", handlers.Repo.AdminPostCancellationPolicies)
+		managePolicies.Post("/cancellation-policies/tiers", handlers.Repo.AdminPostCancellationTier)
+		managePolicies.Get("/delete-cancellation-tier/{id}/action", handlers.Repo.AdminDeleteCancellationTier)
+		managePolicies.Get("/tax-rules", handlers.Repo.AdminTaxRules)
+		managePolicies.Post("/tax-rules", handlers.Repo.AdminPostTaxRules)
+		managePolicies.Get("/delete-tax-rule/{id}/action", handlers.Repo.AdminDeleteTaxRule)
+
+		viewGuests := r.With(Permit(models.PermViewGuests))
+		viewGuests.Get("/waitlist", handlers.Repo.AdminWaitlist)
+		viewGuests.Get("/guests", handlers.Repo.AdminGuests)
+		viewGuests.Get("/guests/{id}/show", handlers.Repo.AdminShowGuest)
+
+		r.With(Permit(models.PermMergeGuests)).Post("/guests/{id}/merge", handlers.Repo.AdminPostMergeGuest)
 	})
 
 	return mux
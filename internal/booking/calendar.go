@@ -0,0 +1,73 @@
+package booking
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"time"
+)
+
+// NightStatus is the availability of a room for one night
+type NightStatus string
+
+const (
+	NightFree           NightStatus = "free"
+	NightReserved       NightStatus = "reserved"
+	NightBlocked        NightStatus = "blocked"
+	NightHeld           NightStatus = "held"
+	NightRuleRestricted NightStatus = "rule_restricted"
+)
+
+// CalendarNight is the availability of a room for the night starting on Date, with the stay rules applying to it
+type CalendarNight struct {
+	Date              time.Time
+	Status            NightStatus
+	ClosedToArrival   bool
+	ClosedToDeparture bool
+	MinStay           int // nights, for arrivals on Date
+	MaxStay           int // nights, for arrivals on Date
+}
+
+// Calendar returns the availability of a room for every night from start up to, but not including, end.
+// Reservations, owner blocks and holds make a night unavailable, a reservation wins over the others.
+// A free night which is closed to arrival or departure is rule restricted,
+// minimum and maximum stays only restrict the stays arriving on the night, so they leave it free
+func Calendar(start, end time.Time, restrictions, rules []models.RoomRestriction) []CalendarNight {
+	var nights []CalendarNight
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := CalendarNight{Date: d, Status: NightFree}
+
+		for _, r := range restrictions {
+			if !covers(r, d) {
+				continue
+			}
+			if r.RestrictionID == models.RestrictionReservation {
+				night.Status = NightReserved
+			} else if r.RestrictionID == models.RestrictionOwnerBlock && night.Status == NightFree {
+				night.Status = NightBlocked
+			} else if r.RestrictionID == models.RestrictionHold && night.Status == NightFree {
+				night.Status = NightHeld
+			}
+		}
+
+		for _, rule := range rules {
+			if !covers(rule, d) {
+				continue
+			}
+			switch rule.RestrictionID {
+			case models.RestrictionMinimumStay:
+				night.MinStay = rule.Nights
+			case models.RestrictionMaximumStay:
+				night.MaxStay = rule.Nights
+			case models.RestrictionClosedToArrival:
+				night.ClosedToArrival = true
+			case models.RestrictionClosedToDeparture:
+				night.ClosedToDeparture = true
+			}
+		}
+		if night.Status == NightFree && (night.ClosedToArrival || night.ClosedToDeparture) {
+			night.Status = NightRuleRestricted
+		}
+
+		nights = append(nights, night)
+	}
+	return nights
+}
@@ -0,0 +1,47 @@
+package booking
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"testing"
+)
+
+func TestCalendar(t *testing.T) {
+	restrictions := []models.RoomRestriction{
+		{RestrictionID: models.RestrictionReservation, StartDate: date("2050-06-02"), EndDate: date("2050-06-04")},
+		{RestrictionID: models.RestrictionOwnerBlock, StartDate: date("2050-06-03"), EndDate: date("2050-06-05")},
+		{RestrictionID: models.RestrictionHold, StartDate: date("2050-06-05"), EndDate: date("2050-06-06")},
+	}
+	rules := []models.RoomRestriction{
+		{RestrictionID: models.RestrictionMinimumStay, StartDate: date("2050-06-01"), EndDate: date("2050-06-08"), Nights: 2},
+		{RestrictionID: models.RestrictionClosedToArrival, StartDate: date("2050-06-06"), EndDate: date("2050-06-07")},
+		{RestrictionID: models.RestrictionClosedToDeparture, StartDate: date("2050-06-07"), EndDate: date("2050-06-08")},
+	}
+
+	nights := Calendar(date("2050-06-01"), date("2050-06-09"), restrictions, rules)
+
+	expected := []NightStatus{
+		NightFree,           // 06-01
+		NightReserved,       // 06-02
+		NightReserved,       // 06-03, also blocked
+		NightBlocked,        // 06-04
+		NightHeld,           // 06-05
+		NightRuleRestricted, // 06-06, closed to arrival
+		NightRuleRestricted, // 06-07, closed to departure
+		NightFree,           // 06-08
+	}
+	if len(nights) != len(expected) {
+		t.Fatalf("expected %d nights but got %d", len(expected), len(nights))
+	}
+	for i, night := range nights {
+		if night.Status != expected[i] {
+			t.Errorf("night %s: expected %s but got %s", night.Date.Format("2006-01-02"), expected[i], night.Status)
+		}
+	}
+
+	if nights[0].MinStay != 2 || nights[7].MinStay != 0 {
+		t.Errorf("expected the minimum stay to apply up to 06-07, but got %d and %d", nights[0].MinStay, nights[7].MinStay)
+	}
+	if !nights[5].ClosedToArrival || nights[5].ClosedToDeparture {
+		t.Error("expected 06-06 to be closed to arrival only")
+	}
+}
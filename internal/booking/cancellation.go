@@ -0,0 +1,54 @@
+package booking
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"time"
+)
+
+// CheckInHour is the hour of the arrival day guests check in, cancellation windows count back from it
+const CheckInHour = 14
+
+// CancellationOutcome is what cancelling a reservation costs under its cancellation policy
+type CancellationOutcome struct {
+	HoursBefore       int // whole hours between the cancellation and check-in, negative after check-in
+	FeePercent        int
+	Fee               int // share of the total price which is kept, in cents
+	RefundablePercent int
+}
+
+// Refundable returns how much of the amount paid is given back, which is what was paid beyond the fee
+func (o CancellationOutcome) Refundable(paid int) int {
+	if paid <= o.Fee {
+		return 0
+	}
+	return paid - o.Fee
+}
+
+// EvaluateCancellation applies a cancellation policy to a reservation cancelled at the given time.
+// A tier applies to cancellations less than its hours before check-in, and the applying tier
+// with the fewest hours wins. Cancellations no tier applies to are free
+func EvaluateCancellation(res models.Reservation, policy models.CancellationPolicy, at time.Time) CancellationOutcome {
+	checkIn := res.StartDate.Add(CheckInHour * time.Hour)
+	before := checkIn.Sub(at)
+
+	var tier *models.CancellationTier
+	for i, t := range policy.Tiers {
+		if before >= time.Duration(t.HoursBefore)*time.Hour {
+			continue
+		}
+		if tier == nil || t.HoursBefore < tier.HoursBefore {
+			tier = &policy.Tiers[i]
+		}
+	}
+
+	outcome := CancellationOutcome{
+		HoursBefore:       int(before / time.Hour),
+		RefundablePercent: 100,
+	}
+	if tier != nil {
+		outcome.FeePercent = tier.FeePercent
+		outcome.Fee = (res.TotalPrice*tier.FeePercent + 50) / 100
+		outcome.RefundablePercent = 100 - tier.FeePercent
+	}
+	return outcome
+}
@@ -0,0 +1,89 @@
+package booking
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"testing"
+	"time"
+)
+
+// testPolicy is free until 7 days before check-in, keeps 50% after, and everything within 24 hours
+var testPolicy = models.CancellationPolicy{
+	Name: "Standard",
+	Tiers: []models.CancellationTier{
+		{HoursBefore: 24, FeePercent: 100},
+		{HoursBefore: 168, FeePercent: 50},
+	},
+}
+
+func TestEvaluateCancellation(t *testing.T) {
+	res := models.Reservation{
+		StartDate:  date("2050-06-10"),
+		EndDate:    date("2050-06-12"),
+		TotalPrice: 20000,
+	}
+	checkIn := res.StartDate.Add(CheckInHour * time.Hour)
+
+	var tests = []struct {
+		name                string
+		policy              models.CancellationPolicy
+		at                  time.Time
+		expectedFeePercent  int
+		expectedFee         int
+		expectedHoursBefore int
+	}{
+		{"long-before", testPolicy, checkIn.Add(-30 * 24 * time.Hour), 0, 0, 720},
+		{"exactly-7-days-before", testPolicy, checkIn.Add(-168 * time.Hour), 0, 0, 168},
+		{"just-inside-7-days", testPolicy, checkIn.Add(-168*time.Hour + time.Second), 50, 10000, 167},
+		{"exactly-24-hours-before", testPolicy, checkIn.Add(-24 * time.Hour), 50, 10000, 24},
+		{"just-inside-24-hours", testPolicy, checkIn.Add(-24*time.Hour + time.Second), 100, 20000, 23},
+		{"at-check-in", testPolicy, checkIn, 100, 20000, 0},
+		{"after-check-in", testPolicy, checkIn.Add(3 * time.Hour), 100, 20000, -3},
+		{"no-policy", models.CancellationPolicy{}, checkIn, 0, 0, 0},
+	}
+
+	for _, e := range tests {
+		outcome := EvaluateCancellation(res, e.policy, e.at)
+		if outcome.FeePercent != e.expectedFeePercent {
+			t.Errorf("failed %s: expected fee percent %d, got %d", e.name, e.expectedFeePercent, outcome.FeePercent)
+		}
+		if outcome.Fee != e.expectedFee {
+			t.Errorf("failed %s: expected fee %d, got %d", e.name, e.expectedFee, outcome.Fee)
+		}
+		if outcome.RefundablePercent != 100-e.expectedFeePercent {
+			t.Errorf("failed %s: expected refundable percent %d, got %d", e.name, 100-e.expectedFeePercent, outcome.RefundablePercent)
+		}
+		if outcome.HoursBefore != e.expectedHoursBefore {
+			t.Errorf("failed %s: expected %d hours before, got %d", e.name, e.expectedHoursBefore, outcome.HoursBefore)
+		}
+	}
+}
+
+func TestEvaluateCancellationRoundsFee(t *testing.T) {
+	res := models.Reservation{StartDate: date("2050-06-10"), TotalPrice: 10001}
+	at := res.StartDate.Add(CheckInHour*time.Hour - 48*time.Hour)
+
+	outcome := EvaluateCancellation(res, testPolicy, at)
+	if outcome.Fee != 5001 {
+		t.Errorf("expected a fee of 5001, got %d", outcome.Fee)
+	}
+}
+
+func TestCancellationOutcome_Refundable(t *testing.T) {
+	var tests = []struct {
+		fee      int
+		paid     int
+		expected int
+	}{
+		{0, 3000, 3000},
+		{1000, 3000, 2000},
+		{3000, 3000, 0},
+		{10000, 3000, 0},
+	}
+
+	for _, e := range tests {
+		outcome := CancellationOutcome{Fee: e.fee}
+		if got := outcome.Refundable(e.paid); got != e.expected {
+			t.Errorf("expected %d refundable of %d paid with a fee of %d, got %d", e.expected, e.paid, e.fee, got)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package booking
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// crockford is the Crockford base32 alphabet, it leaves out I, L, O and U, which are easily misread over the phone
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ConfirmationCodeLength is the number of characters of a confirmation code
+const ConfirmationCodeLength = 8
+
+// NewConfirmationCode returns a random confirmation code of ConfirmationCodeLength Crockford base32 characters
+func NewConfirmationCode() (string, error) {
+	b := make([]byte, ConfirmationCodeLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range b {
+		// 256 is a multiple of 32, so every character is equally likely
+		b[i] = crockford[b[i]%32]
+	}
+	return string(b), nil
+}
+
+// NormalizeConfirmationCode turns a confirmation code as typed by a person into its canonical form.
+// Case, spaces and hyphens are ignored, and the letters I, L and O are read as the digits they look like
+func NormalizeConfirmationCode(code string) string {
+	var sb strings.Builder
+	for _, c := range strings.ToUpper(code) {
+		switch c {
+		case ' ', '-':
+			continue
+		case 'I', 'L':
+			c = '1'
+		case 'O':
+			c = '0'
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
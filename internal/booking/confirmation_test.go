@@ -0,0 +1,46 @@
+package booking
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewConfirmationCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code, err := NewConfirmationCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != ConfirmationCodeLength {
+			t.Errorf("expected %d characters, got %q", ConfirmationCodeLength, code)
+		}
+		for _, c := range code {
+			if !strings.ContainsRune(crockford, c) {
+				t.Errorf("code %q has a character outside the alphabet", code)
+			}
+		}
+		if seen[code] {
+			t.Errorf("code %q was generated twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestNormalizeConfirmationCode(t *testing.T) {
+	var tests = []struct {
+		code     string
+		expected string
+	}{
+		{"7K3M9QXA", "7K3M9QXA"},
+		{"7k3m-9qxa", "7K3M9QXA"},
+		{" 7K3M 9QXA ", "7K3M9QXA"},
+		{"OIL0", "0110"},
+	}
+
+	for _, e := range tests {
+		if got := NormalizeConfirmationCode(e.code); got != e.expected {
+			t.Errorf("expected %q to normalize to %q, got %q", e.code, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package booking
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"sort"
+	"time"
+)
+
+// FlexibleStay is a free stay found by a flexible dates search,
+// Distance is the number of days its arrival is off the requested arrival
+type FlexibleStay struct {
+	StartDate time.Time
+	EndDate   time.Time
+	Distance  int
+}
+
+// RoomStays are the free stays found for one room, the closest to the requested arrival first
+type RoomStays struct {
+	Room  models.Room
+	Stays []FlexibleStay
+}
+
+// ArrivalsAround returns the arrivals up to days before and after the requested arrival, leaving out those before today
+func ArrivalsAround(requested time.Time, days int, today time.Time) []time.Time {
+	var arrivals []time.Time
+	for d := requested.AddDate(0, 0, -days); !d.After(requested.AddDate(0, 0, days)); d = d.AddDate(0, 0, 1) {
+		if !d.Before(today) {
+			arrivals = append(arrivals, d)
+		}
+	}
+	return arrivals
+}
+
+// ArrivalsInMonth returns the arrivals on the weekday of the requested arrival within its month,
+// e.g. every friday of the month for a weekend, leaving out those before today
+func ArrivalsInMonth(requested time.Time, today time.Time) []time.Time {
+	var arrivals []time.Time
+	first := time.Date(requested.Year(), requested.Month(), 1, 0, 0, 0, 0, requested.Location())
+	for d := first; d.Month() == requested.Month(); d = d.AddDate(0, 0, 1) {
+		if d.Weekday() == requested.Weekday() && !d.Before(today) {
+			arrivals = append(arrivals, d)
+		}
+	}
+	return arrivals
+}
+
+// FindStays returns the stays of the given nights arriving on one of the arrivals, which fit in a free gap of a room
+// and are allowed by the stay rules of the room. Each room gets at most limit stays, ranked by the distance of
+// their arrival to the requested one, earlier first on a tie. Rooms are ranked by their closest stay
+func FindStays(gaps []models.FreeGap, rules []models.RoomRestriction, arrivals []time.Time, nights int, requested time.Time, limit int) []RoomStays {
+	rulesByRoom := make(map[int][]models.RoomRestriction)
+	for _, rule := range rules {
+		rulesByRoom[rule.RoomID] = append(rulesByRoom[rule.RoomID], rule)
+	}
+
+	var found []RoomStays
+	index := make(map[int]int)
+	for _, gap := range gaps {
+		for _, arrival := range arrivals {
+			departure := arrival.AddDate(0, 0, nights)
+			if arrival.Before(gap.StartDate) || departure.After(gap.EndDate) {
+				continue
+			}
+			if CheckStayRules(arrival, departure, rulesByRoom[gap.Room.ID]) != nil {
+				continue
+			}
+
+			i, ok := index[gap.Room.ID]
+			if !ok {
+				index[gap.Room.ID] = len(found)
+				found = append(found, RoomStays{Room: gap.Room})
+				i = len(found) - 1
+			}
+			found[i].Stays = append(found[i].Stays, FlexibleStay{
+				StartDate: arrival,
+				EndDate:   departure,
+				Distance:  distance(arrival, requested),
+			})
+		}
+	}
+
+	for i := range found {
+		stays := found[i].Stays
+		sort.SliceStable(stays, func(a, b int) bool {
+			if stays[a].Distance != stays[b].Distance {
+				return stays[a].Distance < stays[b].Distance
+			}
+			return stays[a].StartDate.Before(stays[b].StartDate)
+		})
+		if len(stays) > limit {
+			found[i].Stays = stays[:limit]
+		}
+	}
+
+	sort.SliceStable(found, func(a, b int) bool {
+		return found[a].Stays[0].Distance < found[b].Stays[0].Distance
+	})
+	return found
+}
+
+// distance returns the number of days between two dates
+func distance(a, b time.Time) int {
+	days := int(a.Sub(b).Hours() / 24)
+	if days < 0 {
+		return -days
+	}
+	return days
+}
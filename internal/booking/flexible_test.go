@@ -0,0 +1,85 @@
+package booking
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"testing"
+	"time"
+)
+
+func TestArrivalsAround(t *testing.T) {
+	arrivals := ArrivalsAround(date("2050-06-10"), 3, date("2050-06-09"))
+
+	// 06-07 and 06-08 are in the past
+	if len(arrivals) != 5 {
+		t.Fatalf("expected 5 arrivals but got %d", len(arrivals))
+	}
+	if !arrivals[0].Equal(date("2050-06-09")) || !arrivals[4].Equal(date("2050-06-13")) {
+		t.Errorf("expected arrivals from 2050-06-09 to 2050-06-13 but got %s to %s", arrivals[0], arrivals[4])
+	}
+}
+
+func TestArrivalsInMonth(t *testing.T) {
+	// the fridays of june 2050 are the 3rd, 10th, 17th and 24th
+	arrivals := ArrivalsInMonth(date("2050-06-17"), date("2050-06-05"))
+
+	expected := []string{"2050-06-10", "2050-06-17", "2050-06-24"}
+	if len(arrivals) != len(expected) {
+		t.Fatalf("expected %d arrivals but got %d", len(expected), len(arrivals))
+	}
+	for i, arrival := range arrivals {
+		if arrival.Weekday() != time.Friday || arrival.Format("2006-01-02") != expected[i] {
+			t.Errorf("expected arrival %s but got %s", expected[i], arrival.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestFindStays(t *testing.T) {
+	generals := models.Room{ID: 1, RoomName: "General's Quarters"}
+	majors := models.Room{ID: 2, RoomName: "Major's Suite"}
+
+	gaps := []models.FreeGap{
+		// the general's quarters are free up to 06-09, and from 06-12
+		{Room: generals, StartDate: date("2050-06-07"), EndDate: date("2050-06-09")},
+		{Room: generals, StartDate: date("2050-06-12"), EndDate: date("2050-06-16")},
+		// the major's suite is free all the time
+		{Room: majors, StartDate: date("2050-06-07"), EndDate: date("2050-06-16")},
+	}
+	rules := []models.RoomRestriction{
+		{RoomID: 2, RestrictionID: models.RestrictionClosedToArrival, StartDate: date("2050-06-10"), EndDate: date("2050-06-11")},
+	}
+	arrivals := ArrivalsAround(date("2050-06-10"), 3, date("2050-06-01"))
+
+	found := FindStays(gaps, rules, arrivals, 2, date("2050-06-10"), 3)
+	if len(found) != 2 {
+		t.Fatalf("expected stays in 2 rooms but got %d", len(found))
+	}
+
+	// closed to arrival on the requested date, so the suite's closest stays are a day off
+	if found[0].Room.ID != 2 {
+		t.Errorf("expected the major's suite first but got %s", found[0].Room.RoomName)
+	}
+	expected := []string{"2050-06-09", "2050-06-11", "2050-06-08"}
+	if len(found[0].Stays) != len(expected) {
+		t.Fatalf("expected %d stays but got %d", len(expected), len(found[0].Stays))
+	}
+	for i, stay := range found[0].Stays {
+		if stay.StartDate.Format("2006-01-02") != expected[i] {
+			t.Errorf("expected a stay arriving %s but got %s", expected[i], stay.StartDate.Format("2006-01-02"))
+		}
+	}
+
+	// the first gap fits a single stay, the second one fits arrivals from 06-12
+	expected = []string{"2050-06-12", "2050-06-07", "2050-06-13"}
+	distances := []int{2, 3, 3}
+	if len(found[1].Stays) != len(expected) {
+		t.Fatalf("expected %d stays but got %d", len(expected), len(found[1].Stays))
+	}
+	for i, stay := range found[1].Stays {
+		if stay.StartDate.Format("2006-01-02") != expected[i] || stay.Distance != distances[i] {
+			t.Errorf("expected a stay arriving %s but got %s", expected[i], stay.StartDate.Format("2006-01-02"))
+		}
+		if !stay.EndDate.Equal(stay.StartDate.AddDate(0, 0, 2)) {
+			t.Errorf("expected a stay of 2 nights but got %s to %s", stay.StartDate, stay.EndDate)
+		}
+	}
+}
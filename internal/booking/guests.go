@@ -0,0 +1,22 @@
+package booking
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NormalizeEmail returns an email address as guests are matched by, email addresses are not case sensitive
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizePhone returns a phone number as possible duplicate guests are found by, only its digits count
+func NormalizePhone(phone string) string {
+	var sb strings.Builder
+	for _, c := range phone {
+		if unicode.IsDigit(c) {
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,27 @@
+package booking
+
+import "testing"
+
+func TestNormalizeEmail(t *testing.T) {
+	if email := NormalizeEmail(" John@Smith.COM "); email != "john@smith.com" {
+		t.Errorf("expected john@smith.com but got %s", email)
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	var tests = []struct {
+		phone    string
+		expected string
+	}{
+		{"555-123-4567", "5551234567"},
+		{"+1 (555) 123 4567", "15551234567"},
+		{"5551234567", "5551234567"},
+		{"", ""},
+	}
+
+	for _, e := range tests {
+		if phone := NormalizePhone(e.phone); phone != e.expected {
+			t.Errorf("expected %q for %q but got %q", e.expected, e.phone, phone)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package booking
+
+import "github.com/loidinhm31/go-bookings-system/internal/models"
+
+// Fits returns true if a party fits the room, children may take the beds adults leave free but not the other way
+// round. The availability and waitlist queries apply the same rule in SQL
+func Fits(room models.Room, adults, children int) bool {
+	return adults <= room.MaxAdults && adults+children <= room.MaxAdults+room.MaxChildren
+}
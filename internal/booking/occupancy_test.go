@@ -0,0 +1,30 @@
+package booking
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"testing"
+)
+
+func TestFits(t *testing.T) {
+	room := models.Room{MaxAdults: 2, MaxChildren: 1}
+
+	var tests = []struct {
+		name     string
+		adults   int
+		children int
+		expected bool
+	}{
+		{"full", 2, 1, true},
+		{"adults-only", 2, 0, true},
+		{"child-in-adult-bed", 1, 2, true},
+		{"too-many-adults", 3, 0, false},
+		{"too-many-guests", 2, 2, false},
+		{"too-many-children", 0, 4, false},
+	}
+
+	for _, e := range tests {
+		if got := Fits(room, e.adults, e.children); got != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package booking
+
+import (
+	"fmt"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"strings"
+	"time"
+)
+
+// NormalizePromoCode returns a promo code as stored, promo codes are not case sensitive
+func NormalizePromoCode(s string) string {
+	return strings.ToUpper(strings.TrimSpace(s))
+}
+
+// CheckPromoCode returns why the promo code can't be redeemed for the reservation on the day of at,
+// or an empty string if it can
+func CheckPromoCode(promo models.PromoCode, res models.Reservation, at time.Time) string {
+	today := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+
+	if !promo.ValidFrom.IsZero() && today.Before(promo.ValidFrom) {
+		return "This promo code is not valid yet"
+	}
+	if !promo.ValidUntil.IsZero() && today.After(promo.ValidUntil) {
+		return "This promo code has expired"
+	}
+	if promo.MaxUses > 0 && promo.Uses >= promo.MaxUses {
+		return "This promo code has been used up"
+	}
+	return CheckPromoCodeStay(promo, res)
+}
+
+// CheckPromoCodeStay returns why the promo code doesn't apply to the stay of the reservation,
+// or an empty string if it does. Unlike CheckPromoCode it ignores when and how often the code was redeemed,
+// for stays which are changed after the code was redeemed
+func CheckPromoCodeStay(promo models.PromoCode, res models.Reservation) string {
+	nights := int(res.EndDate.Sub(res.StartDate).Hours() / 24)
+	if nights < promo.MinNights {
+		return fmt.Sprintf("This promo code requires a stay of at least %d nights", promo.MinNights)
+	}
+
+	if len(promo.RoomIDs) == 0 {
+		return ""
+	}
+	for _, id := range promo.RoomIDs {
+		if id == res.RoomID {
+			return ""
+		}
+	}
+	return "This promo code is not valid for this room"
+}
@@ -0,0 +1,74 @@
+package booking
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"testing"
+	"time"
+)
+
+func TestCheckPromoCode(t *testing.T) {
+	// three nights in room 1
+	res := models.Reservation{
+		RoomID:    1,
+		StartDate: date("2050-06-02"),
+		EndDate:   date("2050-06-05"),
+	}
+	summer := models.PromoCode{
+		Code:       "SUMMER",
+		ValidFrom:  date("2050-05-01"),
+		ValidUntil: date("2050-05-31"),
+	}
+	at := time.Date(2050, 5, 31, 23, 30, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name     string
+		promo    models.PromoCode
+		at       time.Time
+		expected string
+	}{
+		{"valid", summer, at, ""},
+		{"first-day", summer, date("2050-05-01"), ""},
+		{"not-yet", summer, date("2050-04-30"), "This promo code is not valid yet"},
+		{"expired", summer, date("2050-06-01"), "This promo code has expired"},
+		{"open-ended", models.PromoCode{}, date("2099-01-01"), ""},
+		{"uses-left", models.PromoCode{MaxUses: 5, Uses: 4}, at, ""},
+		{"used-up", models.PromoCode{MaxUses: 5, Uses: 5}, at, "This promo code has been used up"},
+		{"unlimited", models.PromoCode{Uses: 500}, at, ""},
+		{"min-nights", models.PromoCode{MinNights: 3}, at, ""},
+		{"too-short", models.PromoCode{MinNights: 4}, at, "This promo code requires a stay of at least 4 nights"},
+		{"room-eligible", models.PromoCode{RoomIDs: []int{2, 1}}, at, ""},
+		{"room-not-eligible", models.PromoCode{RoomIDs: []int{2}}, at, "This promo code is not valid for this room"},
+	}
+
+	for _, e := range tests {
+		reason := CheckPromoCode(e.promo, res, e.at)
+		if reason != e.expected {
+			t.Errorf("failed %s: expected %q but got %q", e.name, e.expected, reason)
+		}
+	}
+}
+
+func TestCheckPromoCodeStay(t *testing.T) {
+	res := models.Reservation{
+		RoomID:    1,
+		StartDate: date("2050-06-02"),
+		EndDate:   date("2050-06-05"),
+	}
+
+	// when and how often the code was redeemed doesn't matter anymore
+	promo := models.PromoCode{ValidUntil: date("2050-01-01"), MaxUses: 1, Uses: 1}
+	if reason := CheckPromoCodeStay(promo, res); reason != "" {
+		t.Errorf("expected the stay to qualify but got %q", reason)
+	}
+
+	promo.RoomIDs = []int{2}
+	if reason := CheckPromoCodeStay(promo, res); reason == "" {
+		t.Error("expected the room not to qualify")
+	}
+}
+
+func TestNormalizePromoCode(t *testing.T) {
+	if code := NormalizePromoCode(" summer-10 "); code != "SUMMER-10" {
+		t.Errorf("expected SUMMER-10 but got %s", code)
+	}
+}
@@ -0,0 +1,63 @@
+package booking
+
+import (
+	"fmt"
+	"github.com/loidinhm31/go-bookings-system/internal/constants"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"time"
+)
+
+// IsStayRule reports whether the restriction is a rule on the stay, rather than a reservation or a block of the room
+func IsStayRule(restrictionID int) bool {
+	switch restrictionID {
+	case models.RestrictionMinimumStay, models.RestrictionMaximumStay,
+		models.RestrictionClosedToArrival, models.RestrictionClosedToDeparture:
+		return true
+	}
+	return false
+}
+
+// CheckStayRules checks a stay from start to end against the stay rules of a room, and returns the first violated rule.
+// A rule covers the dates from its start date up to, but not including, its end date.
+// Minimum stay, maximum stay and closed to arrival rules apply to the arrival date,
+// closed to departure rules apply to the departure date
+func CheckStayRules(start, end time.Time, rules []models.RoomRestriction) *models.RuleViolation {
+	nights := int(end.Sub(start).Hours() / 24)
+
+	for _, rule := range rules {
+		var reason string
+
+		switch rule.RestrictionID {
+		case models.RestrictionMinimumStay:
+			if covers(rule, start) && nights < rule.Nights {
+				reason = fmt.Sprintf("A minimum stay of %d nights applies to arrivals on %s", rule.Nights, start.Format(constants.Layout))
+			}
+		case models.RestrictionMaximumStay:
+			if covers(rule, start) && nights > rule.Nights {
+				reason = fmt.Sprintf("A maximum stay of %d nights applies to arrivals on %s", rule.Nights, start.Format(constants.Layout))
+			}
+		case models.RestrictionClosedToArrival:
+			if covers(rule, start) {
+				reason = fmt.Sprintf("Arrivals are not possible on %s", start.Format(constants.Layout))
+			}
+		case models.RestrictionClosedToDeparture:
+			if covers(rule, end) {
+				reason = fmt.Sprintf("Departures are not possible on %s", end.Format(constants.Layout))
+			}
+		}
+
+		if reason != "" {
+			return &models.RuleViolation{
+				RoomID:        rule.RoomID,
+				RestrictionID: rule.RestrictionID,
+				Reason:        reason,
+			}
+		}
+	}
+	return nil
+}
+
+// covers reports whether the date is within the date range of the rule
+func covers(rule models.RoomRestriction, date time.Time) bool {
+	return !date.Before(rule.StartDate) && date.Before(rule.EndDate)
+}
@@ -0,0 +1,69 @@
+package booking
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/constants"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse(constants.Layout, s)
+	return d
+}
+
+// rules for july 2050
+var julyRules = []models.RoomRestriction{
+	{RoomID: 1, RestrictionID: models.RestrictionMinimumStay, StartDate: date("2050-07-01"), EndDate: date("2050-08-01"), Nights: 3},
+	{RoomID: 1, RestrictionID: models.RestrictionMaximumStay, StartDate: date("2050-07-01"), EndDate: date("2050-08-01"), Nights: 7},
+	{RoomID: 1, RestrictionID: models.RestrictionClosedToArrival, StartDate: date("2050-07-14"), EndDate: date("2050-07-15")},
+	{RoomID: 1, RestrictionID: models.RestrictionClosedToDeparture, StartDate: date("2050-07-20"), EndDate: date("2050-07-21")},
+}
+
+func TestCheckStayRules(t *testing.T) {
+	var tests = []struct {
+		name          string
+		start         string
+		end           string
+		restrictionID int
+	}{
+		{"allowed", "2050-07-02", "2050-07-05", 0},
+		{"too-short", "2050-07-02", "2050-07-04", models.RestrictionMinimumStay},
+		{"too-short-arriving-before-rule", "2050-06-30", "2050-07-02", 0},
+		{"too-short-arriving-after-rule", "2050-08-01", "2050-08-02", 0},
+		{"longest-allowed", "2050-07-02", "2050-07-09", 0},
+		{"too-long", "2050-07-02", "2050-07-10", models.RestrictionMaximumStay},
+		{"closed-to-arrival", "2050-07-14", "2050-07-17", models.RestrictionClosedToArrival},
+		{"staying-over-closed-to-arrival", "2050-07-13", "2050-07-16", 0},
+		{"closed-to-departure", "2050-07-17", "2050-07-20", models.RestrictionClosedToDeparture},
+		{"arriving-on-closed-to-departure", "2050-07-20", "2050-07-23", 0},
+	}
+
+	for _, e := range tests {
+		violation := CheckStayRules(date(e.start), date(e.end), julyRules)
+
+		if e.restrictionID == 0 {
+			if violation != nil {
+				t.Errorf("failed %s: expected no violation but got %q", e.name, violation.Reason)
+			}
+			continue
+		}
+
+		if violation == nil {
+			t.Errorf("failed %s: expected a violation but got none", e.name)
+		} else if violation.RestrictionID != e.restrictionID {
+			t.Errorf("failed %s: expected restriction %d but got %d", e.name, e.restrictionID, violation.RestrictionID)
+		} else if violation.Reason == "" {
+			t.Errorf("failed %s: expected a reason", e.name)
+		}
+	}
+}
+
+func TestIsStayRule(t *testing.T) {
+	if IsStayRule(models.RestrictionReservation) || IsStayRule(models.RestrictionOwnerBlock) {
+		t.Error("expected reservations and owner blocks not to be stay rules")
+	}
+	if !IsStayRule(models.RestrictionMinimumStay) || !IsStayRule(models.RestrictionClosedToDeparture) {
+		t.Error("expected stay rules")
+	}
+}
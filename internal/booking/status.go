@@ -0,0 +1,43 @@
+package booking
+
+import (
+	"fmt"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+)
+
+// transitions lists the statuses a reservation may move to from each status
+var transitions = map[models.ReservationStatus][]models.ReservationStatus{
+	models.StatusPending:   {models.StatusConfirmed, models.StatusCancelled},
+	models.StatusConfirmed: {models.StatusCheckedIn, models.StatusCancelled, models.StatusNoShow},
+	models.StatusCheckedIn: {models.StatusCheckedOut},
+}
+
+// TransitionError is returned when the reservation lifecycle does not allow a status change
+type TransitionError struct {
+	From models.ReservationStatus
+	To   models.ReservationStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("reservation status can't change from %s to %s", e.From, e.To)
+}
+
+// NextStatuses returns the statuses a reservation may move to from the given status
+func NextStatuses(from models.ReservationStatus) []models.ReservationStatus {
+	return transitions[from]
+}
+
+// Transition checks that a reservation may move from one status to another, and returns a *TransitionError if not
+func Transition(from, to models.ReservationStatus) error {
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to}
+}
+
+// IsChangeable reports whether the guest may still change the dates or the room of a reservation in the status
+func IsChangeable(status models.ReservationStatus) bool {
+	return status == models.StatusPending || status == models.StatusConfirmed
+}
@@ -0,0 +1,68 @@
+package booking
+
+import (
+	"errors"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"testing"
+)
+
+func TestTransition(t *testing.T) {
+	var tests = []struct {
+		from    models.ReservationStatus
+		to      models.ReservationStatus
+		allowed bool
+	}{
+		{models.StatusPending, models.StatusConfirmed, true},
+		{models.StatusPending, models.StatusCancelled, true},
+		{models.StatusPending, models.StatusCheckedIn, false},
+		{models.StatusConfirmed, models.StatusCheckedIn, true},
+		{models.StatusConfirmed, models.StatusNoShow, true},
+		{models.StatusConfirmed, models.StatusCancelled, true},
+		{models.StatusConfirmed, models.StatusPending, false},
+		{models.StatusCheckedIn, models.StatusCheckedOut, true},
+		{models.StatusCheckedIn, models.StatusCancelled, false},
+		{models.StatusCheckedOut, models.StatusCheckedIn, false},
+		{models.StatusCancelled, models.StatusConfirmed, false},
+		{models.StatusNoShow, models.StatusCheckedIn, false},
+		{models.StatusConfirmed, models.StatusConfirmed, false},
+		{"unknown", models.StatusConfirmed, false},
+	}
+
+	for _, e := range tests {
+		err := Transition(e.from, e.to)
+		if e.allowed && err != nil {
+			t.Errorf("expected %s to %s to be allowed, but got %s", e.from, e.to, err)
+		}
+		if !e.allowed {
+			var transitionErr *TransitionError
+			if !errors.As(err, &transitionErr) {
+				t.Errorf("expected a transition error for %s to %s, but got %v", e.from, e.to, err)
+			} else if transitionErr.From != e.from || transitionErr.To != e.to {
+				t.Errorf("expected the transition error to name %s and %s", e.from, e.to)
+			}
+		}
+	}
+}
+
+func TestNextStatuses(t *testing.T) {
+	for _, status := range []models.ReservationStatus{models.StatusCheckedOut, models.StatusCancelled, models.StatusNoShow} {
+		if len(NextStatuses(status)) != 0 {
+			t.Errorf("expected %s to be a final status", status)
+		}
+	}
+
+	for _, next := range NextStatuses(models.StatusConfirmed) {
+		if err := Transition(models.StatusConfirmed, next); err != nil {
+			t.Errorf("expected %s to be reachable from confirmed", next)
+		}
+	}
+}
+
+func TestIsChangeable(t *testing.T) {
+	for _, status := range models.ReservationStatuses {
+		expected := status == models.StatusPending || status == models.StatusConfirmed
+		if IsChangeable(status) != expected {
+			t.Errorf("expected %s changeable to be %t", status, expected)
+		}
+	}
+}
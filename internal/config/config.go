@@ -3,6 +3,9 @@ package config
 import (
 	"github.com/alexedwards/scs/v2"
 	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"github.com/loidinhm31/go-bookings-system/internal/payments"
+	"github.com/loidinhm31/go-bookings-system/internal/storage"
+	"github.com/loidinhm31/go-bookings-system/internal/tokens"
 	"html/template"
 	"log"
 )
@@ -16,4 +19,8 @@ type AppConfig struct {
 	InProduction   bool
 	SessionManager *scs.SessionManager
 	MailChannel    chan models.MailData
+	Storage        storage.Storage
+	BaseURL        string // public address of the application, used for links in emails
+	Signer         *tokens.Signer
+	Payments       payments.PaymentGateway
 }
@@ -3,10 +3,19 @@ package forms
 import (
 	"fmt"
 	"github.com/asaskevich/govalidator"
+	"github.com/loidinhm31/go-bookings-system/internal/constants"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+var moneyPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,2})?$`)
+var promoCodePattern = regexp.MustCompile(`^[A-Za-z0-9]+(-[A-Za-z0-9]+)*$`)
+
 // Form creates a custom form struct, embeds an url.Values object
 type Form struct {
 	url.Values
@@ -63,3 +72,105 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+// IsSlug checks for a valid url slug, made of lowercase letters, digits and single dashes
+func (f *Form) IsSlug(field string) {
+	if !slugPattern.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Only lowercase letters, digits and dashes are allowed")
+	}
+}
+
+// MinInt checks for a whole number which is at least min
+func (f *Form) MinInt(field string, min int) bool {
+	x, err := strconv.Atoi(f.Get(field))
+	if err != nil || x < min {
+		f.Errors.Add(field, fmt.Sprintf("This field must be a whole number of at least %d", min))
+		return false
+	}
+	return true
+}
+
+// IsMoney checks for a non-negative amount with at most two decimals, like 120 or 99.50
+func (f *Form) IsMoney(field string) {
+	if !moneyPattern.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Invalid amount")
+	}
+}
+
+// IsDate checks for a date in the yyyy-mm-dd format
+func (f *Form) IsDate(field string) {
+	_, err := time.Parse(constants.Layout, f.Get(field))
+	if err != nil {
+		f.Errors.Add(field, "Invalid date")
+	}
+}
+
+// IsPromoCode checks an optional promo code for 3 to 20 letters and digits, which may be separated by hyphens
+func (f *Form) IsPromoCode(field string) {
+	code := strings.TrimSpace(f.Get(field))
+	if code == "" {
+		return
+	}
+	if len(code) < 3 || len(code) > 20 || !promoCodePattern.MatchString(code) {
+		f.Errors.Add(field, "Invalid promo code")
+	}
+}
+
+// IsCardNumber checks for a payment card number of 12 to 19 digits with a valid Luhn check digit,
+// spaces between the digits are allowed
+func (f *Form) IsCardNumber(field string) {
+	digits := strings.ReplaceAll(f.Get(field), " ", "")
+	if len(digits) < 12 || len(digits) > 19 {
+		f.Errors.Add(field, "Invalid card number")
+		return
+	}
+
+	sum := 0
+	for i := range digits {
+		d := int(digits[len(digits)-1-i] - '0')
+		if d < 0 || d > 9 {
+			f.Errors.Add(field, "Invalid card number")
+			return
+		}
+		// double every second digit from the right
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+
+	if sum%10 != 0 {
+		f.Errors.Add(field, "Invalid card number")
+	}
+}
+
+// Matches checks that a field repeats another one, like a password confirmation
+func (f *Form) Matches(field, other string) {
+	if f.Get(field) != f.Get(other) {
+		f.Errors.Add(field, "This field does not match")
+	}
+}
+
+// IsStrongPassword checks for a password of at least 8 characters with upper and lower case letters and a digit
+func (f *Form) IsStrongPassword(field string) {
+	password := f.Get(field)
+
+	var upper, lower, digit bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		}
+	}
+
+	if len([]rune(password)) < 8 || !upper || !lower || !digit {
+		f.Errors.Add(field, "Use at least 8 characters with upper and lower case letters and a digit")
+	}
+}
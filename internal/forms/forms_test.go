@@ -124,3 +124,183 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("got a valid for an invalid email address")
 	}
 }
+
+func TestForm_IsSlug(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("slug", "generals-quarters")
+	form := New(postedData)
+
+	form.IsSlug("slug")
+	if !form.Valid() {
+		t.Error("got an invalid slug when we should not have")
+	}
+
+	for _, slug := range []string{"", "General's Quarters", "-generals", "generals--quarters"} {
+		postedData = url.Values{}
+		postedData.Add("slug", slug)
+		form = New(postedData)
+
+		form.IsSlug("slug")
+		if form.Valid() {
+			t.Errorf("got a valid slug for %q", slug)
+		}
+	}
+}
+
+func TestForm_MinInt(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("adults", "2")
+	form := New(postedData)
+
+	if !form.MinInt("adults", 1) {
+		t.Error("shows min int of 1 is not met when it is")
+	}
+
+	for _, value := range []string{"", "0", "two", "1.5"} {
+		postedData = url.Values{}
+		postedData.Add("adults", value)
+		form = New(postedData)
+
+		if form.MinInt("adults", 1) {
+			t.Errorf("shows min int of 1 is met for %q", value)
+		}
+	}
+}
+
+func TestForm_IsMoney(t *testing.T) {
+	for _, value := range []string{"0", "120", "99.5", "99.50"} {
+		postedData := url.Values{}
+		postedData.Add("price", value)
+		form := New(postedData)
+
+		form.IsMoney("price")
+		if !form.Valid() {
+			t.Errorf("got an invalid amount for %q", value)
+		}
+	}
+
+	for _, value := range []string{"", "-1", "1.999", "ten"} {
+		postedData := url.Values{}
+		postedData.Add("price", value)
+		form := New(postedData)
+
+		form.IsMoney("price")
+		if form.Valid() {
+			t.Errorf("got a valid amount for %q", value)
+		}
+	}
+}
+
+func TestForm_IsDate(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("start_date", "2050-07-01")
+	form := New(postedData)
+
+	form.IsDate("start_date")
+	if !form.Valid() {
+		t.Error("got an invalid date when should have been valid")
+	}
+
+	for _, value := range []string{"", "2050-13-01", "01/07/2050"} {
+		postedData := url.Values{}
+		postedData.Add("start_date", value)
+		form := New(postedData)
+
+		form.IsDate("start_date")
+		if form.Valid() {
+			t.Errorf("got a valid date for %q", value)
+		}
+	}
+}
+
+func TestForm_IsCardNumber(t *testing.T) {
+	for _, value := range []string{"4242424242424242", "4242 4242 4242 4242", "4000000000000002"} {
+		postedData := url.Values{}
+		postedData.Add("card_number", value)
+		form := New(postedData)
+
+		form.IsCardNumber("card_number")
+		if !form.Valid() {
+			t.Errorf("got an invalid card number for %q", value)
+		}
+	}
+
+	for _, value := range []string{"", "4242424242424241", "4242-4242-4242-4242", "42424242", "abcdabcdabcdabcd"} {
+		postedData := url.Values{}
+		postedData.Add("card_number", value)
+		form := New(postedData)
+
+		form.IsCardNumber("card_number")
+		if form.Valid() {
+			t.Errorf("got a valid card number for %q", value)
+		}
+	}
+}
+
+func TestForm_IsPromoCode(t *testing.T) {
+	for _, value := range []string{"", "SUMMER10", "summer-10", " EARLY-BIRD-2050 "} {
+		postedData := url.Values{}
+		postedData.Add("promo_code", value)
+		form := New(postedData)
+
+		form.IsPromoCode("promo_code")
+		if !form.Valid() {
+			t.Errorf("got an invalid promo code for %q", value)
+		}
+	}
+
+	for _, value := range []string{"AB", "SUMMER 10", "-SUMMER", "SUMMER--10", "ÉTÉ2050", "ABCDEFGHIJKLMNOPQRSTU"} {
+		postedData := url.Values{}
+		postedData.Add("promo_code", value)
+		form := New(postedData)
+
+		form.IsPromoCode("promo_code")
+		if form.Valid() {
+			t.Errorf("got a valid promo code for %q", value)
+		}
+	}
+}
+
+func TestForm_Matches(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("password", "correct horse")
+	postedData.Add("password_confirm", "correct horse")
+	form := New(postedData)
+
+	form.Matches("password_confirm", "password")
+	if !form.Valid() {
+		t.Error("got a mismatch for equal fields")
+	}
+
+	postedData.Set("password_confirm", "battery staple")
+	form = New(postedData)
+
+	form.Matches("password_confirm", "password")
+	if form.Valid() {
+		t.Error("got a match for different fields")
+	}
+}
+
+func TestForm_IsStrongPassword(t *testing.T) {
+	for _, value := range []string{"Secret-Passw0rd", "Abcdefg1", "Ünïcode9x"} {
+		postedData := url.Values{}
+		postedData.Add("password", value)
+		form := New(postedData)
+
+		form.IsStrongPassword("password")
+		if !form.Valid() {
+			t.Errorf("got a weak password for %q", value)
+		}
+	}
+
+	for _, value := range []string{"", "Abcde1", "secret-passw0rd", "SECRET-PASSW0RD", "Secret-Password", "12345678"} {
+		postedData := url.Values{}
+		postedData.Add("password", value)
+		form := New(postedData)
+
+		form.IsStrongPassword("password")
+		if form.Valid() {
+			t.Errorf("got a strong password for %q", value)
+		}
+	}
+}
@@ -1,24 +1,44 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/loidinhm31/go-bookings-system/internal/booking"
 	"github.com/loidinhm31/go-bookings-system/internal/config"
 	"github.com/loidinhm31/go-bookings-system/internal/constants"
 	"github.com/loidinhm31/go-bookings-system/internal/driver"
 	"github.com/loidinhm31/go-bookings-system/internal/forms"
 	"github.com/loidinhm31/go-bookings-system/internal/helpers"
+	"github.com/loidinhm31/go-bookings-system/internal/images"
+	"github.com/loidinhm31/go-bookings-system/internal/invoices"
 	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"github.com/loidinhm31/go-bookings-system/internal/payments"
+	"github.com/loidinhm31/go-bookings-system/internal/pricing"
 	"github.com/loidinhm31/go-bookings-system/internal/render"
 	"github.com/loidinhm31/go-bookings-system/internal/repository"
 	"github.com/loidinhm31/go-bookings-system/internal/repository/dbrepo"
+	"github.com/loidinhm31/go-bookings-system/internal/tokens"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// maxPhotoSize is the largest room photo accepted for upload
+const maxPhotoSize = 5 << 20
+
+// maxPhotoUploadSize is the largest request body of a photo upload, the photo together with the rest of the form
+const maxPhotoUploadSize = maxPhotoSize + 1<<20
+
+// thumbnail bounds for room photos
+const thumbnailWidth, thumbnailHeight = 400, 300
+
 type Repository struct {
 	App *config.AppConfig
 	DB  repository.DatabaseRepo
@@ -28,7 +48,7 @@ type jsonResponse struct {
 	OK        bool   `json:"ok"`
 	Message   string `json:"message"`
 	RoomID    string `json:"room_id"`
-	StartDate string `json:"start_date""`
+	StartDate string `json:"start_date"`
 	EndDate   string `json:"end_date"`
 }
 
@@ -78,6 +98,25 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	res.Room.RoomName = room.RoomName
+
+	// price the stay night by night, the total is kept in the session until the reservation is made
+	room.ID = res.RoomID
+	plan, err := m.DB.GetRatePlanForRoom(res.RoomID)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't calculate price")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	rules, err := m.DB.AllTaxRules()
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't calculate price")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	quote := pricing.Calculate(res, room, plan, rules, models.PromoCode{})
+	res.TotalPrice = quote.Total
+	res.Taxes = quote.Taxes
+
 	m.App.SessionManager.Put(r.Context(), "reservation", res) // update reservation value in the session
 
 	sd := res.StartDate.Format(constants.Layout)
@@ -86,9 +125,14 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	stringMap := make(map[string]string)
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
+	if res.HoldToken != "" {
+		stringMap["held_until"] = heldUntil(res.HoldExpiresAt)
+	}
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["nights"] = quote.Nights
+	m.addBookedRooms(r, data, res)
 
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil),
@@ -97,6 +141,107 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// renderMakeReservation shows the make reservation form again, with the errors of the posted form
+func (m *Repository) renderMakeReservation(w http.ResponseWriter, r *http.Request, reservation models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+	m.addBookedRooms(r, data, reservation)
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = r.Form.Get("start_date")
+	stringMap["end_date"] = r.Form.Get("end_date")
+	if reservation.HoldToken != "" {
+		stringMap["held_until"] = heldUntil(reservation.HoldExpiresAt)
+	}
+
+	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// heldUntil formats the expiry of a hold, with the date unless it expires today
+func heldUntil(expiresAt time.Time) string {
+	if expiresAt.Format(constants.Layout) == time.Now().Format(constants.Layout) {
+		return expiresAt.Format("15:04")
+	}
+	return expiresAt.Format(constants.Layout + " 15:04")
+}
+
+// bookedRooms returns the rooms added to the booking in the session before the room being reserved, see AddRoom
+func (m *Repository) bookedRooms(r *http.Request) []models.Reservation {
+	rooms, _ := m.App.SessionManager.Get(r.Context(), "rooms").([]models.Reservation)
+	return rooms
+}
+
+// addBookedRooms adds the rooms booked together with the reservation, their grand total and its deposit to the template data
+func (m *Repository) addBookedRooms(r *http.Request, data map[string]interface{}, reservation models.Reservation) {
+	rooms := m.bookedRooms(r)
+	total := reservation.TotalPrice
+	for _, res := range rooms {
+		total += res.TotalPrice
+	}
+	data["rooms"] = rooms
+	data["total"] = total
+	data["deposit"] = payments.GroupDeposit(rooms) + payments.Deposit(reservation.TotalPrice)
+}
+
+// AddRoom adds the priced room in the session to the booking, so another room can be searched for
+func (m *Repository) AddRoom(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.App.SessionManager.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok || res.RoomID == 0 || res.TotalPrice == 0 {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't get reservation")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	rooms := m.bookedRooms(r)
+	for _, booked := range rooms {
+		if booked.RoomID == res.RoomID && booked.StartDate.Before(res.EndDate) && res.StartDate.Before(booked.EndDate) {
+			m.App.SessionManager.Put(r.Context(), "warning", "This room is already part of your booking for these dates")
+			http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+			return
+		}
+	}
+
+	m.App.SessionManager.Put(r.Context(), "rooms", append(rooms, res))
+	// keep the party for the next search
+	m.App.SessionManager.Put(r.Context(), "reservation", models.Reservation{Adults: res.Adults, Children: res.Children})
+	m.App.SessionManager.Put(r.Context(), "success",
+		fmt.Sprintf("%s was added to your booking, search for the next room", res.Room.RoomName))
+	http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+}
+
+// RemoveRoom removes a room added before from the booking
+func (m *Repository) RemoveRoom(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	index, err := strconv.Atoi(exploded[3])
+	rooms := m.bookedRooms(r)
+	if err != nil || index < 0 || index >= len(rooms) {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't find room in your booking")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	m.releaseHold(rooms[index])
+	rooms = append(rooms[:index:index], rooms[index+1:]...)
+	if len(rooms) == 0 {
+		m.App.SessionManager.Remove(r.Context(), "rooms")
+	} else {
+		m.App.SessionManager.Put(r.Context(), "rooms", rooms)
+	}
+	m.App.SessionManager.Put(r.Context(), "success", "Room removed from your booking")
+
+	if res, ok := m.App.SessionManager.Get(r.Context(), "reservation").(models.Reservation); ok && res.RoomID != 0 {
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+}
+
+// PostReservation books the room in the session together with the rooms added before, all with the same guest details.
+// The rooms are reserved all at once or not at all, and one deposit is taken for the grand total
 func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -116,8 +261,10 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	reservation.LastName = r.Form.Get("last_name")
 	reservation.Phone = r.Form.Get("phone")
 	reservation.Email = r.Form.Get("email")
-	reservation.RoomID, err = strconv.Atoi(r.Form.Get("room_id"))
-	if err != nil {
+
+	// the room is the one priced and held in the session, any other room posted would be booked at its price
+	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	if err != nil || roomID != reservation.RoomID {
 		m.App.SessionManager.Put(r.Context(), "error", "Can't get room")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -125,56 +272,192 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 
 	// check form valid
 	form := forms.New(r.PostForm)
-	form.Required("first_name", "last_name", "email")
+	form.Required("first_name", "last_name", "email", "card_number")
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
+	form.IsCardNumber("card_number")
+	form.IsPromoCode("promo_code")
 
 	if !form.Valid() {
-		data := make(map[string]interface{})
-		data["reservation"] = reservation
+		m.renderMakeReservation(w, r, reservation, form)
+		return
+	}
 
-		stringMap := make(map[string]string)
-		stringMap["start_date"] = r.Form.Get("start_date")
-		stringMap["end_date"] = r.Form.Get("end_date")
+	// copy the rooms, so a failed attempt leaves the booking in the session as it was
+	stays := append(append([]models.Reservation{}, m.bookedRooms(r)...), reservation)
+	for i := range stays {
+		stays[i].FirstName = reservation.FirstName
+		stays[i].LastName = reservation.LastName
+		stays[i].Phone = reservation.Phone
+		stays[i].Email = reservation.Email
+		// it stays pending until the deposit is authorized
+		stays[i].Status = models.StatusPending
+	}
 
-		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form:      form,
-			Data:      data,
-			StringMap: stringMap,
-		})
-		return
+	// a room may have been retired since it was chosen, and only the search checked that it fits the party
+	for _, res := range stays {
+		room, err := m.DB.GetRoomByID(res.RoomID)
+		if err != nil {
+			m.App.SessionManager.Put(r.Context(), "error", "Can't get room")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if !room.Active {
+			m.App.SessionManager.Put(r.Context(), "error", fmt.Sprintf("Sorry, %s is no longer available. Please search again", res.Room.RoomName))
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+		if !booking.Fits(room, res.Adults, res.Children) {
+			m.App.SessionManager.Put(r.Context(), "error", fmt.Sprintf("Sorry, %s doesn't fit your party. Please search again", res.Room.RoomName))
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+	}
+
+	// redeem the promo code, the stays it applies to are priced again with its discount
+	if code := booking.NormalizePromoCode(form.Get("promo_code")); code != "" {
+		promo, err := m.DB.GetPromoCodeByCode(code)
+		if errors.Is(err, repository.ErrNotFound) {
+			form.Errors.Add("promo_code", "Unknown promo code")
+			m.renderMakeReservation(w, r, reservation, form)
+			return
+		}
+		if err != nil {
+			m.App.SessionManager.Put(r.Context(), "error", "Can't check promo code")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		var reason string
+		redeemed := false
+		for i, res := range stays {
+			if why := booking.CheckPromoCode(promo, res, time.Now()); why != "" {
+				if reason == "" {
+					reason = why
+				}
+				continue
+			}
+
+			quote, err := m.quote(res, promo)
+			if err != nil {
+				m.App.SessionManager.Put(r.Context(), "error", "Can't calculate price")
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+			stays[i].PromoCodeID = promo.ID
+			stays[i].PromoCode = promo.Code
+			stays[i].Discount = quote.Discount
+			stays[i].TotalPrice = quote.Total
+			stays[i].Taxes = quote.Taxes
+			redeemed = true
+		}
+		if !redeemed {
+			form.Errors.Add("promo_code", reason)
+			m.renderMakeReservation(w, r, reservation, form)
+			return
+		}
 	}
 
-	// insert reservation to db
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	// insert the reservations and their room restrictions to db in a single transaction
+	stays, err = m.DB.CreateReservations(stays)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.SessionManager.Put(r.Context(), "error", "Sorry, this room was just booked by someone else for your dates. Please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	var ruleErr *repository.StayRuleError
+	if errors.As(err, &ruleErr) {
+		m.App.SessionManager.Put(r.Context(), "error", fmt.Sprintf("Sorry, %s can't be booked for your dates. %s",
+			ruleErr.Violation.RoomName, ruleErr.Violation.Reason))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, repository.ErrPromoCodeUsedUp) {
+		form.Errors.Add("promo_code", "This promo code has been used up")
+		m.renderMakeReservation(w, r, reservation, form)
+		return
+	}
 	if err != nil {
 		m.App.SessionManager.Put(r.Context(), "error", "Can't save reservation")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	// the rooms share the confirmation code of the first room
+	reservation = stays[0]
+
+	total := 0
+	for _, res := range stays {
+		total += res.TotalPrice
+	}
+
+	// take the deposit, a declined card releases the rooms again
+	reference, err := m.App.Payments.Authorize(payments.GroupDeposit(stays), strings.ReplaceAll(form.Get("card_number"), " ", ""))
+	if err != nil {
+		for _, res := range stays {
+			if cancelErr := m.DB.UpdateReservationStatus(res.ID, models.StatusPending, models.StatusCancelled); cancelErr != nil {
+				m.App.ErrorLog.Println(cancelErr)
+			}
+		}
 
-	roomRestriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+		if errors.Is(err, payments.ErrDeclined) {
+			form.Errors.Add("card_number", "Your card was declined, please use another card")
+			m.renderMakeReservation(w, r, stays[len(stays)-1], form)
+			return
+		}
+		m.App.SessionManager.Put(r.Context(), "error", "Can't take the deposit, please try again")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
 
-	// insert room restriction into db
-	err = m.DB.InsertRoomRestriction(roomRestriction)
+	// every room records its share of the deposit, so its balance, invoice and cancellation count what was paid for it
+	var authorizations []models.Payment
+	for i := 0; err == nil && i < len(stays); i++ {
+		authorization := models.Payment{
+			ReservationID: stays[i].ID,
+			Kind:          models.PaymentAuthorization,
+			Amount:        payments.Deposit(stays[i].TotalPrice),
+			Reference:     reference,
+		}
+		err = m.DB.InsertPayment(authorization)
+		if err == nil {
+			authorizations = append(authorizations, authorization)
+		}
+	}
+	for i := 0; err == nil && i < len(stays); i++ {
+		err = m.DB.UpdateReservationStatus(stays[i].ID, models.StatusPending, models.StatusConfirmed)
+		if err == nil {
+			stays[i].Status = models.StatusConfirmed
+		}
+	}
 	if err != nil {
-		m.App.SessionManager.Put(r.Context(), "error", "Can't save room reservation")
+		m.App.ErrorLog.Println(err)
+		m.abandonBooking(stays, reference, authorizations)
+		m.App.SessionManager.Put(r.Context(), "error", "Can't confirm reservation, the deposit was released")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	reservation = stays[0]
 
 	// send mail notifications - guest
+	var guestRooms, ownerRooms string
+	for _, res := range stays {
+		guestRooms += fmt.Sprintf(`
+		%s from %s to %s: %s%s%s <a href="%s">View or cancel</a><br>`,
+			res.Room.RoomName, res.StartDate.Format(constants.Layout), res.EndDate.Format(constants.Layout),
+			render.FormatMoney(res.TotalPrice), discountNote(res), taxBreakdown(res.Taxes), m.manageReservationURL(res))
+		ownerRooms += fmt.Sprintf(`
+		%s from %s to %s, confirmation code %s: %s%s%s<br>`,
+			res.Room.RoomName, res.StartDate.Format(constants.Layout), res.EndDate.Format(constants.Layout),
+			res.ConfirmationCode, render.FormatMoney(res.TotalPrice), discountNote(res), taxBreakdown(res.Taxes))
+	}
+
 	htmlMessage := fmt.Sprintf(`
 		<strong>Reservation Confirmation</strong><br>
 		Dear %s, <br>
-		This is confirm your reservaton from %s to %s.
-	`, reservation.FirstName, reservation.StartDate.Format(constants.Layout), reservation.EndDate.Format(constants.Layout))
+		This is confirm your reservaton of the following rooms.<br>
+		Confirmation code: <strong>%s</strong><br>%s
+		Total: %s<br>
+	`, reservation.FirstName, reservation.ConfirmationCode, guestRooms, render.FormatMoney(total))
 
 	msg := models.MailData{
 		To:           reservation.Email,
@@ -188,8 +471,9 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	// send mail notification top property owner
 	htmlMessage = fmt.Sprintf(`
 		<strong>Reservation Confirmation</strong><br>
-		A reservation has bee made for %s from %s to %s.
-	`, reservation.Room.RoomName, reservation.StartDate.Format(constants.Layout), reservation.EndDate.Format(constants.Layout))
+		A reservation has bee made for %s %s.<br>%s
+		Total: %s
+	`, reservation.FirstName, reservation.LastName, ownerRooms, render.FormatMoney(total))
 
 	msg = models.MailData{
 		To:           "me@there.com",
@@ -200,23 +484,137 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	}
 	m.App.MailChannel <- msg
 
+	m.App.SessionManager.Remove(r.Context(), "rooms")
 	m.App.SessionManager.Put(r.Context(), "reservation", reservation) // store reservation to the session
-	m.App.SessionManager.Put(r.Context(), "success", "Submit")        // push success alert
+	m.App.SessionManager.Put(r.Context(), "booked_rooms", stays)
+	m.App.SessionManager.Put(r.Context(), "success", "Submit") // push success alert
 
 	// redirect to another page, avoid submitting one more time
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 }
 
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// abandonBooking releases a deposit which was authorized for stays that could not be confirmed, and cancels the
+// stays so they don't keep blocking their rooms. The void is recorded for every share of the deposit which was
+func (m *Repository) abandonBooking(stays []models.Reservation, reference string, recorded []models.Payment) {
+	err := m.App.Payments.Void(reference)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	} else {
+		for _, authorization := range recorded {
+			void := authorization
+			void.Kind = models.PaymentVoid
+			if err = m.DB.InsertPayment(void); err != nil {
+				m.App.ErrorLog.Println(err)
+			}
+		}
+	}
+
+	for _, res := range stays {
+		if err = m.DB.UpdateReservationStatus(res.ID, res.Status, models.StatusCancelled); err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+}
+
+// quote prices a reservation with the rate plan of its room, the tax rules and the promo code
+func (m *Repository) quote(res models.Reservation, promo models.PromoCode) (pricing.Quote, error) {
+	room, err := m.DB.GetRoomByID(res.RoomID)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+	room.ID = res.RoomID
+
+	plan, err := m.DB.GetRatePlanForRoom(res.RoomID)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+
+	rules, err := m.DB.AllTaxRules()
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+	return pricing.Calculate(res, room, plan, rules, promo), nil
+}
+
+// discountNote names the promo code redeemed for a total in the notification emails, it is empty without discount
+func discountNote(res models.Reservation) string {
+	if res.Discount == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" after %s off with promo code %s", render.FormatMoney(res.Discount), res.PromoCode)
+}
+
+// taxBreakdown lists the taxes included in a total for the notification emails, it is empty without taxes
+func taxBreakdown(taxes []models.ReservationTax) string {
+	if len(taxes) == 0 {
+		return ""
+	}
+	parts := make([]string, len(taxes))
+	for i, t := range taxes {
+		parts[i] = fmt.Sprintf("%s %s", t.Name, render.FormatMoney(t.Amount))
+	}
+	return fmt.Sprintf(" (including %s)", strings.Join(parts, ", "))
+}
+
+// Rooms displays the list of rooms offered to guests
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+// Room displays the page of a single room, looked up by its slug
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	slug := exploded[2]
+
+	room, err := m.DB.GetRoomBySlug(slug)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't find room")
+		http.Redirect(w, r, "/rooms", http.StatusSeeOther)
+		return
+	}
+
+	photos, err := m.DB.GetPhotosForRoom(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["photos"] = photos
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
+	m.renderAvailability(w, r, forms.New(nil))
+}
+
+// renderAvailability shows the search form, with the waitlist form when the last search found no rooms
+func (m *Repository) renderAvailability(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["rooms"] = m.bookedRooms(r)
+	if search, ok := m.App.SessionManager.Get(r.Context(), "waitlist").(models.Reservation); ok {
+		data["waitlist"] = search
+	}
+
+	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
 }
 
 func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
@@ -243,7 +641,36 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	adults, children := 1, 0
+	if r.Form.Get("adults") != "" {
+		adults, err = strconv.Atoi(r.Form.Get("adults"))
+		if err != nil || adults < 1 {
+			m.App.SessionManager.Put(r.Context(), "error", "can't parse number of adults!")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+	}
+	if r.Form.Get("children") != "" {
+		children, err = strconv.Atoi(r.Form.Get("children"))
+		if err != nil || children < 0 {
+			m.App.SessionManager.Put(r.Context(), "error", "can't parse number of children!")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+	}
+
+	// the room chosen before is no longer held while the guest searches again
+	if search, ok := m.App.SessionManager.Get(r.Context(), "reservation").(models.Reservation); ok {
+		m.releaseHold(search)
+	}
+
+	// a flexible search looks for free stays around the requested dates instead
+	if flexibility := r.Form.Get("flexibility"); flexibility != "" && flexibility != "exact" {
+		m.flexibleAvailability(w, r, startDate, endDate, adults, children, flexibility)
+		return
+	}
+
+	rooms, violations, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate, adults, children)
 	if err != nil {
 		m.App.SessionManager.Put(r.Context(), "error", "can't get availability for rooms")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -251,18 +678,38 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(rooms) == 0 {
-		// no availability
-		m.App.SessionManager.Put(r.Context(), "error", "No Availability")
+		// no availability, explain the stay rules which turned down the free rooms
+		msg := "No Availability"
+		if len(violations) > 0 {
+			var reasons []string
+			for _, v := range violations {
+				reasons = append(reasons, fmt.Sprintf("%s: %s", v.RoomName, v.Reason))
+			}
+			msg = fmt.Sprintf("No Availability. %s", strings.Join(reasons, ". "))
+		}
+		m.App.SessionManager.Put(r.Context(), "error", msg)
+
+		// offer to wait for a room to become free for the stay
+		m.App.SessionManager.Put(r.Context(), "waitlist", models.Reservation{
+			StartDate: startDate,
+			EndDate:   endDate,
+			Adults:    adults,
+			Children:  children,
+		})
 		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
+	m.App.SessionManager.Remove(r.Context(), "waitlist")
 
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["violations"] = violations
 
 	reservation := models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
 	}
 
 	m.App.SessionManager.Put(r.Context(), "reservation", reservation)
@@ -272,6 +719,95 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// maxFlexibleDays is the furthest a flexible search moves the arrival away from the requested one
+const maxFlexibleDays = 7
+
+// flexibleStaysPerRoom is the number of stays a flexible search offers per room
+const flexibleStaysPerRoom = 3
+
+// flexibleAvailability offers the free stays closest to the requested dates, per room. The flexibility is either
+// a number of days the arrival may move in both directions, or "month" for arrivals on the same weekday in the month
+// of the requested arrival, e.g. any weekend. The stay keeps the requested length, unless nights are given
+func (m *Repository) flexibleAvailability(w http.ResponseWriter, r *http.Request, startDate, endDate time.Time, adults, children int, flexibility string) {
+	nights := int(endDate.Sub(startDate).Hours() / 24)
+	if r.Form.Get("nights") != "" {
+		var err error
+		nights, err = strconv.Atoi(r.Form.Get("nights"))
+		if err != nil {
+			nights = 0
+		}
+	}
+	if nights < 1 {
+		m.App.SessionManager.Put(r.Context(), "error", "can't parse length of stay!")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	var arrivals []time.Time
+	if flexibility == "month" {
+		arrivals = booking.ArrivalsInMonth(startDate, today)
+	} else {
+		days, err := strconv.Atoi(flexibility)
+		if err != nil || days < 1 || days > maxFlexibleDays {
+			m.App.SessionManager.Put(r.Context(), "error", "can't parse flexibility!")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+		arrivals = booking.ArrivalsAround(startDate, days, today)
+	}
+	if len(arrivals) == 0 {
+		m.App.SessionManager.Put(r.Context(), "error", "No Availability")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	// the gaps and rules are loaded once for the whole window
+	from := arrivals[0]
+	to := arrivals[len(arrivals)-1].AddDate(0, 0, nights)
+
+	gaps, err := m.DB.SearchFreeGaps(from, to, nights, adults, children)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "can't get availability for rooms")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	rules, err := m.DB.GetStayRulesForRoom(0, from, to)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "can't get availability for rooms")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	found := booking.FindStays(gaps, rules, arrivals, nights, startDate, flexibleStaysPerRoom)
+	if len(found) == 0 {
+		m.App.SessionManager.Put(r.Context(), "error", "No Availability")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	// keep the party, the stay is picked on the next page
+	reservation := models.Reservation{
+		StartDate: startDate,
+		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
+	}
+	m.App.SessionManager.Put(r.Context(), "reservation", reservation)
+
+	data := make(map[string]interface{})
+	data["rooms"] = found
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = startDate.Format(constants.Layout)
+	stringMap["nights"] = strconv.Itoa(nights)
+
+	render.Template(w, r, "choose-stay.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
 func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -290,27 +826,48 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	sd := r.Form.Get("start")
 	ed := r.Form.Get("end")
 
-	startDate, _ := time.Parse(constants.Layout, sd)
-	endDate, _ := time.Parse(constants.Layout, ed)
-
-	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
-
-	available, err := m.DB.SearchAvailabilityByRoomIDAndDates(startDate, endDate, roomID)
+	startDate, err := time.Parse(constants.Layout, sd)
 	if err != nil {
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Error connecting to database",
-		}
-
-		out, _ := json.Marshal(resp)
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+		writeJSONError(w, http.StatusBadRequest, "Invalid arrival date")
 		return
 	}
-
+	endDate, err := time.Parse(constants.Layout, ed)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid departure date")
+		return
+	}
+	if !endDate.After(startDate) {
+		writeJSONError(w, http.StatusBadRequest, "Departure must be after arrival")
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid room id")
+		return
+	}
+
+	available, violation, err := m.DB.SearchAvailabilityByRoomIDAndDates(startDate, endDate, roomID)
+	if err != nil {
+		resp := jsonResponse{
+			OK:      false,
+			Message: "Error connecting to database",
+		}
+
+		out, _ := json.Marshal(resp)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+
+	message := ""
+	if violation != nil {
+		message = violation.Reason
+	}
+
 	resp := jsonResponse{
 		OK:        available,
-		Message:   "",
+		Message:   message,
 		StartDate: sd,
 		EndDate:   ed,
 		RoomID:    strconv.Itoa(roomID),
@@ -322,6 +879,123 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	w.Write(out)
 }
 
+// maxCalendarNights is the longest range the room availability calendar answers for
+const maxCalendarNights = 366
+
+// calendarNight is one night of the room availability calendar, the price is in cents
+type calendarNight struct {
+	Date              string              `json:"date"`
+	Status            booking.NightStatus `json:"status"`
+	Price             int                 `json:"price"`
+	ClosedToArrival   bool                `json:"closed_to_arrival"`
+	ClosedToDeparture bool                `json:"closed_to_departure"`
+	MinStay           int                 `json:"min_stay,omitempty"`
+	MaxStay           int                 `json:"max_stay,omitempty"`
+}
+
+// roomCalendar is the response of the room availability calendar
+type roomCalendar struct {
+	OK     bool            `json:"ok"`
+	RoomID int             `json:"room_id"`
+	From   string          `json:"from"`
+	To     string          `json:"to"`
+	Nights []calendarNight `json:"nights"`
+}
+
+// RoomAvailabilityJSON returns the availability and price of a room for every night from the from date
+// up to, but not including, the to date, e.g. to grey out the unavailable nights of a date picker
+func (m *Repository) RoomAvailabilityJSON(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid room id")
+		return
+	}
+
+	from, err := time.Parse(constants.Layout, r.URL.Query().Get("from"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid from date, expected yyyy-mm-dd")
+		return
+	}
+	to, err := time.Parse(constants.Layout, r.URL.Query().Get("to"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid to date, expected yyyy-mm-dd")
+		return
+	}
+	if !to.After(from) {
+		writeJSONError(w, http.StatusBadRequest, "The to date must be after the from date")
+		return
+	}
+	if to.After(from.AddDate(0, 0, maxCalendarNights)) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("At most %d nights can be requested at once", maxCalendarNights))
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		writeJSONError(w, http.StatusInternalServerError, "Error connecting to database")
+		return
+	}
+	// retired rooms can't be booked, so they have no calendar either
+	if err != nil || !room.Active {
+		writeJSONError(w, http.StatusNotFound, "Room not found")
+		return
+	}
+	room.ID = roomID
+
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(roomID, from, to)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error connecting to database")
+		return
+	}
+	rules, err := m.DB.GetStayRulesForRoom(roomID, from, to)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error connecting to database")
+		return
+	}
+	plan, err := m.DB.GetRatePlanForRoom(roomID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Error connecting to database")
+		return
+	}
+
+	resp := roomCalendar{
+		OK:     true,
+		RoomID: roomID,
+		From:   from.Format(constants.Layout),
+		To:     to.Format(constants.Layout),
+	}
+	for _, night := range booking.Calendar(from, to, restrictions, rules) {
+		resp.Nights = append(resp.Nights, calendarNight{
+			Date:              night.Date.Format(constants.Layout),
+			Status:            night.Status,
+			Price:             pricing.NightlyPrice(night.Date, room, plan),
+			ClosedToArrival:   night.ClosedToArrival,
+			ClosedToDeparture: night.ClosedToDeparture,
+			MinStay:           night.MinStay,
+			MaxStay:           night.MaxStay,
+		})
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "     ")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// writeJSONError answers a JSON request with the status code and a message
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	resp := jsonResponse{
+		OK:      false,
+		Message: message,
+	}
+
+	out, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
 func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
 }
@@ -338,21 +1012,27 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// all rooms booked together, the first of which is the reservation
+	rooms, ok := m.App.SessionManager.Pop(r.Context(), "booked_rooms").([]models.Reservation)
+	if !ok {
+		rooms = []models.Reservation{reservation}
+	}
+
 	m.App.SessionManager.Remove(r.Context(), "reservation") // remove session data for reservation
 
+	total := 0
+	for _, res := range rooms {
+		total += res.TotalPrice
+	}
+
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
-
-	// format date to display
-	sd := reservation.StartDate.Format(constants.Layout)
-	ed := reservation.EndDate.Format(constants.Layout)
-	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
+	data["rooms"] = rooms
+	data["total"] = total
+	data["deposit"] = payments.GroupDeposit(rooms)
 
 	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
-		Data:      data,
-		StringMap: stringMap,
+		Data: data,
 	})
 }
 
@@ -374,24 +1054,114 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't get room from database")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if !room.Active {
+		m.App.SessionManager.Put(r.Context(), "error", "This room is no longer available")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if !booking.Fits(room, reservation.Adults, reservation.Children) {
+		m.App.SessionManager.Put(r.Context(), "error", "This room doesn't fit your party")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
 	reservation.RoomID = roomID
+	err = m.holdRoom(&reservation)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.SessionManager.Put(r.Context(), "error", "Sorry, this room was just taken by someone else for your dates. Please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't hold room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 	m.App.SessionManager.Put(r.Context(), "reservation", reservation)
 
 	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 }
 
+// holdDuration is how long a chosen room is held for the guest to make the reservation
+const holdDuration = 15 * time.Minute
+
+// holdRoom holds the room of the reservation for its dates, so nobody else can book it while the guest fills in
+// the reservation. The hold placed for the reservation before is released
+func (m *Repository) holdRoom(res *models.Reservation) error {
+	m.releaseHold(*res)
+	res.HoldToken = ""
+	res.HoldExpiresAt = time.Time{}
+
+	token, err := helpers.RandomHex(16)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(holdDuration)
+
+	err = m.DB.PlaceHold(models.RoomRestriction{
+		RoomID:    res.RoomID,
+		StartDate: res.StartDate,
+		EndDate:   res.EndDate,
+		HoldToken: token,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	res.HoldToken = token
+	res.HoldExpiresAt = expiresAt
+	return nil
+}
+
+// releaseHold releases the hold of the reservation, if it has one. A hold which can't be released expires anyway
+func (m *Repository) releaseHold(res models.Reservation) {
+	if res.HoldToken == "" {
+		return
+	}
+	if err := m.DB.ReleaseHold(res.HoldToken); err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
 func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
 	sd := r.URL.Query().Get("s")
 	ed := r.URL.Query().Get("e")
 
-	startDate, _ := time.Parse(constants.Layout, sd)
-	endDate, _ := time.Parse(constants.Layout, ed)
+	startDate, err := time.Parse(constants.Layout, sd)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't parse start date")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	endDate, err := time.Parse(constants.Layout, ed)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't parse end date")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if !endDate.After(startDate) {
+		m.App.SessionManager.Put(r.Context(), "error", "Departure must be after arrival")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
 	room, err := m.DB.GetRoomByID(roomID)
 	if err != nil {
 		m.App.SessionManager.Put(r.Context(), "error", "Can't get room from database")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if !room.Active {
+		m.App.SessionManager.Put(r.Context(), "error", "This room is no longer available")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
 
 	var reservation models.Reservation
@@ -399,6 +1169,33 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	reservation.RoomID = roomID
 	reservation.StartDate = startDate
 	reservation.EndDate = endDate
+	reservation.Adults = 1
+
+	// keep the party of the search the stay was picked from, the room held before is released
+	if search, ok := m.App.SessionManager.Get(r.Context(), "reservation").(models.Reservation); ok {
+		if search.Adults > 0 {
+			reservation.Adults = search.Adults
+			reservation.Children = search.Children
+		}
+		reservation.HoldToken = search.HoldToken
+	}
+	if !booking.Fits(room, reservation.Adults, reservation.Children) {
+		m.App.SessionManager.Put(r.Context(), "error", "This room doesn't fit your party")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	err = m.holdRoom(&reservation)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.SessionManager.Put(r.Context(), "error", "Sorry, this room was just taken by someone else for your dates. Please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't hold room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
 	m.App.SessionManager.Put(r.Context(), "reservation", reservation)
 
@@ -441,96 +1238,503 @@ func (m *Repository) PostLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, err := m.DB.GetUserByID(id)
+	if err != nil {
+		log.Println(err)
+		m.App.SessionManager.Put(r.Context(), "error", "Can't log you in")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	if !user.IsStaff() && user.EmailVerifiedAt.IsZero() {
+		m.App.SessionManager.Put(r.Context(), "error", "Please verify your email address with the link we sent you first")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
 	m.App.SessionManager.Put(r.Context(), "user_id", id)
+	m.App.SessionManager.Put(r.Context(), "access_level", user.AccessLevel)
 	m.App.SessionManager.Put(r.Context(), "success", "Logged in successfully")
+	if !user.IsStaff() {
+		http.Redirect(w, r, "/account/bookings", http.StatusSeeOther)
+		return
+	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.SessionManager.Destroy(r.Context())
-	_ = m.App.SessionManager.RenewToken(r.Context())
+// verifyEmailPurpose is the purpose of the signed tokens in the links guests verify their email address with
+const verifyEmailPurpose = "verify-email"
 
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-}
+// verifyEmailDuration is how long a guest can use the link to verify their email address
+const verifyEmailDuration = 48 * time.Hour
 
-func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin/admin-dashboard.page.tmpl", &models.TemplateData{})
+// ShowRegister shows the page guests create an account on
+func (m *Repository) ShowRegister(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "register.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
 }
 
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+// PostRegister creates a guest account and emails the link to verify its email address with
+func (m *Repository) PostRegister(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.SessionManager.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/user/register", http.StatusSeeOther)
 		return
 	}
 
-	data := make(map[string]interface{})
-	data["reservations"] = reservations
-
-	render.Template(w, r, "admin/admin-new-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
-}
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "password", "password_confirm")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+	form.IsStrongPassword("password")
+	form.Matches("password_confirm", "password")
+	if !form.Valid() {
+		render.Template(w, r, "register.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
 
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	user := models.User{
+		FirstName:   r.Form.Get("first_name"),
+		LastName:    r.Form.Get("last_name"),
+		Email:       r.Form.Get("email"),
+		Password:    r.Form.Get("password"),
+		AccessLevel: int(models.RoleGuest),
+	}
+	id, err := m.DB.InsertUser(user)
+	if errors.Is(err, repository.ErrDuplicateEmail) {
+		form.Errors.Add("email", "An account with this email already exists")
+		render.Template(w, r, "register.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.ErrorLog.Println(err)
+		m.App.SessionManager.Put(r.Context(), "error", "Can't create your account")
+		http.Redirect(w, r, "/user/register", http.StatusSeeOther)
 		return
 	}
 
-	data := make(map[string]interface{})
-	data["reservations"] = reservations
+	token := m.App.Signer.Sign(verifyEmailPurpose, id, time.Now().Add(verifyEmailDuration))
+	htmlMessage := fmt.Sprintf(`
+		<strong>Verify your email</strong><br>
+		Dear %s, <br>
+		Thank you for creating an account. Please verify your email address by following
+		<a href="%s/user/verify/%s">this link</a> within the next 48 hours.
+	`, user.FirstName, m.App.BaseURL, token)
 
-	render.Template(w, r, "admin/admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
+	msg := models.MailData{
+		To:           user.Email,
+		From:         "me@here.com",
+		Subject:      "Verify your email",
+		Content:      htmlMessage,
+		TemplateMail: "basic.html",
+	}
+	m.App.MailChannel <- msg
+
+	m.App.SessionManager.Put(r.Context(), "success", "Your account is created, please check your email to verify your address")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
-func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+// VerifyEmail marks the email address of an account as verified when its owner follows the signed link
+func (m *Repository) VerifyEmail(w http.ResponseWriter, r *http.Request) {
 	exploded := strings.Split(r.RequestURI, "/")
-	id, err := strconv.Atoi(exploded[4])
+	token := exploded[3]
+
+	id, err := m.App.Signer.Verify(verifyEmailPurpose, token)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.SessionManager.Put(r.Context(), "error", "This link is invalid or has expired")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
 
-	src := exploded[3]
-
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
-
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
-
-	stringMap["month"] = month
-	stringMap["year"] = year
-
-	// get reservation from the db
-	res, err := m.DB.GetReservationByID(id)
+	err = m.DB.VerifyUserEmail(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		m.App.SessionManager.Put(r.Context(), "error", "This link is invalid or has expired")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.ErrorLog.Println(err)
+		m.App.SessionManager.Put(r.Context(), "error", "Can't verify your email")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	data := make(map[string]interface{})
-	data["reservation"] = res
+	m.App.SessionManager.Put(r.Context(), "success", "Your email is verified, you can log in now")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
 
-	render.Template(w, r, "admin/admin-reservations-show.page.tmpl", &models.TemplateData{
-		StringMap: stringMap,
-		Data:      data,
-		Form:      forms.New(nil),
+// passwordResetDuration is how long a user can use the link to reset their password
+const passwordResetDuration = time.Hour
+
+// ShowForgotPassword shows the page users ask for a link to reset their password on
+func (m *Repository) ShowForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
 	})
 }
 
-func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+// PostForgotPassword emails a one-time link to reset the password to the user registered with the email address.
+// Only the hash of the token in the link is stored. The answer is the same whether the address is registered or not,
+// so the page can't be used to find out who has an account
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.SessionManager.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
 		return
 	}
 
-	exploded := strings.Split(r.RequestURI, "/")
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	sent := "If an account exists for this email address, we have sent it a link to reset the password"
+
+	user, err := m.DB.GetUserByEmail(r.Form.Get("email"))
+	if errors.Is(err, repository.ErrNotFound) {
+		m.App.SessionManager.Put(r.Context(), "success", sent)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.SessionManager.Put(r.Context(), "error", "Can't send the link to reset your password")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	token, err := helpers.RandomHex(32)
+	if err == nil {
+		err = m.DB.InsertPasswordReset(user.ID, tokens.Hash(token), time.Now().Add(passwordResetDuration))
+	}
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.SessionManager.Put(r.Context(), "error", "Can't send the link to reset your password")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	htmlMessage := fmt.Sprintf(`
+		<strong>Reset your password</strong><br>
+		Dear %s, <br>
+		Somebody asked to reset the password of your account. You can choose a new password by following
+		<a href="%s/user/reset-password/%s">this link</a> within the next hour, it works once only.
+		If it wasn't you, you can ignore this email and your password stays the same.
+	`, user.FirstName, m.App.BaseURL, token)
+
+	msg := models.MailData{
+		To:           user.Email,
+		From:         "me@here.com",
+		Subject:      "Reset your password",
+		Content:      htmlMessage,
+		TemplateMail: "basic.html",
+	}
+	m.App.MailChannel <- msg
+
+	m.App.SessionManager.Put(r.Context(), "success", sent)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// ShowResetPassword shows the page to choose a new password on, for users following the link sent to them
+func (m *Repository) ShowResetPassword(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	token := exploded[3]
+
+	_, err := m.DB.GetPasswordResetUserID(tokens.Hash(token), time.Now())
+	if errors.Is(err, repository.ErrNotFound) {
+		m.App.SessionManager.Put(r.Context(), "error", "This link is invalid or has expired, please ask for a new one")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.SessionManager.Put(r.Context(), "error", "Can't reset your password")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	m.renderResetPassword(w, r, token, forms.New(nil))
+}
+
+// PostResetPassword sets the new password of the user the link was sent to, and logs the user out everywhere
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	token := exploded[3]
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/user/reset-password/"+token, http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password", "password_confirm")
+	form.IsStrongPassword("password")
+	form.Matches("password_confirm", "password")
+	if !form.Valid() {
+		m.renderResetPassword(w, r, token, form)
+		return
+	}
+
+	userID, err := m.DB.ResetPassword(tokens.Hash(token), r.Form.Get("password"), time.Now())
+	if errors.Is(err, repository.ErrNotFound) {
+		m.App.SessionManager.Put(r.Context(), "error", "This link is invalid or has expired, please ask for a new one")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.SessionManager.Put(r.Context(), "error", "Can't reset your password")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err = m.logOutEverywhere(userID)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+
+	// the session of this request is saved again after the handler, so it is logged out separately
+	m.App.SessionManager.Remove(r.Context(), "user_id")
+	m.App.SessionManager.Remove(r.Context(), "access_level")
+	_ = m.App.SessionManager.RenewToken(r.Context())
+
+	m.App.SessionManager.Put(r.Context(), "success", "Your password is reset, you can log in with the new one")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// renderResetPassword shows the page to choose a new password on, with the form posting back to the same link
+func (m *Repository) renderResetPassword(w http.ResponseWriter, r *http.Request, token string, form *forms.Form) {
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+
+	render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// logOutEverywhere destroys every session the user is logged in with
+func (m *Repository) logOutEverywhere(userID int) error {
+	return m.App.SessionManager.Iterate(context.Background(), func(ctx context.Context) error {
+		if m.App.SessionManager.GetInt(ctx, "user_id") != userID {
+			return nil
+		}
+		return m.App.SessionManager.Destroy(ctx)
+	})
+}
+
+// AccountBookings shows the logged in guest the reservations made with their email address, upcoming stays first
+func (m *Repository) AccountBookings(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserByID(m.App.SessionManager.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.SessionManager.Put(r.Context(), "error", "Can't get your account")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	reservations, err := m.DB.GetReservationsForAccount(booking.NormalizeEmail(user.Email))
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.SessionManager.Put(r.Context(), "error", "Can't get your bookings")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	var upcoming, past []models.Reservation
+	managePaths := make(map[int]string)
+	for _, res := range reservations {
+		if res.EndDate.After(today) {
+			upcoming = append(upcoming, res)
+			managePaths[res.ID] = m.manageReservationPath(res)
+		} else {
+			past = append(past, res)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["user"] = user
+	data["upcoming"] = upcoming
+	data["past"] = past
+	data["manage_paths"] = managePaths
+
+	render.Template(w, r, "account-bookings.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.SessionManager.Destroy(r.Context())
+	_ = m.App.SessionManager.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "admin/admin-dashboard.page.tmpl", &models.TemplateData{})
+}
+
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllNewReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+
+	render.Template(w, r, "admin/admin-new-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	// filter by status, unknown statuses show all reservations
+	var status models.ReservationStatus
+	for _, s := range models.ReservationStatuses {
+		if string(s) == r.URL.Query().Get("status") {
+			status = s
+		}
+	}
+
+	reservations, err := m.DB.AllReservations(status)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["status"] = status
+	data["statuses"] = models.ReservationStatuses
+
+	render.Template(w, r, "admin/admin-all-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminLookupReservation finds a reservation by its confirmation code, as read out by a guest, and shows it
+func (m *Repository) AdminLookupReservation(w http.ResponseWriter, r *http.Request) {
+	code := booking.NormalizeConfirmationCode(r.URL.Query().Get("code"))
+	if code == "" {
+		m.App.SessionManager.Put(r.Context(), "error", "Please enter a confirmation code")
+		http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+		return
+	}
+
+	res, err := m.DB.GetReservationByCode(code)
+	if errors.Is(err, repository.ErrNotFound) {
+		m.App.SessionManager.Put(r.Context(), "error", fmt.Sprintf("No reservation found with confirmation code %s", code))
+		http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/all/%d/show", res.ID), http.StatusSeeOther)
+}
+
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := exploded[3]
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	stringMap["month"] = month
+	stringMap["year"] = year
+
+	// get reservation from the db
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	statusChanges, err := m.DB.GetStatusChangesForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	history, err := m.DB.GetPaymentsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["next_statuses"] = booking.NextStatuses(res.Status)
+	data["status_changes"] = statusChanges
+	data["payments"] = history
+	data["balance"] = payments.Summarize(history)
+	data["can_cancel"] = booking.Transition(res.Status, models.StatusCancelled) == nil
+	data["can_invoice"] = res.Status != models.StatusPending
+
+	// the other rooms booked together with this one
+	if res.GroupID != 0 {
+		group, err := m.DB.GetReservationsInGroup(res.GroupID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["group"] = group
+	}
+
+	if res.Status == models.StatusCancelled {
+		cancellation, err := m.DB.GetCancellationForReservation(id)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			helpers.ServerError(w, err)
+			return
+		}
+		if err == nil {
+			data["cancellation"] = cancellation
+		}
+	}
+
+	render.Template(w, r, "admin/admin-reservations-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[4])
 	if err != nil {
 		helpers.ServerError(w, err)
@@ -621,6 +1825,7 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 	for _, x := range rooms {
 		reservationMap := make(map[string]int)
 		blockMap := make(map[string]int)
+		holdMap := make(map[string]string)
 
 		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
 			reservationMap[d.Format(constants.Layout)] = 0
@@ -640,6 +1845,11 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
 					reservationMap[d.Format(constants.LayoutCalendar)] = y.ReservationID
 				}
+			} else if y.RestrictionID == models.RestrictionHold {
+				// it's a guest holding the room while making a reservation, until the hold expires
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+					holdMap[d.Format(constants.LayoutCalendar)] = y.ExpiresAt.Format("15:04")
+				}
 			} else {
 				// it's a block
 				blockMap[y.StartDate.Format(constants.LayoutCalendar)] = y.ID
@@ -647,6 +1857,7 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 		}
 		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
 		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+		data[fmt.Sprintf("hold_map_%d", x.ID)] = holdMap
 
 		m.App.SessionManager.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
 	}
@@ -658,7 +1869,8 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 	})
 }
 
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+// AdminUpdateReservationStatus moves a reservation to another status of its lifecycle
+func (m *Repository) AdminUpdateReservationStatus(w http.ResponseWriter, r *http.Request) {
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[4])
 	if err != nil {
@@ -667,10 +1879,32 @@ func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Requ
 	}
 
 	src := exploded[3]
+	to := models.ReservationStatus(exploded[5])
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
+	var transitionErr *booking.TransitionError
+	err = booking.Transition(res.Status, to)
+	if err == nil {
+		err = m.DB.UpdateReservationStatus(id, res.Status, to)
+	}
 
-	m.App.SessionManager.Put(r.Context(), "success", "Reservation marked as processed")
+	switch {
+	case errors.As(err, &transitionErr):
+		m.App.SessionManager.Put(r.Context(), "error",
+			fmt.Sprintf("A %s reservation can't be changed to %s", transitionErr.From.Label(), transitionErr.To.Label()))
+	case errors.Is(err, repository.ErrStatusChanged):
+		m.App.SessionManager.Put(r.Context(), "error", "The reservation was changed by someone else, please try again")
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.App.SessionManager.Put(r.Context(), "success", fmt.Sprintf("Reservation marked as %s", to.Label()))
+	}
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
@@ -681,7 +1915,18 @@ func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Requ
 	}
 }
 
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+// reservationShowURL returns the admin page of a reservation, keeping the calendar month it was opened from
+func reservationShowURL(r *http.Request, src string, id int) string {
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+	if year == "" {
+		return fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+	}
+	return fmt.Sprintf("/admin/reservations/%s/%d/show?y=%s&m=%s", src, id, year, month)
+}
+
+// AdminPostCapturePayment collects the authorized deposit of a reservation
+func (m *Repository) AdminPostCapturePayment(w http.ResponseWriter, r *http.Request) {
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[4])
 	if err != nil {
@@ -691,26 +1936,313 @@ func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Reque
 
 	src := exploded[3]
 
-	_ = m.DB.DeleteReservation(id)
-	m.App.SessionManager.Put(r.Context(), "success", "Reservation deleted")
+	history, err := m.DB.GetPaymentsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	balance := payments.Summarize(history)
+	if balance.Capturable() <= 0 {
+		m.App.SessionManager.Put(r.Context(), "error", "There is nothing to capture")
+		http.Redirect(w, r, reservationShowURL(r, src, id), http.StatusSeeOther)
+		return
 	}
-}
 
-func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+	err = m.App.Payments.Capture(balance.Reference, balance.Capturable())
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.SessionManager.Put(r.Context(), "error", fmt.Sprintf("The payment gateway refused the capture: %s", err))
+		http.Redirect(w, r, reservationShowURL(r, src, id), http.StatusSeeOther)
 		return
 	}
 
-	year, _ := strconv.Atoi(r.Form.Get("y"))
+	err = m.DB.InsertPayment(models.Payment{
+		ReservationID: id,
+		Kind:          models.PaymentCapture,
+		Amount:        balance.Capturable(),
+		Reference:     balance.Reference,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", fmt.Sprintf("Captured %s", render.FormatMoney(balance.Capturable())))
+	http.Redirect(w, r, reservationShowURL(r, src, id), http.StatusSeeOther)
+}
+
+// AdminPostRefundPayment pays back part or all of the captured payments of a reservation
+func (m *Repository) AdminPostRefundPayment(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := exploded[3]
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	history, err := m.DB.GetPaymentsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	balance := payments.Summarize(history)
+
+	form := forms.New(r.PostForm)
+	form.Required("amount")
+	form.IsMoney("amount")
+
+	amount, _ := helpers.ParseMoney(form.Get("amount"))
+	if form.Valid() && (amount <= 0 || amount > balance.Refundable()) {
+		form.Errors.Add("amount", fmt.Sprintf("Refunds must be between 0.01 and %s", render.FormatMoney(balance.Refundable())))
+	}
+
+	if !form.Valid() {
+		m.App.SessionManager.Put(r.Context(), "error", fmt.Sprintf("Can't refund: %s", form.Errors.Get("amount")))
+		http.Redirect(w, r, reservationShowURL(r, src, id), http.StatusSeeOther)
+		return
+	}
+
+	err = m.App.Payments.Refund(balance.Reference, amount)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", fmt.Sprintf("The payment gateway refused the refund: %s", err))
+		http.Redirect(w, r, reservationShowURL(r, src, id), http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.InsertPayment(models.Payment{
+		ReservationID: id,
+		Kind:          models.PaymentRefund,
+		Amount:        amount,
+		Reference:     balance.Reference,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", fmt.Sprintf("Refunded %s", render.FormatMoney(amount)))
+	http.Redirect(w, r, reservationShowURL(r, src, id), http.StatusSeeOther)
+}
+
+// AdminDeleteReservation shows what cancelling a reservation costs under the cancellation policy of its room,
+// before the cancellation is confirmed
+func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := exploded[3]
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if err = booking.Transition(res.Status, models.StatusCancelled); err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", fmt.Sprintf("A %s reservation can't be cancelled", res.Status.Label()))
+		http.Redirect(w, r, reservationShowURL(r, src, id), http.StatusSeeOther)
+		return
+	}
+
+	policy, err := m.DB.GetCancellationPolicyForRoom(res.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	history, err := m.DB.GetPaymentsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	outcome := booking.EvaluateCancellation(res, policy, time.Now())
+	paid := payments.Summarize(history).Refundable()
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["policy"] = policy
+	data["outcome"] = outcome
+	data["paid"] = paid
+	data["refundable"] = outcome.Refundable(paid)
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+	stringMap["year"] = r.URL.Query().Get("y")
+	stringMap["month"] = r.URL.Query().Get("m")
+
+	render.Template(w, r, "admin/admin-cancel-reservation.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminPostDeleteReservation cancels a reservation and records the outcome of its cancellation policy
+func (m *Repository) AdminPostDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := exploded[3]
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	outcome, err := m.cancelReservation(res)
+
+	var transitionErr *booking.TransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		m.App.SessionManager.Put(r.Context(), "error", fmt.Sprintf("A %s reservation can't be cancelled", res.Status.Label()))
+	case errors.Is(err, repository.ErrStatusChanged):
+		m.App.SessionManager.Put(r.Context(), "error", "The reservation was changed by someone else, please try again")
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.App.SessionManager.Put(r.Context(), "success",
+			fmt.Sprintf("Reservation cancelled with a fee of %s", render.FormatMoney(outcome.Fee)))
+	}
+
+	http.Redirect(w, r, reservationShowURL(r, src, id), http.StatusSeeOther)
+}
+
+// cancelReservation applies the cancellation policy of the room to a reservation cancelled now,
+// then cancels the reservation and records the outcome
+func (m *Repository) cancelReservation(res models.Reservation) (booking.CancellationOutcome, error) {
+	var outcome booking.CancellationOutcome
+
+	err := booking.Transition(res.Status, models.StatusCancelled)
+	if err != nil {
+		return outcome, err
+	}
+
+	policy, err := m.DB.GetCancellationPolicyForRoom(res.RoomID)
+	if err != nil {
+		return outcome, err
+	}
+
+	outcome = booking.EvaluateCancellation(res, policy, time.Now())
+	err = m.DB.CancelReservation(models.Cancellation{
+		ReservationID: res.ID,
+		PolicyName:    policy.Name,
+		HoursBefore:   outcome.HoursBefore,
+		FeePercent:    outcome.FeePercent,
+		Fee:           outcome.Fee,
+	}, res.Status)
+	if err != nil {
+		return outcome, err
+	}
+
+	// the room is free again for the dates
+	m.notifyWaitlist(res.RoomID, res.StartDate, res.EndDate)
+	return outcome, nil
+}
+
+// AdminReservationInvoice sends the invoice of a reservation as PDF, issuing it on first request
+func (m *Repository) AdminReservationInvoice(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if res.Status == models.StatusPending {
+		m.App.SessionManager.Put(r.Context(), "error", "An invoice can't be issued before the reservation is confirmed")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/all/%d/show", id), http.StatusSeeOther)
+		return
+	}
+
+	inv, err := m.issueInvoice(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	writeInvoice(w, inv)
+}
+
+// issueInvoice returns the invoice of a reservation. The first time it is asked for, the invoice is built from the
+// current charges and payments and stored, so later copies show the same number and lines
+func (m *Repository) issueInvoice(res models.Reservation) (models.Invoice, error) {
+	inv, err := m.DB.GetInvoiceForReservation(res.ID)
+	if !errors.Is(err, repository.ErrNotFound) {
+		return inv, err
+	}
+
+	room, err := m.DB.GetRoomByID(res.RoomID)
+	if err != nil {
+		return inv, err
+	}
+
+	plan, err := m.DB.GetRatePlanForRoom(res.RoomID)
+	if err != nil {
+		return inv, err
+	}
+
+	history, err := m.DB.GetPaymentsForReservation(res.ID)
+	if err != nil {
+		return inv, err
+	}
+
+	var cancellation *models.Cancellation
+	if res.Status == models.StatusCancelled {
+		c, err := m.DB.GetCancellationForReservation(res.ID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return inv, err
+		}
+		if err == nil {
+			cancellation = &c
+		}
+	}
+
+	// the taxes are taken from the reservation as charged, only the nights are priced again
+	return m.DB.InsertInvoice(invoices.Build(res, pricing.Calculate(res, room, plan, nil, models.PromoCode{}), history, cancellation))
+}
+
+// writeInvoice sends the invoice as PDF, to be shown in the browser
+func writeInvoice(w http.ResponseWriter, inv models.Invoice) {
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, inv.Label()))
+	_, err := invoices.Render(inv).WriteTo(w)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	year, _ := strconv.Atoi(r.Form.Get("y"))
 	month, _ := strconv.Atoi(r.Form.Get("m"))
 
 	// process blocks
@@ -737,7 +2269,12 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 						err := m.DB.DeleteBlockRoomRestrictionByID(value)
 						if err != nil {
 							log.Println(err)
+							continue
 						}
+
+						// the room is free again for the night
+						night, _ := time.Parse(constants.LayoutCalendar, name)
+						m.notifyWaitlist(x.ID, night, night.AddDate(0, 0, 1))
 					}
 				}
 			}
@@ -745,6 +2282,7 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 	}
 
 	// handler new blocks
+	var unavailable []string
 	for name, _ := range r.PostForm {
 		if strings.HasPrefix(name, "add_block") {
 			exploded := strings.Split(name, "_")
@@ -753,12 +2291,1710 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 
 			// insert a new block
 			err := m.DB.InsertBlockForRoom(roomID, t)
-			if err != nil {
+			if errors.Is(err, repository.ErrRoomUnavailable) {
+				unavailable = append(unavailable, t.Format(constants.Layout))
+			} else if err != nil {
 				log.Println(err)
 			}
 		}
 	}
 
+	if len(unavailable) > 0 {
+		m.App.SessionManager.Put(r.Context(), "warning",
+			fmt.Sprintf("Could not block %s, just booked by someone else", strings.Join(unavailable, ", ")))
+	}
+
 	m.App.SessionManager.Put(r.Context(), "success", "Changes saved")
 	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
 }
+
+// AdminRooms displays all rooms, including the retired ones
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRoomsWithRetired()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin/admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowRoom displays the room form, an id of 0 is used to create a new room
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room := models.Room{Active: true, MaxAdults: 2}
+	if id > 0 {
+		room, err = m.DB.GetRoomByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.renderRoom(w, r, room, forms.New(nil))
+}
+
+// AdminPostShowRoom creates a new room or updates an existing one
+func (m *Repository) AdminPostShowRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room := models.Room{
+		ID:               id,
+		RoomName:         r.Form.Get("room_name"),
+		Slug:             r.Form.Get("slug"),
+		Active:           r.Form.Get("active") != "",
+		Description:      r.Form.Get("description"),
+		BedConfiguration: r.Form.Get("bed_configuration"),
+	}
+	if room.Slug == "" {
+		room.Slug = helpers.Slugify(room.RoomName)
+		r.PostForm.Set("slug", room.Slug)
+	}
+	for _, amenity := range strings.Split(r.Form.Get("amenities"), "\n") {
+		if strings.TrimSpace(amenity) != "" {
+			room.Amenities = append(room.Amenities, strings.TrimSpace(amenity))
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name", "max_adults", "max_children", "base_price")
+	form.IsSlug("slug")
+	form.MinInt("max_adults", 1)
+	form.MinInt("max_children", 0)
+	form.IsMoney("base_price")
+
+	room.MaxAdults, _ = strconv.Atoi(r.Form.Get("max_adults"))
+	room.MaxChildren, _ = strconv.Atoi(r.Form.Get("max_children"))
+	room.BasePrice, _ = helpers.ParseMoney(r.Form.Get("base_price"))
+	room.CancellationPolicyID, _ = strconv.Atoi(r.Form.Get("cancellation_policy_id"))
+
+	if form.Valid() {
+		if id == 0 {
+			_, err = m.DB.InsertRoom(room)
+		} else {
+			err = m.DB.UpdateRoom(room)
+		}
+
+		if errors.Is(err, repository.ErrDuplicateSlug) {
+			form.Errors.Add("slug", "This slug is already used by another room")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderRoom(w, r, room, form)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Changes saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// renderRoom displays the room form, with the cancellation policies the room can have
+func (m *Repository) renderRoom(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	policies, err := m.DB.AllCancellationPolicies()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["policies"] = policies
+
+	render.Template(w, r, "admin/admin-rooms-show.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminDeleteRoom retires a room
+func (m *Repository) AdminDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteRoom(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Room retired")
+
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminRoomPhotos displays the photos of a room together with the upload form
+func (m *Repository) AdminRoomPhotos(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	room.ID = roomID
+
+	photos, err := m.DB.GetPhotosForRoom(roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["photos"] = photos
+
+	render.Template(w, r, "admin/admin-room-photos.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminPostRoomPhotos uploads a room photo, and stores it together with a thumbnail
+func (m *Repository) AdminPostRoomPhotos(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	redirectURL := fmt.Sprintf("/admin/rooms/%d/photos", roomID)
+
+	// the memory limit of ParseMultipartForm doesn't cap the upload, the rest would be written to temporary files
+	r.Body = http.MaxBytesReader(w, r.Body, maxPhotoUploadSize)
+	err = r.ParseMultipartForm(maxPhotoSize)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		m.App.SessionManager.Put(r.Context(), "error", "Photo must not be larger than 5 MB")
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't read upload")
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+		return
+	}
+
+	file, header, err := r.FormFile("photo")
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Please choose a photo")
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxPhotoSize {
+		m.App.SessionManager.Put(r.Context(), "error", "Photo must not be larger than 5 MB")
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+		return
+	}
+
+	content, err := io.ReadAll(io.LimitReader(file, maxPhotoSize))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	ext, err := images.Extension(content)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Only JPEG, PNG and GIF photos are allowed")
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+		return
+	}
+
+	img, err := images.Decode(content)
+	if errors.Is(err, images.ErrTooManyPixels) {
+		m.App.SessionManager.Put(r.Context(), "error", "Photo must not have more than 25 megapixels")
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't read image")
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+		return
+	}
+
+	thumbnail, err := images.EncodeJPEG(images.Thumbnail(img, thumbnailWidth, thumbnailHeight))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	name, err := helpers.RandomHex(16)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	photo := models.RoomPhoto{
+		RoomID:        roomID,
+		FileName:      fmt.Sprintf("rooms/%d/%s%s", roomID, name, ext),
+		ThumbnailName: fmt.Sprintf("rooms/%d/thumb_%s.jpg", roomID, name),
+		Caption:       r.Form.Get("caption"),
+	}
+
+	err = m.App.Storage.Save(photo.FileName, bytes.NewReader(content))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.App.Storage.Save(photo.ThumbnailName, bytes.NewReader(thumbnail))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	_, err = m.DB.InsertRoomPhoto(photo)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Photo uploaded")
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+// AdminPostUpdateRoomPhotos saves the captions and the order of the photos of a room
+func (m *Repository) AdminPostUpdateRoomPhotos(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	photos, err := m.DB.GetPhotosForRoom(roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for _, photo := range photos {
+		if !r.PostForm.Has(fmt.Sprintf("caption_%d", photo.ID)) {
+			continue
+		}
+
+		photo.Caption = r.Form.Get(fmt.Sprintf("caption_%d", photo.ID))
+		sortOrder, err := strconv.Atoi(r.Form.Get(fmt.Sprintf("sort_order_%d", photo.ID)))
+		if err == nil {
+			photo.SortOrder = sortOrder
+		}
+
+		err = m.DB.UpdateRoomPhoto(photo)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/photos", roomID), http.StatusSeeOther)
+}
+
+// AdminDeleteRoomPhoto removes a room photo and its files
+func (m *Repository) AdminDeleteRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	photoID, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	photo, err := m.DB.GetRoomPhotoByID(photoID)
+	if err != nil || photo.RoomID != roomID {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't find photo")
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/photos", roomID), http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteRoomPhoto(photo.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for _, name := range []string{photo.FileName, photo.ThumbnailName} {
+		if err := m.App.Storage.Delete(name); err != nil {
+			log.Println(err)
+		}
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Photo deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/photos", roomID), http.StatusSeeOther)
+}
+
+// manageReservationPurpose is the purpose of the signed tokens in the links guests manage their reservation with
+const manageReservationPurpose = "manage-reservation"
+
+// manageReservationPath returns the path of the signed link a guest manages a reservation with, it expires on the departure date
+func (m *Repository) manageReservationPath(res models.Reservation) string {
+	token := m.App.Signer.Sign(manageReservationPurpose, res.ID, res.EndDate)
+	return fmt.Sprintf("/reservations/manage/%s", token)
+}
+
+// manageReservationURL returns the absolute signed link a guest manages a reservation with, as sent by email
+func (m *Repository) manageReservationURL(res models.Reservation) string {
+	return m.App.BaseURL + m.manageReservationPath(res)
+}
+
+// reservationFromToken returns the reservation a signed manage link was issued for
+func (m *Repository) reservationFromToken(token string) (models.Reservation, error) {
+	id, err := m.App.Signer.Verify(manageReservationPurpose, token)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+	return m.DB.GetReservationByID(id)
+}
+
+// ManageReservation shows a reservation to the guest who follows the signed link from the confirmation email
+func (m *Repository) ManageReservation(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	token := exploded[3]
+
+	res, err := m.reservationFromToken(token)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "This link is invalid or has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(url.Values{})
+	form.Set("start_date", res.StartDate.Format(constants.Layout))
+	form.Set("end_date", res.EndDate.Format(constants.Layout))
+	form.Set("room_id", strconv.Itoa(res.RoomID))
+
+	m.renderManageReservation(w, r, res, token, form)
+}
+
+// renderManageReservation shows the manage page of a reservation, with the form to change its dates or room
+func (m *Repository) renderManageReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, token string, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["can_cancel"] = booking.Transition(res.Status, models.StatusCancelled) == nil
+	data["can_change"] = booking.IsChangeable(res.Status)
+	data["can_invoice"] = res.Status != models.StatusPending
+
+	if booking.IsChangeable(res.Status) {
+		rooms, err := m.DB.AllRooms()
+		if err != nil {
+			m.App.SessionManager.Put(r.Context(), "error", "Can't get rooms")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		// only offer the rooms the party fits in
+		var fitting []models.Room
+		for _, room := range rooms {
+			if booking.Fits(room, res.Adults, res.Children) {
+				fitting = append(fitting, room)
+			}
+		}
+		data["rooms"] = fitting
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+
+	render.Template(w, r, "manage-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// PostCancelReservation cancels a reservation for the guest who follows the signed link, and notifies the owner
+func (m *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	token := exploded[3]
+
+	res, err := m.reservationFromToken(token)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "This link is invalid or has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	manageURL := fmt.Sprintf("/reservations/manage/%s", token)
+
+	_, err = m.cancelReservation(res)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "This reservation can't be cancelled anymore, please contact us")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	// send mail notification to property owner
+	htmlMessage := fmt.Sprintf(`
+		<strong>Reservation Cancelled</strong><br>
+		%s %s cancelled the reservation for %s from %s to %s.
+	`, res.FirstName, res.LastName, res.Room.RoomName, res.StartDate.Format(constants.Layout), res.EndDate.Format(constants.Layout))
+
+	msg := models.MailData{
+		To:           "me@there.com",
+		From:         "me@here.com",
+		Subject:      "Reservation Cancelled",
+		Content:      htmlMessage,
+		TemplateMail: "basic.html",
+	}
+	m.App.MailChannel <- msg
+
+	m.App.SessionManager.Put(r.Context(), "success", "Your reservation has been cancelled")
+	http.Redirect(w, r, manageURL, http.StatusSeeOther)
+}
+
+// ReservationInvoice sends the invoice of a reservation as PDF to the guest who follows the signed link
+func (m *Repository) ReservationInvoice(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	token := exploded[3]
+
+	res, err := m.reservationFromToken(token)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "This link is invalid or has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	manageURL := fmt.Sprintf("/reservations/manage/%s", token)
+
+	if res.Status == models.StatusPending {
+		m.App.SessionManager.Put(r.Context(), "error", "Your invoice will be available once the reservation is confirmed")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	inv, err := m.issueInvoice(res)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't get your invoice, please try again later")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	writeInvoice(w, inv)
+}
+
+// PostChangeReservation moves a reservation to other dates or another room for the guest who follows the signed link.
+// The new stay is re-checked for availability, ignoring the guest's own booking, and priced again
+func (m *Repository) PostChangeReservation(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	token := exploded[3]
+
+	res, err := m.reservationFromToken(token)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "This link is invalid or has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	manageURL := fmt.Sprintf("/reservations/manage/%s", token)
+
+	if !booking.IsChangeable(res.Status) {
+		m.App.SessionManager.Put(r.Context(), "error", "This reservation can't be changed anymore, please contact us")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't parse form")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date", "room_id")
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+	form.MinInt("room_id", 1)
+
+	var startDate, endDate time.Time
+	if form.Valid() {
+		startDate, _ = time.Parse(constants.Layout, form.Get("start_date"))
+		endDate, _ = time.Parse(constants.Layout, form.Get("end_date"))
+
+		today := time.Now().Truncate(24 * time.Hour)
+		if startDate.Before(today) {
+			form.Errors.Add("start_date", "Arrival can't be in the past")
+		}
+		if !endDate.After(startDate) {
+			form.Errors.Add("end_date", "Departure must be after arrival")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderManageReservation(w, r, res, token, form)
+		return
+	}
+
+	roomID, _ := strconv.Atoi(form.Get("room_id"))
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't find room")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+	room.ID = roomID
+
+	if !room.Active {
+		form.Errors.Add("room_id", "This room is no longer available")
+		m.renderManageReservation(w, r, res, token, form)
+		return
+	}
+
+	if !booking.Fits(room, res.Adults, res.Children) {
+		form.Errors.Add("room_id", "This room doesn't fit your party")
+		m.renderManageReservation(w, r, res, token, form)
+		return
+	}
+
+	available, violation, err := m.DB.SearchAvailabilityByRoomIDAndDatesExcluding(startDate, endDate, roomID, res.ID)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't check availability")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+	if violation != nil {
+		m.App.SessionManager.Put(r.Context(), "error", fmt.Sprintf("Can't change your reservation. %s", violation.Reason))
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+	if !available {
+		m.App.SessionManager.Put(r.Context(), "error", "Sorry, the room is not available for these dates")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	changed := res
+	changed.StartDate = startDate
+	changed.EndDate = endDate
+	changed.RoomID = roomID
+	changed.Room = room
+
+	plan, err := m.DB.GetRatePlanForRoom(roomID)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't calculate price")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+	rules, err := m.DB.AllTaxRules()
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't calculate price")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	// the redeemed promo code must still apply to the changed stay, a code which was withdrawn since
+	// keeps the discount given
+	var promo models.PromoCode
+	if res.PromoCodeID != 0 {
+		promo, err = m.DB.GetPromoCodeByID(res.PromoCodeID)
+		if err != nil {
+			m.App.SessionManager.Put(r.Context(), "error", "Can't calculate price")
+			http.Redirect(w, r, manageURL, http.StatusSeeOther)
+			return
+		}
+		if reason := booking.CheckPromoCodeStay(promo, changed); reason != "" {
+			m.App.SessionManager.Put(r.Context(), "error", fmt.Sprintf("Can't change your reservation. %s", reason))
+			http.Redirect(w, r, manageURL, http.StatusSeeOther)
+			return
+		}
+	} else if res.Discount > 0 {
+		promo = models.PromoCode{Code: res.PromoCode, Kind: models.DiscountFixed, Amount: res.Discount}
+	}
+
+	quote := pricing.Calculate(changed, room, plan, rules, promo)
+	changed.TotalPrice = quote.Total
+	changed.Taxes = quote.Taxes
+	changed.Discount = quote.Discount
+
+	// move the reservation and its room restriction in a single transaction
+	err = m.DB.UpdateReservationDates(changed)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.SessionManager.Put(r.Context(), "error", "Sorry, this room was just booked by someone else for your dates")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't change reservation")
+		http.Redirect(w, r, manageURL, http.StatusSeeOther)
+		return
+	}
+
+	// send mail notifications - guest, with a new link, which expires on the new departure date
+	htmlMessage := fmt.Sprintf(`
+		<strong>Reservation Changed</strong><br>
+		Dear %s, <br>
+		Your reservation %s has been changed to %s from %s to %s.<br>
+		Total: %s%s%s<br>
+		<a href="%s">View or cancel your reservation</a>
+	`, changed.FirstName, changed.ConfirmationCode, changed.Room.RoomName, changed.StartDate.Format(constants.Layout),
+		changed.EndDate.Format(constants.Layout), render.FormatMoney(changed.TotalPrice), discountNote(changed),
+		taxBreakdown(changed.Taxes), m.manageReservationURL(changed))
+
+	msg := models.MailData{
+		To:           changed.Email,
+		From:         "me@here.com",
+		Subject:      "Reservation Changed",
+		Content:      htmlMessage,
+		TemplateMail: "basic.html",
+	}
+	m.App.MailChannel <- msg
+
+	// send mail notification to property owner
+	htmlMessage = fmt.Sprintf(`
+		<strong>Reservation Changed</strong><br>
+		%s %s moved the reservation for %s from %s to %s to %s from %s to %s.
+	`, res.FirstName, res.LastName, res.Room.RoomName, res.StartDate.Format(constants.Layout), res.EndDate.Format(constants.Layout),
+		changed.Room.RoomName, changed.StartDate.Format(constants.Layout), changed.EndDate.Format(constants.Layout))
+
+	msg = models.MailData{
+		To:           "me@there.com",
+		From:         "me@here.com",
+		Subject:      "Reservation Changed",
+		Content:      htmlMessage,
+		TemplateMail: "basic.html",
+	}
+	m.App.MailChannel <- msg
+
+	m.App.SessionManager.Put(r.Context(), "success", "Your reservation has been changed")
+	http.Redirect(w, r, m.manageReservationPath(changed), http.StatusSeeOther)
+}
+
+// waitlistClaimDuration is how long a room offered to a waitlisted guest is held for them to claim it
+const waitlistClaimDuration = 12 * time.Hour
+
+// waitlistClaimPurpose is the purpose of the signed tokens in the links waitlisted guests claim an offered room with
+const waitlistClaimPurpose = "waitlist-claim"
+
+// PostWaitlist puts the guest on the waitlist for the dates and party of the search which found no rooms
+func (m *Repository) PostWaitlist(w http.ResponseWriter, r *http.Request) {
+	search, ok := m.App.SessionManager.Get(r.Context(), "waitlist").(models.Reservation)
+	if !ok {
+		m.App.SessionManager.Put(r.Context(), "error", "Please search for your dates first")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+	if !form.Valid() {
+		m.renderAvailability(w, r, form)
+		return
+	}
+
+	entry := models.WaitlistEntry{
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Email:     r.Form.Get("email"),
+		StartDate: search.StartDate,
+		EndDate:   search.EndDate,
+		Adults:    search.Adults,
+		Children:  search.Children,
+	}
+	_, err = m.DB.InsertWaitlistEntry(entry)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't add you to the waitlist")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	htmlMessage := fmt.Sprintf(`
+		<strong>Waitlist</strong><br>
+		Dear %s, <br>
+		You are on our waitlist for a stay from %s to %s. We will email you as soon as a room becomes free for your dates.
+	`, entry.FirstName, entry.StartDate.Format(constants.Layout), entry.EndDate.Format(constants.Layout))
+
+	msg := models.MailData{
+		To:           entry.Email,
+		From:         "me@here.com",
+		Subject:      "Waitlist",
+		Content:      htmlMessage,
+		TemplateMail: "basic.html",
+	}
+	m.App.MailChannel <- msg
+
+	m.App.SessionManager.Remove(r.Context(), "waitlist")
+	m.App.SessionManager.Put(r.Context(), "success", "You are on the waitlist, we will email you when a room becomes free for your dates")
+	http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+}
+
+// notifyWaitlist offers a room which became free for the dates to the guests waiting for a stay in them, in the order
+// they joined the waitlist. Every guest whose whole stay is free in the room gets it held for them, and an email with
+// a link to claim it before the hold expires. It returns how many guests were notified
+func (m *Repository) notifyWaitlist(roomID int, start, end time.Time) int {
+	entries, err := m.DB.WaitlistEntriesForRoom(roomID, start, end)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return 0
+	}
+
+	notified := 0
+	for _, entry := range entries {
+		available, violation, err := m.DB.SearchAvailabilityByRoomIDAndDates(entry.StartDate, entry.EndDate, roomID)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			continue
+		}
+		if !available || violation != nil {
+			continue
+		}
+
+		token, err := helpers.RandomHex(16)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			continue
+		}
+		entry.RoomID = roomID
+		entry.HoldToken = token
+		entry.NotifiedAt = time.Now()
+		entry.ClaimExpiresAt = entry.NotifiedAt.Add(waitlistClaimDuration)
+
+		// an earlier guest may have been offered some of the nights already
+		err = m.DB.PlaceHold(models.RoomRestriction{
+			RoomID:    roomID,
+			StartDate: entry.StartDate,
+			EndDate:   entry.EndDate,
+			HoldToken: entry.HoldToken,
+			ExpiresAt: entry.ClaimExpiresAt,
+		})
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			continue
+		}
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			continue
+		}
+
+		err = m.DB.OfferWaitlistEntry(entry)
+		if err != nil {
+			if !errors.Is(err, repository.ErrWaitlistStatusChanged) {
+				m.App.ErrorLog.Println(err)
+			}
+			m.releaseHold(models.Reservation{HoldToken: entry.HoldToken})
+			continue
+		}
+
+		token = m.App.Signer.Sign(waitlistClaimPurpose, entry.ID, entry.ClaimExpiresAt)
+		htmlMessage := fmt.Sprintf(`
+		<strong>A Room Is Free For You</strong><br>
+		Dear %s, <br>
+		%s became free for your stay from %s to %s. We are holding it for you until %s.<br>
+		<a href="%s">Claim the room</a>
+	`, entry.FirstName, entry.Room.RoomName, entry.StartDate.Format(constants.Layout), entry.EndDate.Format(constants.Layout),
+			entry.ClaimExpiresAt.Format(constants.Layout+" 15:04"), m.App.BaseURL+"/waitlist/claim/"+token)
+
+		msg := models.MailData{
+			To:           entry.Email,
+			From:         "me@here.com",
+			Subject:      "A Room Is Free For You",
+			Content:      htmlMessage,
+			TemplateMail: "basic.html",
+		}
+		m.App.MailChannel <- msg
+		notified++
+	}
+	return notified
+}
+
+// ReofferExpiredWaitlistOffers closes the offers which weren't claimed by now, and offers their rooms to the guests
+// next on the waitlist. It returns how many guests were notified
+func (m *Repository) ReofferExpiredWaitlistOffers(now time.Time) (int, error) {
+	expired, err := m.DB.ExpireWaitlistOffers(now)
+	if err != nil {
+		return 0, err
+	}
+
+	notified := 0
+	for _, entry := range expired {
+		m.releaseHold(models.Reservation{HoldToken: entry.HoldToken})
+		notified += m.notifyWaitlist(entry.RoomID, entry.StartDate, entry.EndDate)
+	}
+	return notified, nil
+}
+
+// ClaimWaitlist takes up the room offered to the waitlisted guest who follows the signed link from the email,
+// and continues with the reservation of the room held for them
+func (m *Repository) ClaimWaitlist(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	token := exploded[3]
+
+	id, err := m.App.Signer.Verify(waitlistClaimPurpose, token)
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "This link is invalid or has expired")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	entry, err := m.DB.GetWaitlistEntryByID(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		m.App.SessionManager.Put(r.Context(), "error", "This link is invalid or has expired")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't get waitlist entry")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.ClaimWaitlistEntry(entry.ID, time.Now())
+	if errors.Is(err, repository.ErrWaitlistStatusChanged) {
+		m.App.SessionManager.Put(r.Context(), "error", "Sorry, this offer has expired. Please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.SessionManager.Put(r.Context(), "error", "Can't claim room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// the reservation takes over the hold placed for the guest
+	m.App.SessionManager.Put(r.Context(), "reservation", models.Reservation{
+		FirstName:     entry.FirstName,
+		LastName:      entry.LastName,
+		Email:         entry.Email,
+		StartDate:     entry.StartDate,
+		EndDate:       entry.EndDate,
+		RoomID:        entry.RoomID,
+		Room:          entry.Room,
+		Adults:        entry.Adults,
+		Children:      entry.Children,
+		HoldToken:     entry.HoldToken,
+		HoldExpiresAt: entry.ClaimExpiresAt,
+	})
+
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// weekdayNames labels the weekday multipliers of a rate plan, indexed by time.Weekday
+var weekdayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+
+// AdminRatePlans lists the rate plans
+func (m *Repository) AdminRatePlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := m.DB.AllRatePlans()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["plans"] = plans
+
+	render.Template(w, r, "admin/admin-rate-plans.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowRatePlan displays the rate plan form with its seasons, an id of 0 is used to create a new plan
+func (m *Repository) AdminShowRatePlan(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	plan := models.RatePlan{Active: true, WeekdayPercents: [7]int{100, 100, 100, 100, 100, 100, 100}}
+	if id > 0 {
+		plan, err = m.DB.GetRatePlanByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.renderRatePlan(w, r, plan, forms.New(nil))
+}
+
+// AdminPostShowRatePlan creates a new rate plan or updates an existing one
+func (m *Repository) AdminPostShowRatePlan(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	plan := models.RatePlan{
+		ID:         id,
+		Name:       r.Form.Get("name"),
+		Active:     r.Form.Get("active") != "",
+		RoomPrices: make(map[int]int),
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+
+	for i := range plan.WeekdayPercents {
+		field := fmt.Sprintf("percent_%d", i)
+		form.MinInt(field, 1)
+		plan.WeekdayPercents[i], _ = strconv.Atoi(r.Form.Get(field))
+	}
+
+	rooms, err := m.DB.AllRoomsWithRetired()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// rooms without a price are not priced by this plan
+	for _, room := range rooms {
+		field := fmt.Sprintf("room_price_%d", room.ID)
+		if r.Form.Get(field) == "" {
+			continue
+		}
+		form.IsMoney(field)
+		plan.RoomPrices[room.ID], _ = helpers.ParseMoney(r.Form.Get(field))
+	}
+
+	if !form.Valid() {
+		if id > 0 {
+			saved, err := m.DB.GetRatePlanByID(id)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			plan.Seasons = saved.Seasons
+		}
+		m.renderRatePlan(w, r, plan, form)
+		return
+	}
+
+	if id == 0 {
+		id, err = m.DB.InsertRatePlan(plan)
+	} else {
+		err = m.DB.UpdateRatePlan(plan)
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rate-plans/%d/show", id), http.StatusSeeOther)
+}
+
+// AdminPostRateSeason adds a season to a rate plan
+func (m *Repository) AdminPostRateSeason(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	planID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	redirectURL := fmt.Sprintf("/admin/rate-plans/%d/show", planID)
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "start_date", "end_date", "nightly_price")
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+	form.IsMoney("nightly_price")
+
+	season := models.RateSeason{
+		RatePlanID: planID,
+		Name:       r.Form.Get("name"),
+	}
+	season.RoomID, _ = strconv.Atoi(r.Form.Get("room_id"))
+	season.StartDate, _ = time.Parse(constants.Layout, r.Form.Get("start_date"))
+	season.EndDate, _ = time.Parse(constants.Layout, r.Form.Get("end_date"))
+	season.NightlyPrice, _ = helpers.ParseMoney(r.Form.Get("nightly_price"))
+
+	if form.Valid() && season.EndDate.Before(season.StartDate) {
+		form.Errors.Add("end_date", "The last night must not be before the first night")
+	}
+
+	if !form.Valid() {
+		m.App.SessionManager.Put(r.Context(), "error", "Invalid season, please check the dates and the price")
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.InsertRateSeason(season)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Season added")
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+// AdminDeleteRateSeason removes a season from a rate plan
+func (m *Repository) AdminDeleteRateSeason(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	planID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteRateSeason(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Season deleted")
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/rate-plans/%d/show", planID), http.StatusSeeOther)
+}
+
+// renderRatePlan displays the rate plan form
+func (m *Repository) renderRatePlan(w http.ResponseWriter, r *http.Request, plan models.RatePlan, form *forms.Form) {
+	rooms, err := m.DB.AllRoomsWithRetired()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["plan"] = plan
+	data["rooms"] = rooms
+	data["weekdays"] = weekdayNames
+
+	render.Template(w, r, "admin/admin-rate-plans-show.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminStayRules lists the stay rules which have not ended yet, together with the form to add one
+func (m *Repository) AdminStayRules(w http.ResponseWriter, r *http.Request) {
+	m.renderStayRules(w, r, forms.New(nil))
+}
+
+// AdminPostStayRules adds a stay rule for a room and a date range
+func (m *Repository) AdminPostStayRules(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "restriction_id", "start_date", "end_date")
+	form.IsDate("start_date")
+	form.IsDate("end_date")
+
+	rule := models.RoomRestriction{}
+	rule.RoomID, _ = strconv.Atoi(r.Form.Get("room_id"))
+	rule.RestrictionID, _ = strconv.Atoi(r.Form.Get("restriction_id"))
+	rule.StartDate, _ = time.Parse(constants.Layout, r.Form.Get("start_date"))
+	lastDate, _ := time.Parse(constants.Layout, r.Form.Get("end_date"))
+	rule.EndDate = lastDate.AddDate(0, 0, 1) // the rule covers the last date as well
+
+	if !booking.IsStayRule(rule.RestrictionID) {
+		form.Errors.Add("restriction_id", "Invalid rule")
+	}
+	if rule.RestrictionID == models.RestrictionMinimumStay || rule.RestrictionID == models.RestrictionMaximumStay {
+		if form.MinInt("nights", 1) {
+			rule.Nights, _ = strconv.Atoi(r.Form.Get("nights"))
+		}
+	}
+	if form.Valid() && lastDate.Before(rule.StartDate) {
+		form.Errors.Add("end_date", "The last date must not be before the first date")
+	}
+
+	if !form.Valid() {
+		m.renderStayRules(w, r, form)
+		return
+	}
+
+	err = m.DB.InsertStayRule(rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Rule added")
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// AdminDeleteStayRule removes a stay rule
+func (m *Repository) AdminDeleteStayRule(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteStayRule(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Rule deleted")
+
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// renderStayRules displays the stay rules page
+func (m *Repository) renderStayRules(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rules, err := m.DB.AllStayRules()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rules
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin/admin-stay-rules.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminCancellationPolicies lists the cancellation policies with their tiers, together with the forms to add them
+func (m *Repository) AdminCancellationPolicies(w http.ResponseWriter, r *http.Request) {
+	m.renderCancellationPolicies(w, r, forms.New(nil))
+}
+
+// AdminPostCancellationPolicies adds a cancellation policy, its tiers are added afterwards
+func (m *Repository) AdminPostCancellationPolicies(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+
+	if !form.Valid() {
+		m.renderCancellationPolicies(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertCancellationPolicy(models.CancellationPolicy{Name: r.Form.Get("name")})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Policy added")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminPostCancellationTier adds a tier to a cancellation policy
+func (m *Repository) AdminPostCancellationTier(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("policy_id", "hours_before", "fee_percent")
+	form.MinInt("policy_id", 1)
+	form.MinInt("hours_before", 1)
+	if form.MinInt("fee_percent", 0) {
+		if percent, _ := strconv.Atoi(r.Form.Get("fee_percent")); percent > 100 {
+			form.Errors.Add("fee_percent", "The fee can't be more than 100%")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderCancellationPolicies(w, r, form)
+		return
+	}
+
+	tier := models.CancellationTier{}
+	tier.PolicyID, _ = strconv.Atoi(r.Form.Get("policy_id"))
+	tier.HoursBefore, _ = strconv.Atoi(r.Form.Get("hours_before"))
+	tier.FeePercent, _ = strconv.Atoi(r.Form.Get("fee_percent"))
+
+	err = m.DB.InsertCancellationTier(tier)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Tier added")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminDeleteCancellationTier removes a tier from its cancellation policy
+func (m *Repository) AdminDeleteCancellationTier(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteCancellationTier(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Tier deleted")
+
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// renderCancellationPolicies displays the cancellation policies page
+func (m *Repository) renderCancellationPolicies(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	policies, err := m.DB.AllCancellationPolicies()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["policies"] = policies
+
+	render.Template(w, r, "admin/admin-cancellation-policies.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminTaxRules lists the tax and fee rules, together with the form to add one
+func (m *Repository) AdminTaxRules(w http.ResponseWriter, r *http.Request) {
+	m.renderTaxRules(w, r, forms.New(nil))
+}
+
+// AdminPostTaxRules adds a tax or fee rule. The dates are optional, a rule without them applies to every stay
+func (m *Repository) AdminPostTaxRules(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "kind", "amount")
+	form.IsMoney("amount")
+
+	rule := models.TaxRule{
+		Name:  r.Form.Get("name"),
+		Kind:  models.TaxKind(r.Form.Get("kind")),
+		Basis: models.TaxBasis(r.Form.Get("basis")),
+	}
+
+	if rule.Kind != models.TaxPercent && rule.Kind != models.TaxFixed {
+		form.Errors.Add("kind", "Invalid kind")
+	}
+	if rule.Kind == models.TaxPercent {
+		// percentages are taken of the nightly prices, whatever the basis
+		rule.Basis = models.TaxPerStay
+	}
+	validBasis := false
+	for _, b := range models.TaxBases {
+		validBasis = validBasis || rule.Basis == b
+	}
+	if !validBasis {
+		form.Errors.Add("basis", "Invalid basis")
+	}
+
+	if form.Get("amount") != "" && form.Errors.Get("amount") == "" {
+		rule.Amount, _ = helpers.ParseMoney(form.Get("amount"))
+		if rule.Kind == models.TaxPercent && rule.Amount > 10000 {
+			form.Errors.Add("amount", "A percentage can't be more than 100")
+		}
+	}
+
+	if form.Get("start_date") != "" {
+		form.IsDate("start_date")
+		rule.StartDate, _ = time.Parse(constants.Layout, form.Get("start_date"))
+	}
+	if form.Get("end_date") != "" {
+		form.IsDate("end_date")
+		rule.EndDate, _ = time.Parse(constants.Layout, form.Get("end_date"))
+	}
+	if form.Valid() && !rule.StartDate.IsZero() && !rule.EndDate.IsZero() && rule.EndDate.Before(rule.StartDate) {
+		form.Errors.Add("end_date", "The last night must not be before the first night")
+	}
+
+	if !form.Valid() {
+		m.renderTaxRules(w, r, form)
+		return
+	}
+
+	err = m.DB.InsertTaxRule(rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Tax rule added")
+	http.Redirect(w, r, "/admin/tax-rules", http.StatusSeeOther)
+}
+
+// AdminDeleteTaxRule removes a tax or fee rule, existing reservations keep what they were charged
+func (m *Repository) AdminDeleteTaxRule(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteTaxRule(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Tax rule deleted")
+
+	http.Redirect(w, r, "/admin/tax-rules", http.StatusSeeOther)
+}
+
+// renderTaxRules displays the tax rules page
+func (m *Repository) renderTaxRules(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rules, err := m.DB.AllTaxRules()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rules
+	data["bases"] = models.TaxBases
+
+	render.Template(w, r, "admin/admin-tax-rules.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPromoCodes lists the promo codes, together with the form to add one
+func (m *Repository) AdminPromoCodes(w http.ResponseWriter, r *http.Request) {
+	m.renderPromoCodes(w, r, forms.New(nil))
+}
+
+// AdminPostPromoCodes adds a promo code. The dates, usage limit, minimum stay and rooms are optional,
+// a code without them can be redeemed for any stay
+func (m *Repository) AdminPostPromoCodes(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code", "kind", "amount")
+	form.IsPromoCode("code")
+	form.IsMoney("amount")
+
+	promo := models.PromoCode{
+		Code: booking.NormalizePromoCode(r.Form.Get("code")),
+		Kind: models.DiscountKind(r.Form.Get("kind")),
+	}
+
+	if promo.Kind != models.DiscountPercent && promo.Kind != models.DiscountFixed {
+		form.Errors.Add("kind", "Invalid kind")
+	}
+	if form.Get("amount") != "" && form.Errors.Get("amount") == "" {
+		promo.Amount, _ = helpers.ParseMoney(form.Get("amount"))
+		if promo.Amount == 0 {
+			form.Errors.Add("amount", "The discount must be more than 0")
+		}
+		if promo.Kind == models.DiscountPercent && promo.Amount > 10000 {
+			form.Errors.Add("amount", "A percentage can't be more than 100")
+		}
+	}
+
+	if form.Get("valid_from") != "" {
+		form.IsDate("valid_from")
+		promo.ValidFrom, _ = time.Parse(constants.Layout, form.Get("valid_from"))
+	}
+	if form.Get("valid_until") != "" {
+		form.IsDate("valid_until")
+		promo.ValidUntil, _ = time.Parse(constants.Layout, form.Get("valid_until"))
+	}
+	if form.Valid() && !promo.ValidFrom.IsZero() && !promo.ValidUntil.IsZero() && promo.ValidUntil.Before(promo.ValidFrom) {
+		form.Errors.Add("valid_until", "The last day must not be before the first day")
+	}
+
+	if form.Get("max_uses") != "" && form.MinInt("max_uses", 0) {
+		promo.MaxUses, _ = strconv.Atoi(form.Get("max_uses"))
+	}
+	if form.Get("min_nights") != "" && form.MinInt("min_nights", 0) {
+		promo.MinNights, _ = strconv.Atoi(form.Get("min_nights"))
+	}
+
+	for _, value := range r.Form["room_id"] {
+		roomID, err := strconv.Atoi(value)
+		if err != nil {
+			form.Errors.Add("room_id", "Invalid room")
+			break
+		}
+		promo.RoomIDs = append(promo.RoomIDs, roomID)
+	}
+
+	if !form.Valid() {
+		m.renderPromoCodes(w, r, form)
+		return
+	}
+
+	err = m.DB.InsertPromoCode(promo)
+	if errors.Is(err, repository.ErrDuplicatePromoCode) {
+		form.Errors.Add("code", "This promo code already exists")
+		m.renderPromoCodes(w, r, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Promo code added")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminDeletePromoCode removes a promo code, reservations keep the discount they were given
+func (m *Repository) AdminDeletePromoCode(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeletePromoCode(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", "Promo code deleted")
+
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// renderPromoCodes displays the promo codes page
+func (m *Repository) renderPromoCodes(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	promos, err := m.DB.AllPromoCodes()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	roomNames := make(map[int]string)
+	for _, room := range rooms {
+		roomNames[room.ID] = room.RoomName
+	}
+
+	// the checked rooms of the posted form
+	checked := make(map[string]bool)
+	for _, id := range form.Values["room_id"] {
+		checked[id] = true
+	}
+
+	data := make(map[string]interface{})
+	data["promos"] = promos
+	data["rooms"] = rooms
+	data["room_names"] = roomNames
+	data["checked"] = checked
+
+	render.Template(w, r, "admin/admin-promo-codes.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminWaitlist displays the waitlist
+func (m *Repository) AdminWaitlist(w http.ResponseWriter, r *http.Request) {
+	entries, err := m.DB.AllWaitlistEntries()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["entries"] = entries
+
+	render.Template(w, r, "admin/admin-waitlist.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminGuests displays the guest profiles
+func (m *Repository) AdminGuests(w http.ResponseWriter, r *http.Request) {
+	guests, err := m.DB.AllGuests()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["guests"] = guests
+
+	render.Template(w, r, "admin/admin-guests.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowGuest displays a guest profile with the stay history, and the profiles which might be duplicates of it
+func (m *Repository) AdminShowGuest(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	guest, err := m.DB.GetGuestByID(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		m.App.SessionManager.Put(r.Context(), "error", "Guest not found")
+		http.Redirect(w, r, "/admin/guests", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservations, err := m.DB.GetReservationsForGuest(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	duplicates, err := m.DB.GetPossibleDuplicateGuests(guest)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["guest"] = guest
+	data["reservations"] = reservations
+	data["duplicates"] = duplicates
+
+	render.Template(w, r, "admin/admin-guests-show.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// AdminPostMergeGuest merges a duplicate guest profile into the one shown, which keeps its details
+func (m *Repository) AdminPostMergeGuest(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	showURL := fmt.Sprintf("/admin/guests/%d/show", id)
+
+	mergeID, err := strconv.Atoi(r.Form.Get("merge_id"))
+	if err != nil || mergeID == id {
+		m.App.SessionManager.Put(r.Context(), "error", "Please choose another guest to merge")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.MergeGuests(id, mergeID)
+	if errors.Is(err, repository.ErrNotFound) {
+		m.App.SessionManager.Put(r.Context(), "error", fmt.Sprintf("Guest #%d not found", mergeID))
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.SessionManager.Put(r.Context(), "success", fmt.Sprintf("Guest #%d merged into this profile", mergeID))
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
+}
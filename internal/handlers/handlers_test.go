@@ -1,12 +1,21 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/loidinhm31/go-bookings-system/internal/booking"
 	"github.com/loidinhm31/go-bookings-system/internal/driver"
 	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"github.com/loidinhm31/go-bookings-system/internal/payments"
+	"github.com/loidinhm31/go-bookings-system/internal/repository"
+	"image"
+	"image/color"
+	gifenc "image/gif"
+	pngenc "image/png"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -27,7 +36,28 @@ var theTests = []struct {
 	{"contact", "/contact", "GET", http.StatusOK},
 	{"major", "/majors-suite", "GET", http.StatusOK},
 	{"general", "/generals-quarters", "GET", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
+	{"room", "/rooms/generals-quarters", "GET", http.StatusOK},
+	{"admin-rooms", "/admin/rooms", "GET", http.StatusOK},
+	{"admin-new-room", "/admin/rooms/0/show", "GET", http.StatusOK},
+	{"admin-room-photos", "/admin/rooms/1/photos", "GET", http.StatusOK},
+	{"admin-rate-plans", "/admin/rate-plans", "GET", http.StatusOK},
+	{"admin-new-rate-plan", "/admin/rate-plans/0/show", "GET", http.StatusOK},
+	{"admin-rate-plan", "/admin/rate-plans/1/show", "GET", http.StatusOK},
+	{"admin-stay-rules", "/admin/stay-rules", "GET", http.StatusOK},
+	{"admin-cancellation-policies", "/admin/cancellation-policies", "GET", http.StatusOK},
+	{"admin-tax-rules", "/admin/tax-rules", "GET", http.StatusOK},
+	{"admin-promo-codes", "/admin/promo-codes", "GET", http.StatusOK},
+	{"admin-waitlist", "/admin/waitlist", "GET", http.StatusOK},
+	{"admin-guests", "/admin/guests", "GET", http.StatusOK},
+	{"admin-cancelled-reservation", "/admin/reservations/all/7/show", "GET", http.StatusOK},
+	{"admin-all-reservations", "/admin/reservations-all?status=confirmed", "GET", http.StatusOK},
+	{"admin-show-reservation", "/admin/reservations/all/1/show", "GET", http.StatusOK},
+	{"admin-group-reservation", "/admin/reservations/all/13/show", "GET", http.StatusOK},
 	{"search", "/search-availability", "GET", http.StatusOK},
+	{"login", "/user/login", "GET", http.StatusOK},
+	{"register", "/user/register", "GET", http.StatusOK},
+	{"forgot-password", "/user/forgot-password", "GET", http.StatusOK},
 }
 
 func TestNewRepo(t *testing.T) {
@@ -109,7 +139,7 @@ func TestRepository_Reservation(t *testing.T) {
 	req = req.WithContext(ctx)
 	rr = httptest.NewRecorder()
 
-	reservation.RoomID = 100
+	reservation.RoomID = 3
 	sessionManager.Put(ctx, "reservation", reservation)
 
 	handler.ServeHTTP(rr, req)
@@ -119,6 +149,45 @@ func TestRepository_Reservation(t *testing.T) {
 	}
 }
 
+func TestRepository_ReservationPrice(t *testing.T) {
+	// thursday to sunday, the rate plan of room 1 charges 20% more on fridays and saturdays,
+	// plus 10% VAT and a city tax of 2.00 per person per night
+	reservation := models.Reservation{
+		RoomID:    1,
+		StartDate: time.Date(2050, 6, 2, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 6, 5, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+	}
+
+	req := httptest.NewRequest("GET", "/make-reservation", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	sessionManager.Put(ctx, "reservation", reservation)
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Reservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	res, _ := sessionManager.Get(ctx, "reservation").(models.Reservation)
+	if res.TotalPrice != 34000+3400+1200 {
+		t.Errorf("expected total price of %d in session but got %d", 34000+3400+1200, res.TotalPrice)
+	}
+	if len(res.Taxes) != 2 || res.Taxes[0].Amount != 3400 || res.Taxes[1].Amount != 1200 {
+		t.Errorf("expected VAT of 3400 and city tax of 1200 in session but got %+v", res.Taxes)
+	}
+
+	for _, want := range []string{"386.00", "City tax", "12.00"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected to find %s on the page but did not", want)
+		}
+	}
+}
+
 func TestRepository_PostReservation(t *testing.T) {
 	/*****************************************
 	// first case -- missing post body
@@ -148,6 +217,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	postData.Add("email", "john@smith.com")
 	postData.Add("phone", "123456789")
 	postData.Add("room_id", "1")
+	postData.Add("card_number", "4242424242424242")
 
 	req = httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
 	ctx = getCtx(req)
@@ -184,6 +254,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	postData.Add("email", "john@smith.com")
 	postData.Add("phone", "123456789")
 	postData.Add("room_id", "1")
+	postData.Add("card_number", "4242424242424242")
 
 	req = httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
 	ctx = getCtx(req)
@@ -213,13 +284,16 @@ func TestRepository_PostReservation(t *testing.T) {
 	postData.Add("email", "john@smith.com")
 	postData.Add("phone", "123456789")
 	postData.Add("room_id", "2")
+	postData.Add("card_number", "4242424242424242")
 
 	req = httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	sessionManager.Put(ctx, "reservation", reservation)
+	failingRoom := reservation
+	failingRoom.RoomID = 2
+	sessionManager.Put(ctx, "reservation", failingRoom)
 
 	rr = httptest.NewRecorder()
 
@@ -241,13 +315,15 @@ func TestRepository_PostReservation(t *testing.T) {
 	postData.Add("email", "john@smith.com")
 	postData.Add("phone", "123456789")
 	postData.Add("room_id", "1000")
+	postData.Add("card_number", "4242424242424242")
 
 	req = httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	sessionManager.Put(ctx, "reservation", reservation)
+	failingRoom.RoomID = 1000
+	sessionManager.Put(ctx, "reservation", failingRoom)
 
 	rr = httptest.NewRecorder()
 
@@ -257,799 +333,3989 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("PostReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
-}
 
-func TestRepository_PostAvailability(t *testing.T) {
 	/*****************************************
-	// 1st case -- rooms are not available
+	// 6th case -- room was just booked by someone else
 	*****************************************/
-	// create request body
-	postData := url.Values{}
-	postData.Add("start", "2050-01-01")
-	postData.Add("end", "2050-01-02")
-
-	// create request
-	req := httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+	postData.Set("room_id", "500")
 
-	// get the context with session
-	ctx := getCtx(req)
+	req = httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+	ctx = getCtx(req)
 	req = req.WithContext(ctx)
-
-	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// create response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr := httptest.NewRecorder()
+	failingRoom.RoomID = 500
+	sessionManager.Put(ctx, "reservation", failingRoom)
 
-	// make handler a http.HandlerFunc
-	handler := http.HandlerFunc(Repo.PostAvailability)
+	rr = httptest.NewRecorder()
 
-	// make the request to handler
+	handler = http.HandlerFunc(Repo.PostReservation)
 	handler.ServeHTTP(rr, req)
 
-	// since it has no rooms available, we expect to get status http.StatusSeeOther
 	if rr.Code != http.StatusSeeOther {
-		t.Errorf("Post availability when no rooms available gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+		t.Errorf("PostReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/search-availability" {
+		t.Errorf("PostReservation handler redirected to wrong location: got %s, wanted %s", actualLoc.String(), "/search-availability")
 	}
 
 	/*****************************************
-	// 2nd case -- rooms are available
+	// another room posted than the one priced and held in the session
 	*****************************************/
-	// this time, we specify a start date before 2040-01-01, which will give us
-	// a non-empty slice, indicating that rooms are available
-	postData = url.Values{}
-	postData.Add("start", "2040-01-01")
-	postData.Add("end", "2040-01-02")
-
-	// create our request
-	req = httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+	postData.Set("room_id", "2")
 
-	// get the context with session
+	req = httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
-
-	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// create response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr = httptest.NewRecorder()
+	sessionManager.Put(ctx, "reservation", reservation)
 
-	// make handler a http.HandlerFunc
-	handler = http.HandlerFunc(Repo.PostAvailability)
+	rr = httptest.NewRecorder()
 
-	// make the request to handler
+	handler = http.HandlerFunc(Repo.PostReservation)
 	handler.ServeHTTP(rr, req)
 
-	// since it has rooms available, we expect to get status http.StatusOK
-	if rr.Code != http.StatusOK {
-		t.Errorf("Post availability when rooms are available gave wrong status code: got %d, wanted %d", rr.Code, http.StatusOK)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	actualLoc, _ = rr.Result().Location()
+	if actualLoc.String() != "/" {
+		t.Errorf("PostReservation handler redirected to wrong location: got %s, wanted %s", actualLoc.String(), "/")
+	}
+	if _, booked := sessionManager.Get(ctx, "booked_rooms").([]models.Reservation); booked {
+		t.Error("PostReservation handler booked a room which wasn't priced and held")
 	}
 
 	/*****************************************
-	// 3rd case -- empty post body
+	// 7th case -- card was declined
 	*****************************************/
-	// create request with a nil body, so parsing form fails
-	req, _ = http.NewRequest("POST", "/search-availability", nil)
+	postData.Set("room_id", "1")
+	postData.Set("card_number", payments.DeclinedCard)
 
-	// get the context with session
+	req = httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
-
-	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// create response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr = httptest.NewRecorder()
+	sessionManager.Put(ctx, "reservation", reservation)
 
-	// make handler a http.HandlerFunc
-	handler = http.HandlerFunc(Repo.PostAvailability)
+	rr = httptest.NewRecorder()
 
-	// make the request to our handler
+	handler = http.HandlerFunc(Repo.PostReservation)
 	handler.ServeHTTP(rr, req)
 
-	// since it has rooms available, we expect to get status http.StatusSeeOther
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("Post availability with empty request body (nil) gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	if rr.Code != http.StatusOK {
+		t.Errorf("PostReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if !strings.Contains(rr.Body.String(), "Your card was declined") {
+		t.Error("PostReservation handler did not tell the card was declined")
 	}
 
 	/*****************************************
-	// 4th case -- start date in wrong format
+	// 8th case -- invalid card number
 	*****************************************/
-	// this time,a start date in the wrong format
-	postData = url.Values{}
-	postData.Add("start", "invalid")
-	postData.Add("end", "2040-01-02")
+	postData.Set("card_number", "1234")
 
-	req = httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
-
-	// get the context with session
+	req = httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
-
-	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// create response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr = httptest.NewRecorder()
+	sessionManager.Put(ctx, "reservation", reservation)
 
-	// make handler a http.HandlerFunc
-	handler = http.HandlerFunc(Repo.PostAvailability)
+	rr = httptest.NewRecorder()
 
-	// make the request to handler
+	handler = http.HandlerFunc(Repo.PostReservation)
 	handler.ServeHTTP(rr, req)
 
-	// since it has rooms available, we expect to get status http.StatusSeeOther
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("Post availability with invalid start date gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	if rr.Code != http.StatusOK {
+		t.Errorf("PostReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
 	}
+}
 
-	/*****************************************
-	// 5th case -- end date in wrong format
-	*****************************************/
-	// this time, an end date in the wrong format
-	postData = url.Values{}
-	postData.Add("start", "2040-01-01")
-	postData.Add("end", "invalid")
+func TestRepository_PostReservation_Rejected(t *testing.T) {
+	tests := []struct {
+		name             string
+		reservation      models.Reservation
+		expectedLocation string
+		expectedError    string
+	}{
+		{
+			name:             "retired-room",
+			reservation:      models.Reservation{RoomID: 5, Adults: 2, Room: models.Room{ID: 5, RoomName: "Old Wing"}},
+			expectedLocation: "/search-availability",
+			expectedError:    "Sorry, Old Wing is no longer available. Please search again",
+		},
+		{
+			name:             "party-too-big",
+			reservation:      models.Reservation{RoomID: 1, Adults: 2, Children: 3, Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+			expectedLocation: "/search-availability",
+			expectedError:    "Sorry, General's Quarters doesn't fit your party. Please search again",
+		},
+		{
+			name: "closed-to-arrival",
+			reservation: models.Reservation{RoomID: 1, Adults: 2, Room: models.Room{ID: 1, RoomName: "General's Quarters"},
+				StartDate: time.Date(2049, 12, 24, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2049, 12, 26, 0, 0, 0, 0, time.UTC)},
+			expectedLocation: "/search-availability",
+			expectedError:    "Sorry, General's Quarters can't be booked for your dates. Arrivals are not possible on 2049-12-24",
+		},
+	}
 
-	req = httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("first_name", "John")
+		postData.Add("last_name", "Smith")
+		postData.Add("email", "john@smith.com")
+		postData.Add("phone", "123456789")
+		postData.Add("room_id", fmt.Sprint(e.reservation.RoomID))
+		postData.Add("card_number", "4242424242424242")
 
-	// get the context with session
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
+		req := httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// set the request header
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if e.reservation.StartDate.IsZero() {
+			e.reservation.StartDate = time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+			e.reservation.EndDate = time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC)
+		}
+		sessionManager.Put(ctx, "reservation", e.reservation)
 
-	// create response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr = httptest.NewRecorder()
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
 
-	// make handler a http.HandlerFunc
-	handler = http.HandlerFunc(Repo.PostAvailability)
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc == nil || actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %v", e.name, e.expectedLocation, actualLoc)
+		}
+		if msg := sessionManager.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q but got %q", e.name, e.expectedError, msg)
+		}
+		if _, booked := sessionManager.Get(ctx, "booked_rooms").([]models.Reservation); booked {
+			t.Errorf("failed %s: expected nothing to be booked", e.name)
+		}
+	}
+}
 
-	// make the request to handler
-	handler.ServeHTTP(rr, req)
+func TestRepository_PostReservation_PromoCode(t *testing.T) {
+	reservation := models.Reservation{
+		RoomID:    1,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Room: models.Room{
+			ID:       1,
+			RoomName: "General's Quarters",
+		},
+	}
 
-	// since it has rooms available, we expect to get status http.StatusSeeOther
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("Post availability with invalid end date gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	tests := []struct {
+		name              string
+		code              string
+		expectedCode      int
+		expectedLocation  string
+		expectedHTML      string
+		expectedPromoCode string
+	}{
+		{"redeemed", " summer10 ", http.StatusSeeOther, "/reservation-summary", "", "SUMMER10"},
+		{"invalid", "10%", http.StatusOK, "", "Invalid promo code", ""},
+		{"unknown", "WINTER", http.StatusOK, "", "Unknown promo code", ""},
+		{"expired", "EXPIRED", http.StatusOK, "", "This promo code has expired", ""},
+		{"used-up", "USEDUP", http.StatusOK, "", "This promo code has been used up", ""},
+		{"too-short", "LONGSTAY", http.StatusOK, "", "This promo code requires a stay of at least 30 nights", ""},
+		{"other-room", "ROOM2", http.StatusOK, "", "This promo code is not valid for this room", ""},
+		{"used-up-in-the-meantime", "RACE", http.StatusOK, "", "This promo code has been used up", ""},
+		{"database-error", "BROKEN", http.StatusSeeOther, "/", "", ""},
 	}
 
-	/*****************************************
-	// 6th case -- database query fails
-	*****************************************/
-	// this time, a start date of 2060-01-01, which will cause
-	// our testdb repo to return an error
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("start_date", "2050-01-01")
+		postData.Add("end_date", "2050-01-03")
+		postData.Add("first_name", "John")
+		postData.Add("last_name", "Smith")
+		postData.Add("email", "john@smith.com")
+		postData.Add("phone", "123456789")
+		postData.Add("room_id", "1")
+		postData.Add("card_number", "4242424242424242")
+		postData.Add("promo_code", e.code)
+
+		req := httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	postData = url.Values{}
-	postData.Add("start", "2060-01-01")
-	postData.Add("end", "2060-01-02")
-	req = httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+		sessionManager.Put(ctx, "reservation", reservation)
 
-	// get the context with session
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
 
-	// set the request header
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
 
-	// create response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr = httptest.NewRecorder()
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
 
-	// make handler a http.HandlerFunc
-	handler = http.HandlerFunc(Repo.PostAvailability)
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
 
-	// make the request to handler
-	handler.ServeHTTP(rr, req)
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
 
-	// since it has rooms available, we expect to get status http.StatusSeeOther
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("Post availability when database query fails gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+		if e.expectedPromoCode != "" {
+			res, _ := sessionManager.Get(ctx, "reservation").(models.Reservation)
+			if res.PromoCode != e.expectedPromoCode || res.Discount == 0 {
+				t.Errorf("failed %s: expected promo code %s to be redeemed, but got %q with discount %d",
+					e.name, e.expectedPromoCode, res.PromoCode, res.Discount)
+			}
+		}
 	}
 }
 
-func TestRepository_AvailabilityJSON(t *testing.T) {
-	/*****************************************
-	// 1st case -- rooms are not available
-	*****************************************/
-	// create request body
-	postData := url.Values{}
-	postData.Add("start", "2050-01-01")
-	postData.Add("end", "2050-01-02")
-	postData.Add("room_id", "1")
+func TestRepository_AddRoom(t *testing.T) {
+	general := models.Reservation{
+		RoomID:     1,
+		StartDate:  time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:    time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Adults:     2,
+		TotalPrice: 20000,
+		Room:       models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
 
-	// create request
-	req := httptest.NewRequest("POST", "/search-availability-json", strings.NewReader(postData.Encode()))
+	tests := []struct {
+		name             string
+		reservation      *models.Reservation
+		rooms            []models.Reservation
+		expectedLocation string
+		expectedRooms    int
+	}{
+		{"added", &general, nil, "/search-availability", 1},
+		{"added-to-others", &general, []models.Reservation{{RoomID: 3, TotalPrice: 30000}}, "/search-availability", 2},
+		{"already-added", &general, []models.Reservation{general}, "/make-reservation", 1},
+		{"not-priced", &models.Reservation{RoomID: 1}, nil, "/", 0},
+		{"no-reservation", nil, nil, "/", 0},
+	}
 
-	// get the context with session
-	ctx := getCtx(req)
-	req = req.WithContext(ctx)
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/make-reservation/add-room", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
 
-	// set the request header
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if e.reservation != nil {
+			sessionManager.Put(ctx, "reservation", *e.reservation)
+		}
+		if e.rooms != nil {
+			sessionManager.Put(ctx, "rooms", e.rooms)
+		}
 
-	// create response recorder, which satisfies the requirements
-	// for http.ResponseWriter
-	rr := httptest.NewRecorder()
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AddRoom)
+		handler.ServeHTTP(rr, req)
 
-	// make the request to our handler
-	handler := http.HandlerFunc(Repo.AvailabilityJSON)
-	handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
 
-	// since it has no rooms available, we expect to get status http.StatusSeeOther
-	// this time we want to parse JSON and get the expected response
-	var j jsonResponse
-	err := json.Unmarshal([]byte(rr.Body.String()), &j)
-	if err != nil {
-		t.Error("failed to parse json")
+		rooms, _ := sessionManager.Get(ctx, "rooms").([]models.Reservation)
+		if len(rooms) != e.expectedRooms {
+			t.Errorf("failed %s: expected %d rooms in the booking but got %d", e.name, e.expectedRooms, len(rooms))
+		}
 	}
 
-	// since we specified a start date > 2049-12-31, we expect no availability
-	if j.OK {
-		t.Error("Got availability when none was expected in AvailabilityJSON")
-	}
-
-	/*****************************************
-	// 2nd case -- rooms not available
-	*****************************************/
-
-	postData = url.Values{}
-	postData.Add("start", "2040-01-01")
-	postData.Add("end", "2040-01-02")
-	postData.Add("room_id", "1")
-
-	req = httptest.NewRequest("POST", "/search-availability-json", strings.NewReader(postData.Encode()))
-	ctx = getCtx(req)
+	// the party is kept for the next search, without the room
+	req := httptest.NewRequest("GET", "/make-reservation/add-room", nil)
+	ctx := getCtx(req)
 	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	sessionManager.Put(ctx, "reservation", general)
 
-	rr = httptest.NewRecorder()
-	handler = http.HandlerFunc(Repo.AvailabilityJSON)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AddRoom)
 	handler.ServeHTTP(rr, req)
 
-	err = json.Unmarshal([]byte(rr.Body.String()), &j)
-	if err != nil {
-		t.Error("failed to parse json")
+	search, _ := sessionManager.Get(ctx, "reservation").(models.Reservation)
+	if search.RoomID != 0 || search.Adults != 2 {
+		t.Errorf("expected the party of 2 adults without room in session but got %+v", search)
 	}
+}
 
-	if !j.OK {
-		t.Error("Got no availability when some was expected in AvailabilityJSON")
+func TestRepository_RemoveRoom(t *testing.T) {
+	rooms := []models.Reservation{{RoomID: 1}, {RoomID: 3}}
+
+	tests := []struct {
+		name             string
+		url              string
+		reservation      bool
+		expectedLocation string
+		expectedRooms    []int
+	}{
+		{"first", "/make-reservation/remove-room/0", true, "/make-reservation", []int{3}},
+		{"last", "/make-reservation/remove-room/1", false, "/search-availability", []int{1}},
+		{"out-of-range", "/make-reservation/remove-room/2", true, "/search-availability", []int{1, 3}},
+		{"invalid", "/make-reservation/remove-room/x", true, "/search-availability", []int{1, 3}},
 	}
 
-	/*****************************************
-	// 3rd case -- no request body
-	*****************************************/
-	req, _ = http.NewRequest("POST", "/search-availability-json", nil)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
 
-	rr = httptest.NewRecorder()
-	handler = http.HandlerFunc(Repo.AvailabilityJSON)
-	handler.ServeHTTP(rr, req)
+		sessionManager.Put(ctx, "rooms", append([]models.Reservation{}, rooms...))
+		if e.reservation {
+			sessionManager.Put(ctx, "reservation", models.Reservation{RoomID: 2})
+		}
 
-	err = json.Unmarshal([]byte(rr.Body.String()), &j)
-	if err != nil {
-		t.Error("failed to parse json")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.RemoveRoom)
+		handler.ServeHTTP(rr, req)
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+
+		left, _ := sessionManager.Get(ctx, "rooms").([]models.Reservation)
+		var ids []int
+		for _, res := range left {
+			ids = append(ids, res.RoomID)
+		}
+		if !reflect.DeepEqual(ids, e.expectedRooms) {
+			t.Errorf("failed %s: expected rooms %v in the booking but got %v", e.name, e.expectedRooms, ids)
+		}
 	}
+}
 
-	if j.OK || j.Message != "Internal Server Error" {
-		t.Error("Got availability when request body was empty")
+// voidSpy is a payment gateway which remembers the payments voided through it
+type voidSpy struct {
+	*payments.FakeGateway
+	voided []string
+}
+
+func (g *voidSpy) Void(reference string) error {
+	g.voided = append(g.voided, reference)
+	return g.FakeGateway.Void(reference)
+}
+
+func TestRepository_PostReservation_ConfirmationFails(t *testing.T) {
+	spy := &voidSpy{FakeGateway: payments.NewFakeGateway()}
+	gateway := testApp.Payments
+	testApp.Payments = spy
+	defer func() { testApp.Payments = gateway }()
+
+	// the third room, reservation 22, can't be confirmed after the deposit was authorized
+	rooms := []models.Reservation{
+		{RoomID: 6, StartDate: time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC), TotalPrice: 30000},
+		{RoomID: 7, StartDate: time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC), TotalPrice: 30000},
+	}
+	reservation := models.Reservation{
+		RoomID:     1,
+		StartDate:  time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:    time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		TotalPrice: 20000,
 	}
 
-	/*****************************************
-	// 4th case -- database error
-	*****************************************/
-	postData = url.Values{}
-	postData.Add("start", "2060-01-01")
-	postData.Add("end", "2060-01-02")
+	postData := url.Values{}
+	postData.Add("first_name", "John")
+	postData.Add("last_name", "Smith")
+	postData.Add("email", "john@smith.com")
+	postData.Add("phone", "123456789")
 	postData.Add("room_id", "1")
+	postData.Add("card_number", "4242424242424242")
 
-	req = httptest.NewRequest("POST", "/search-availability-json", strings.NewReader(postData.Encode()))
-	ctx = getCtx(req)
+	req := httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+	ctx := getCtx(req)
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	rr = httptest.NewRecorder()
-	handler = http.HandlerFunc(Repo.AvailabilityJSON)
+	sessionManager.Put(ctx, "reservation", reservation)
+	sessionManager.Put(ctx, "rooms", rooms)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostReservation)
 	handler.ServeHTTP(rr, req)
 
-	err = json.Unmarshal([]byte(rr.Body.String()), &j)
-	if err != nil {
-		t.Error("failed to parse json!")
+	if location := rr.Header().Get("Location"); location != "/" {
+		t.Errorf("expected location /, but got %s", location)
+	}
+	if !sessionManager.Exists(ctx, "error") {
+		t.Error("expected error message in session")
+	}
+	if _, booked := sessionManager.Get(ctx, "booked_rooms").([]models.Reservation); booked {
+		t.Error("expected no rooms to be booked")
 	}
 
-	// since we specified a start date < 2049-12-31, we expect availability
-	if j.OK || j.Message != "Error connecting to database" {
-		t.Error("Got availability when simulating database error")
+	// the deposit is released, so nothing can be captured from it any more
+	if len(spy.voided) != 1 {
+		t.Fatalf("expected the deposit to be voided once, but got %v", spy.voided)
+	}
+	if err := spy.Capture(spy.voided[0], 1); err != payments.ErrInvalidAmount {
+		t.Errorf("expected the voided deposit not to be capturable, but got %v", err)
 	}
 }
 
-func TestRepository_ReservationSummary(t *testing.T) {
-	/*****************************************
-	// 1st case -- reservation in session
-	*****************************************/
+// paymentSpy is a database which remembers the payments recorded in it
+type paymentSpy struct {
+	repository.DatabaseRepo
+	recorded []models.Payment
+}
+
+func (db *paymentSpy) InsertPayment(p models.Payment) error {
+	db.recorded = append(db.recorded, p)
+	return db.DatabaseRepo.InsertPayment(p)
+}
+
+func TestRepository_PostReservation_DepositShares(t *testing.T) {
+	spy := &paymentSpy{DatabaseRepo: Repo.DB}
+	Repo.DB = spy
+	defer func() { Repo.DB = spy.DatabaseRepo }()
+
+	rooms := []models.Reservation{
+		{RoomID: 6, StartDate: time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC), TotalPrice: 12345},
+	}
 	reservation := models.Reservation{
-		RoomID: 1,
-		Room: models.Room{
-			ID:       1,
-			RoomName: "General's Quarters",
-		},
+		RoomID:     1,
+		StartDate:  time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:    time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		TotalPrice: 20000,
 	}
 
-	req := httptest.NewRequest("GET", "/reservation-summary", nil)
+	postData := url.Values{}
+	postData.Add("first_name", "John")
+	postData.Add("last_name", "Smith")
+	postData.Add("email", "john@smith.com")
+	postData.Add("phone", "123456789")
+	postData.Add("room_id", "1")
+	postData.Add("card_number", "4242424242424242")
+
+	req := httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
 	ctx := getCtx(req)
 	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	rr := httptest.NewRecorder()
 	sessionManager.Put(ctx, "reservation", reservation)
+	sessionManager.Put(ctx, "rooms", rooms)
 
-	handler := http.HandlerFunc(Repo.ReservationSummary)
-
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostReservation)
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusOK {
-		t.Errorf("ReservationSummary handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	if location := rr.Header().Get("Location"); location != "/reservation-summary" {
+		t.Fatalf("expected location /reservation-summary, but got %s", location)
 	}
 
-	/*****************************************
-	// 2nd case -- reservation not in session
-	*****************************************/
-	req = httptest.NewRequest("GET", "/reservation-summary", nil)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
+	// every room records its own share of the one authorization, reservation ids 1 and 21
+	expected := []models.Payment{
+		{ReservationID: 1, Kind: models.PaymentAuthorization, Amount: 3704},
+		{ReservationID: 21, Kind: models.PaymentAuthorization, Amount: 6000},
+	}
+	if len(spy.recorded) != len(expected) {
+		t.Fatalf("expected %d payments to be recorded but got %+v", len(expected), spy.recorded)
+	}
+	for i, p := range spy.recorded {
+		if p.ReservationID != expected[i].ReservationID || p.Kind != expected[i].Kind || p.Amount != expected[i].Amount {
+			t.Errorf("expected payment %+v but got %+v", expected[i], p)
+		}
+		if p.Reference == "" || p.Reference != spy.recorded[0].Reference {
+			t.Errorf("expected the shares to have the reference of the one authorization but got %q", p.Reference)
+		}
+	}
+}
 
-	rr = httptest.NewRecorder()
+func TestRepository_PostReservation_MultipleRooms(t *testing.T) {
+	reservation := models.Reservation{
+		RoomID:     1,
+		StartDate:  time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:    time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		TotalPrice: 20000,
+		Room:       models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
 
-	handler = http.HandlerFunc(Repo.ReservationSummary)
+	tests := []struct {
+		name             string
+		rooms            []models.Reservation
+		expectedLocation string
+		expectedBooked   int
+	}{
+		{
+			"booked",
+			[]models.Reservation{{
+				RoomID:     6,
+				StartDate:  time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+				EndDate:    time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC),
+				TotalPrice: 30000,
+			}},
+			"/reservation-summary",
+			2,
+		},
+		{
+			"one-room-taken",
+			[]models.Reservation{{RoomID: 500, TotalPrice: 30000}},
+			"/search-availability",
+			0,
+		},
+	}
 
-	handler.ServeHTTP(rr, req)
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("first_name", "John")
+		postData.Add("last_name", "Smith")
+		postData.Add("email", "john@smith.com")
+		postData.Add("phone", "123456789")
+		postData.Add("room_id", "1")
+		postData.Add("card_number", "4242424242424242")
 
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("ReservationSummary handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+		req := httptest.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		sessionManager.Put(ctx, "reservation", reservation)
+		sessionManager.Put(ctx, "rooms", e.rooms)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+
+		booked, _ := sessionManager.Get(ctx, "booked_rooms").([]models.Reservation)
+		if len(booked) != e.expectedBooked {
+			t.Errorf("failed %s: expected %d booked rooms but got %d", e.name, e.expectedBooked, len(booked))
+			continue
+		}
+		if e.expectedBooked == 0 {
+			// nothing was booked, so the rooms stay in the booking as they were
+			rooms, _ := sessionManager.Get(ctx, "rooms").([]models.Reservation)
+			if len(rooms) != len(e.rooms) || rooms[0].FirstName != "" {
+				t.Errorf("failed %s: expected the booking to be kept unchanged but got %+v", e.name, rooms)
+			}
+			continue
+		}
+
+		for _, res := range booked {
+			if res.FirstName != "John" || res.Email != "john@smith.com" || res.GroupID != booked[0].ID {
+				t.Errorf("failed %s: expected every room booked for the guest in one group but got %+v", e.name, res)
+			}
+			if res.Status != models.StatusConfirmed {
+				t.Errorf("failed %s: expected every room to be confirmed but got %s", e.name, res.Status)
+			}
+		}
+		if booked[1].RoomID != 1 {
+			t.Errorf("failed %s: expected the room being reserved last but got room %d", e.name, booked[1].RoomID)
+		}
+		if sessionManager.Exists(ctx, "rooms") {
+			t.Errorf("failed %s: expected the booking to be cleared", e.name)
+		}
+		first, _ := sessionManager.Get(ctx, "reservation").(models.Reservation)
+		if first.ID != booked[0].ID {
+			t.Errorf("failed %s: expected the first room in session but got reservation %d", e.name, first.ID)
+		}
 	}
 }
 
-func TestRepository_ChooseRoom(t *testing.T) {
+func TestRepository_PostAvailability(t *testing.T) {
 	/*****************************************
-	// 1st case -- reservation in session
+	// 1st case -- rooms are not available
 	*****************************************/
-	reservation := models.Reservation{
-		RoomID: 1,
-		Room: models.Room{
-			ID:       1,
-			RoomName: "General's Quarters",
-		},
-	}
+	// create request body
+	postData := url.Values{}
+	postData.Add("start", "2050-01-01")
+	postData.Add("end", "2050-01-02")
 
-	req := httptest.NewRequest("GET", "/choose-room/1", nil)
+	// create request
+	req := httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+
+	// get the context with session
 	ctx := getCtx(req)
 	req = req.WithContext(ctx)
-	// set the RequestURI on the request so that we can grab the ID
-	// from the URL
-	req.RequestURI = "/choose-room/1"
 
+	// set the request header
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// create response recorder, which satisfies the requirements
+	// for http.ResponseWriter
 	rr := httptest.NewRecorder()
-	sessionManager.Put(ctx, "reservation", reservation)
 
-	handler := http.HandlerFunc(Repo.ChooseRoom)
+	// make handler a http.HandlerFunc
+	handler := http.HandlerFunc(Repo.PostAvailability)
 
+	// make the request to handler
 	handler.ServeHTTP(rr, req)
 
+	// since it has no rooms available, we expect to get status http.StatusSeeOther
 	if rr.Code != http.StatusSeeOther {
-		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+		t.Errorf("Post availability when no rooms available gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
-	///*****************************************
-	// 2nd case -- reservation not in session
-	//*****************************************/
-	req, _ = http.NewRequest("GET", "/choose-room/1", nil)
+	// the guest is offered to join the waitlist for the dates
+	if search, ok := sessionManager.Get(ctx, "waitlist").(models.Reservation); !ok || search.StartDate.Format("2006-01-02") != "2050-01-01" {
+		t.Error("Post availability when no rooms available did not offer the waitlist")
+	}
+
+	/*****************************************
+	// 2nd case -- rooms are available
+	*****************************************/
+	// this time, we specify a start date before 2040-01-01, which will give us
+	// a non-empty slice, indicating that rooms are available
+	postData = url.Values{}
+	postData.Add("start", "2040-01-01")
+	postData.Add("end", "2040-01-02")
+
+	// create our request
+	req = httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+
+	// get the context with session
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
-	req.RequestURI = "/choose-room/1"
 
+	// set the request header
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// create response recorder, which satisfies the requirements
+	// for http.ResponseWriter
 	rr = httptest.NewRecorder()
 
-	handler = http.HandlerFunc(Repo.ChooseRoom)
+	// make handler a http.HandlerFunc
+	handler = http.HandlerFunc(Repo.PostAvailability)
 
+	// make the request to handler
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	// since it has rooms available, we expect to get status http.StatusOK
+	if rr.Code != http.StatusOK {
+		t.Errorf("Post availability when rooms are available gave wrong status code: got %d, wanted %d", rr.Code, http.StatusOK)
 	}
 
-	///*****************************************
-	// 3rd case -- missing url parameter, or malformed parameter
-	//*****************************************/
-	req, _ = http.NewRequest("GET", "/choose-room/fish", nil)
+	/*****************************************
+	// 3rd case -- empty post body
+	*****************************************/
+	// create request with a nil body, so parsing form fails
+	req, _ = http.NewRequest("POST", "/search-availability", nil)
+
+	// get the context with session
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
-	req.RequestURI = "/choose-room/fish"
 
+	// set the request header
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// create response recorder, which satisfies the requirements
+	// for http.ResponseWriter
 	rr = httptest.NewRecorder()
 
-	handler = http.HandlerFunc(Repo.ChooseRoom)
+	// make handler a http.HandlerFunc
+	handler = http.HandlerFunc(Repo.PostAvailability)
 
+	// make the request to our handler
 	handler.ServeHTTP(rr, req)
 
+	// since it has rooms available, we expect to get status http.StatusSeeOther
 	if rr.Code != http.StatusSeeOther {
-		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+		t.Errorf("Post availability with empty request body (nil) gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
-}
 
-func TestRepository_BookRoom(t *testing.T) {
 	/*****************************************
-	// 1st case -- database works
+	// 4th case -- start date in wrong format
 	*****************************************/
-	reservation := models.Reservation{
-		RoomID: 1,
-		Room: models.Room{
-			ID:       1,
-			RoomName: "General's Quarters",
-		},
+	// this time,a start date in the wrong format
+	postData = url.Values{}
+	postData.Add("start", "invalid")
+	postData.Add("end", "2040-01-02")
+
+	req = httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+
+	// get the context with session
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	// set the request header
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// create response recorder, which satisfies the requirements
+	// for http.ResponseWriter
+	rr = httptest.NewRecorder()
+
+	// make handler a http.HandlerFunc
+	handler = http.HandlerFunc(Repo.PostAvailability)
+
+	// make the request to handler
+	handler.ServeHTTP(rr, req)
+
+	// since it has rooms available, we expect to get status http.StatusSeeOther
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Post availability with invalid start date gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
-	req, _ := http.NewRequest("GET", "/book-room?s=2050-01-01&e=2050-01-02&id=1", nil)
-	ctx := getCtx(req)
+	/*****************************************
+	// 5th case -- end date in wrong format
+	*****************************************/
+	// this time, an end date in the wrong format
+	postData = url.Values{}
+	postData.Add("start", "2040-01-01")
+	postData.Add("end", "invalid")
+
+	req = httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+
+	// get the context with session
+	ctx = getCtx(req)
 	req = req.WithContext(ctx)
 
-	rr := httptest.NewRecorder()
-	sessionManager.Put(ctx, "reservation", reservation)
+	// set the request header
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	handler := http.HandlerFunc(Repo.BookRoom)
+	// create response recorder, which satisfies the requirements
+	// for http.ResponseWriter
+	rr = httptest.NewRecorder()
+
+	// make handler a http.HandlerFunc
+	handler = http.HandlerFunc(Repo.PostAvailability)
 
+	// make the request to handler
 	handler.ServeHTTP(rr, req)
 
+	// since it has rooms available, we expect to get status http.StatusSeeOther
 	if rr.Code != http.StatusSeeOther {
-		t.Errorf("BookRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+		t.Errorf("Post availability with invalid end date gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
 	/*****************************************
-	// 2nd case -- database failed
+	// 6th case -- database query fails
 	*****************************************/
-	req, _ = http.NewRequest("GET", "/book-room?s=2040-01-01&e=2040-01-02&id=4", nil)
+	// this time, a start date of 2060-01-01, which will cause
+	// our testdb repo to return an error
+
+	postData = url.Values{}
+	postData.Add("start", "2060-01-01")
+	postData.Add("end", "2060-01-02")
+	req = httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+
+	// get the context with session
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
 
+	// set the request header
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// create response recorder, which satisfies the requirements
+	// for http.ResponseWriter
 	rr = httptest.NewRecorder()
 
-	handler = http.HandlerFunc(Repo.BookRoom)
+	// make handler a http.HandlerFunc
+	handler = http.HandlerFunc(Repo.PostAvailability)
 
+	// make the request to handler
 	handler.ServeHTTP(rr, req)
 
+	// since it has rooms available, we expect to get status http.StatusSeeOther
 	if rr.Code != http.StatusSeeOther {
-		t.Errorf("BookRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+		t.Errorf("Post availability when database query fails gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 }
 
-// loginTests is the data for the Login handler tests
-var loginTests = []struct {
-	name               string
-	email              string
-	expectedStatusCode int
-	expectedHTML       string
-	expectedLocation   string
-}{
-	{
-		"valid-credentials",
-		"me@here.com",
-		http.StatusSeeOther,
-		"",
-		"/",
-	},
-	{
-		"invalid-credentials",
-		"jack@nimble.com",
-		http.StatusSeeOther,
-		"",
-		"/user/login",
-	},
-	{
-		"invalid-data",
-		"j",
-		http.StatusOK,
-		`action="/user/login"`,
-		"",
-	},
+func TestRepository_PostAvailabilityPartySize(t *testing.T) {
+	var tests = []struct {
+		name         string
+		adults       string
+		children     string
+		expectedCode int
+	}{
+		{"fits", "2", "1", http.StatusOK},
+		{"too-large", "6", "0", http.StatusSeeOther},
+		{"invalid-adults", "none", "0", http.StatusSeeOther},
+		{"invalid-children", "2", "-1", http.StatusSeeOther},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("start", "2040-01-01")
+		postData.Add("end", "2040-01-02")
+		postData.Add("adults", e.adults)
+		postData.Add("children", e.children)
+
+		req := httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostAvailability)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
 }
 
-func TestRepository_PostLogin(t *testing.T) {
-	// range through all tests
-	for _, e := range loginTests {
-		postedData := url.Values{}
-		postedData.Add("email", e.email)
-		postedData.Add("password", "password")
+func TestRepository_PostAvailabilityStayRules(t *testing.T) {
+	postData := url.Values{}
+	postData.Add("start", "2049-12-24")
+	postData.Add("end", "2049-12-26")
+
+	req := httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.PostAvailability)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostAvailability handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	msg := sessionManager.GetString(ctx, "error")
+	if !strings.Contains(msg, "Arrivals are not possible on 2049-12-24") {
+		t.Errorf("expected the stay rule to be explained, but got %q", msg)
+	}
+}
+
+func TestRepository_PostAvailabilityFlexible(t *testing.T) {
+	var tests = []struct {
+		name          string
+		start         string
+		end           string
+		flexibility   string
+		nights        string
+		adults        string
+		expectedCode  int
+		expectedStays []string
+	}{
+		{"days", "2050-01-10", "2050-01-12", "3", "", "2", http.StatusOK,
+			[]string{"2050-01-10 to 2050-01-12", "2050-01-09 to 2050-01-11", "2050-01-11 to 2050-01-13"}},
+		{"same-weekday-in-month", "2050-06-17", "2050-06-19", "month", "", "2", http.StatusOK,
+			[]string{"2050-06-17 to 2050-06-19", "2050-06-10 to 2050-06-12", "2050-06-24 to 2050-06-26"}},
+		{"nights", "2050-01-10", "2050-01-12", "1", "4", "2", http.StatusOK,
+			[]string{"2050-01-10 to 2050-01-14"}},
+		{"invalid-flexibility", "2050-01-10", "2050-01-12", "30", "", "2", http.StatusSeeOther, nil},
+		{"invalid-nights", "2050-01-10", "2050-01-12", "3", "0", "2", http.StatusSeeOther, nil},
+		{"past", "2000-01-10", "2000-01-12", "3", "", "2", http.StatusSeeOther, nil},
+		{"too-large", "2050-01-10", "2050-01-12", "3", "", "6", http.StatusSeeOther, nil},
+		{"database-error", "2060-01-10", "2060-01-12", "3", "", "2", http.StatusSeeOther, nil},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("start", e.start)
+		postData.Add("end", e.end)
+		postData.Add("flexibility", e.flexibility)
+		postData.Add("nights", e.nights)
+		postData.Add("adults", e.adults)
+
+		req := httptest.NewRequest("POST", "/search-availability", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostAvailability)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		for _, stay := range e.expectedStays {
+			if !strings.Contains(rr.Body.String(), stay) {
+				t.Errorf("failed %s: expected to find the stay %s but did not", e.name, stay)
+			}
+		}
+	}
+}
+
+func TestRepository_AvailabilityJSONStayRules(t *testing.T) {
+	postData := url.Values{}
+	postData.Add("start", "2049-12-24")
+	postData.Add("end", "2049-12-26")
+	postData.Add("room_id", "1")
+
+	req := httptest.NewRequest("POST", "/search-availability-json", strings.NewReader(postData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AvailabilityJSON)
+	handler.ServeHTTP(rr, req)
+
+	var j jsonResponse
+	err := json.Unmarshal([]byte(rr.Body.String()), &j)
+	if err != nil {
+		t.Error("failed to parse json")
+	}
+
+	if j.OK {
+		t.Error("Got availability when a stay rule should have rejected the stay")
+	}
+	if j.Message != "Arrivals are not possible on 2049-12-24" {
+		t.Errorf("expected the stay rule as message, but got %q", j.Message)
+	}
+}
+
+func TestRepository_AvailabilityJSONInvalidInput(t *testing.T) {
+	tests := []struct {
+		name            string
+		postedData      url.Values
+		expectedMessage string
+	}{
+		{"missing-start", url.Values{"end": {"2050-01-02"}, "room_id": {"1"}}, "Invalid arrival date"},
+		{"invalid-end", url.Values{"start": {"2050-01-01"}, "end": {"tomorrow"}, "room_id": {"1"}}, "Invalid departure date"},
+		{"reversed", url.Values{"start": {"2050-01-02"}, "end": {"2050-01-01"}, "room_id": {"1"}}, "Departure must be after arrival"},
+		{"invalid-room", url.Values{"start": {"2050-01-01"}, "end": {"2050-01-02"}, "room_id": {"x"}}, "Invalid room id"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/search-availability-json", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AvailabilityJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusBadRequest, rr.Code)
+		}
+
+		var j jsonResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &j)
+		if err != nil {
+			t.Errorf("failed %s: failed to parse json", e.name)
+		}
+		if j.OK || j.Message != e.expectedMessage {
+			t.Errorf("failed %s: expected message %q, but got %q", e.name, e.expectedMessage, j.Message)
+		}
+	}
+}
+
+func TestRepository_RoomAvailabilityJSON(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/rooms/1/availability?from=2050-01-01&to=2050-01-08", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.RoomAvailabilityJSON)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("RoomAvailabilityJSON handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	var j roomCalendar
+	err := json.Unmarshal(rr.Body.Bytes(), &j)
+	if err != nil {
+		t.Fatal("failed to parse json")
+	}
+
+	// 2050-01-01 is a saturday and 2050-01-07 a friday, priced 20% higher by the test rate plan
+	expected := []calendarNight{
+		{Date: "2050-01-01", Status: booking.NightFree, Price: 12000},
+		{Date: "2050-01-02", Status: booking.NightReserved, Price: 10000},
+		{Date: "2050-01-03", Status: booking.NightReserved, Price: 10000},
+		{Date: "2050-01-04", Status: booking.NightBlocked, Price: 10000},
+		{Date: "2050-01-05", Status: booking.NightRuleRestricted, Price: 10000, ClosedToArrival: true},
+		{Date: "2050-01-06", Status: booking.NightHeld, Price: 10000},
+		{Date: "2050-01-07", Status: booking.NightFree, Price: 12000},
+	}
+	if len(j.Nights) != len(expected) {
+		t.Fatalf("expected %d nights, but got %d", len(expected), len(j.Nights))
+	}
+	for i, night := range j.Nights {
+		if night != expected[i] {
+			t.Errorf("expected night %+v, but got %+v", expected[i], night)
+		}
+	}
+}
+
+func TestRepository_RoomAvailabilityJSONErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          string
+		expectedCode int
+	}{
+		{"invalid-room-id", "/api/v1/rooms/x/availability?from=2050-01-01&to=2050-01-07", http.StatusBadRequest},
+		{"missing-from", "/api/v1/rooms/1/availability?to=2050-01-07", http.StatusBadRequest},
+		{"invalid-to", "/api/v1/rooms/1/availability?from=2050-01-01&to=2050-13-01", http.StatusBadRequest},
+		{"reversed", "/api/v1/rooms/1/availability?from=2050-01-07&to=2050-01-01", http.StatusBadRequest},
+		{"too-long", "/api/v1/rooms/1/availability?from=2050-01-01&to=2052-01-01", http.StatusBadRequest},
+		{"unknown-room", "/api/v1/rooms/3/availability?from=2050-01-01&to=2050-01-07", http.StatusNotFound},
+		{"room-query-fails", "/api/v1/rooms/4/availability?from=2050-01-01&to=2050-01-07", http.StatusInternalServerError},
+		{"retired-room", "/api/v1/rooms/5/availability?from=2050-01-01&to=2050-01-07", http.StatusNotFound},
+		{"database-error", "/api/v1/rooms/2/availability?from=2050-01-01&to=2050-01-07", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.RoomAvailabilityJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		var j jsonResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &j)
+		if err != nil || j.OK || j.Message == "" {
+			t.Errorf("failed %s: expected a json error message", e.name)
+		}
+	}
+}
+
+func TestRepository_AvailabilityJSON(t *testing.T) {
+	/*****************************************
+	// 1st case -- rooms are not available
+	*****************************************/
+	// create request body
+	postData := url.Values{}
+	postData.Add("start", "2050-01-01")
+	postData.Add("end", "2050-01-02")
+	postData.Add("room_id", "1")
+
+	// create request
+	req := httptest.NewRequest("POST", "/search-availability-json", strings.NewReader(postData.Encode()))
+
+	// get the context with session
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	// set the request header
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// create response recorder, which satisfies the requirements
+	// for http.ResponseWriter
+	rr := httptest.NewRecorder()
+
+	// make the request to our handler
+	handler := http.HandlerFunc(Repo.AvailabilityJSON)
+	handler.ServeHTTP(rr, req)
+
+	// since it has no rooms available, we expect to get status http.StatusSeeOther
+	// this time we want to parse JSON and get the expected response
+	var j jsonResponse
+	err := json.Unmarshal([]byte(rr.Body.String()), &j)
+	if err != nil {
+		t.Error("failed to parse json")
+	}
+
+	// since we specified a start date > 2049-12-31, we expect no availability
+	if j.OK {
+		t.Error("Got availability when none was expected in AvailabilityJSON")
+	}
+
+	/*****************************************
+	// 2nd case -- rooms not available
+	*****************************************/
+
+	postData = url.Values{}
+	postData.Add("start", "2040-01-01")
+	postData.Add("end", "2040-01-02")
+	postData.Add("room_id", "1")
+
+	req = httptest.NewRequest("POST", "/search-availability-json", strings.NewReader(postData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.AvailabilityJSON)
+	handler.ServeHTTP(rr, req)
+
+	err = json.Unmarshal([]byte(rr.Body.String()), &j)
+	if err != nil {
+		t.Error("failed to parse json")
+	}
+
+	if !j.OK {
+		t.Error("Got no availability when some was expected in AvailabilityJSON")
+	}
+
+	/*****************************************
+	// 3rd case -- no request body
+	*****************************************/
+	req, _ = http.NewRequest("POST", "/search-availability-json", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.AvailabilityJSON)
+	handler.ServeHTTP(rr, req)
+
+	err = json.Unmarshal([]byte(rr.Body.String()), &j)
+	if err != nil {
+		t.Error("failed to parse json")
+	}
+
+	if j.OK || j.Message != "Internal Server Error" {
+		t.Error("Got availability when request body was empty")
+	}
+
+	/*****************************************
+	// 4th case -- database error
+	*****************************************/
+	postData = url.Values{}
+	postData.Add("start", "2060-01-01")
+	postData.Add("end", "2060-01-02")
+	postData.Add("room_id", "1")
+
+	req = httptest.NewRequest("POST", "/search-availability-json", strings.NewReader(postData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.AvailabilityJSON)
+	handler.ServeHTTP(rr, req)
+
+	err = json.Unmarshal([]byte(rr.Body.String()), &j)
+	if err != nil {
+		t.Error("failed to parse json!")
+	}
+
+	// since we specified a start date < 2049-12-31, we expect availability
+	if j.OK || j.Message != "Error connecting to database" {
+		t.Error("Got availability when simulating database error")
+	}
+}
+
+func TestRepository_ReservationSummary(t *testing.T) {
+	/*****************************************
+	// 1st case -- reservation in session
+	*****************************************/
+	reservation := models.Reservation{
+		RoomID: 1,
+		Room: models.Room{
+			ID:       1,
+			RoomName: "General's Quarters",
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/reservation-summary", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	sessionManager.Put(ctx, "reservation", reservation)
+
+	handler := http.HandlerFunc(Repo.ReservationSummary)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("ReservationSummary handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	/*****************************************
+	// 2nd case -- reservation not in session
+	*****************************************/
+	req = httptest.NewRequest("GET", "/reservation-summary", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.ReservationSummary)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("ReservationSummary handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	/*****************************************
+	// 3rd case -- several rooms were booked together
+	*****************************************/
+	req = httptest.NewRequest("GET", "/reservation-summary", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr = httptest.NewRecorder()
+	sessionManager.Put(ctx, "reservation", reservation)
+	sessionManager.Put(ctx, "booked_rooms", []models.Reservation{
+		{ID: 1, TotalPrice: 20000, Room: models.Room{RoomName: "General's Quarters"}},
+		{ID: 2, TotalPrice: 30000, Room: models.Room{RoomName: "Major's Suite"}},
+	})
+
+	handler.ServeHTTP(rr, req)
+
+	for _, want := range []string{"General&#39;s Quarters", "Major&#39;s Suite", "500.00"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("ReservationSummary handler did not show %s", want)
+		}
+	}
+	if sessionManager.Exists(ctx, "booked_rooms") {
+		t.Error("ReservationSummary handler did not remove the booked rooms from session")
+	}
+}
+
+func TestRepository_ChooseRoom(t *testing.T) {
+	/*****************************************
+	// 1st case -- reservation in session
+	*****************************************/
+	reservation := models.Reservation{
+		RoomID: 1,
+		Room: models.Room{
+			ID:       1,
+			RoomName: "General's Quarters",
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/choose-room/1", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	// set the RequestURI on the request so that we can grab the ID
+	// from the URL
+	req.RequestURI = "/choose-room/1"
+
+	rr := httptest.NewRecorder()
+	sessionManager.Put(ctx, "reservation", reservation)
+
+	handler := http.HandlerFunc(Repo.ChooseRoom)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	///*****************************************
+	// 2nd case -- reservation not in session
+	//*****************************************/
+	req, _ = http.NewRequest("GET", "/choose-room/1", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/choose-room/1"
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.ChooseRoom)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	///*****************************************
+	// 3rd case -- missing url parameter, or malformed parameter
+	//*****************************************/
+	req, _ = http.NewRequest("GET", "/choose-room/fish", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/choose-room/fish"
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.ChooseRoom)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+}
+
+func TestRepository_ChooseRoomHold(t *testing.T) {
+	tests := []struct {
+		name             string
+		url              string
+		previousHold     string
+		adults           int
+		expectedLocation string
+		expectedHold     bool
+	}{
+		{"held", "/choose-room/1", "", 2, "/make-reservation", true},
+		{"replaces-previous-hold", "/choose-room/1", "abc", 2, "/make-reservation", true},
+		{"previous-hold-not-released", "/choose-room/1", "broken", 2, "/make-reservation", true},
+		{"taken-in-the-meantime", "/choose-room/500", "", 2, "/search-availability", false},
+		{"database-error", "/choose-room/1000", "", 2, "/", false},
+		{"retired-room", "/choose-room/5", "", 2, "/search-availability", false},
+		{"room-query-fails", "/choose-room/4", "", 2, "/", false},
+		{"party-too-big", "/choose-room/1", "", 3, "/search-availability", false},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		sessionManager.Put(ctx, "reservation", models.Reservation{HoldToken: e.previousHold, Adults: e.adults})
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.ChooseRoom)
+		handler.ServeHTTP(rr, req)
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+
+		if !e.expectedHold {
+			continue
+		}
+		res, _ := sessionManager.Get(ctx, "reservation").(models.Reservation)
+		if res.HoldToken == "" || res.HoldToken == e.previousHold {
+			t.Errorf("failed %s: expected a new hold in session but got %q", e.name, res.HoldToken)
+		}
+		if until := time.Until(res.HoldExpiresAt); until < 14*time.Minute || until > holdDuration {
+			t.Errorf("failed %s: expected the hold to expire in %s but got %s", e.name, holdDuration, until)
+		}
+	}
+}
+
+func TestRepository_BookRoom(t *testing.T) {
+	/*****************************************
+	// 1st case -- database works
+	*****************************************/
+	reservation := models.Reservation{
+		RoomID: 1,
+		Room: models.Room{
+			ID:       1,
+			RoomName: "General's Quarters",
+		},
+	}
+
+	req, _ := http.NewRequest("GET", "/book-room?s=2050-01-01&e=2050-01-02&id=1", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	sessionManager.Put(ctx, "reservation", reservation)
+
+	handler := http.HandlerFunc(Repo.BookRoom)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("BookRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	/*****************************************
+	// 2nd case -- the party of the search is kept
+	*****************************************/
+	req, _ = http.NewRequest("GET", "/book-room?s=2050-01-01&e=2050-01-02&id=1", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr = httptest.NewRecorder()
+	sessionManager.Put(ctx, "reservation", models.Reservation{Adults: 2, Children: 1})
+
+	handler = http.HandlerFunc(Repo.BookRoom)
+
+	handler.ServeHTTP(rr, req)
+
+	booked, _ := sessionManager.Get(ctx, "reservation").(models.Reservation)
+	if booked.Adults != 2 || booked.Children != 1 || booked.RoomID != 1 {
+		t.Errorf("BookRoom handler did not keep the party of the search: got %d adults and %d children in room %d",
+			booked.Adults, booked.Children, booked.RoomID)
+	}
+	if booked.HoldToken == "" {
+		t.Error("BookRoom handler did not hold the room")
+	}
+
+	/*****************************************
+	// 3rd case -- database failed
+	*****************************************/
+	req, _ = http.NewRequest("GET", "/book-room?s=2040-01-01&e=2040-01-02&id=4", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.BookRoom)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("BookRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+}
+
+func TestRepository_BookRoomRejected(t *testing.T) {
+	tests := []struct {
+		name             string
+		url              string
+		adults           int
+		expectedLocation string
+		expectedError    string
+	}{
+		{"retired-room", "/book-room?s=2050-01-01&e=2050-01-02&id=5", 2, "/", "This room is no longer available"},
+		{"party-too-big", "/book-room?s=2050-01-01&e=2050-01-02&id=1", 3, "/search-availability", "This room doesn't fit your party"},
+		{"invalid-start-date", "/book-room?s=2050-13-01&e=2050-01-02&id=1", 2, "/", "Can't parse start date"},
+		{"missing-end-date", "/book-room?s=2050-01-01&id=1", 2, "/", "Can't parse end date"},
+		{"reversed-dates", "/book-room?s=2050-01-02&e=2050-01-01&id=1", 2, "/", "Departure must be after arrival"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		sessionManager.Put(ctx, "reservation", models.Reservation{Adults: e.adults})
+
+		handler := http.HandlerFunc(Repo.BookRoom)
+		handler.ServeHTTP(rr, req)
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+		if msg := sessionManager.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q but got %q", e.name, e.expectedError, msg)
+		}
+		if res, _ := sessionManager.Get(ctx, "reservation").(models.Reservation); res.HoldToken != "" {
+			t.Errorf("failed %s: expected no hold but got %q", e.name, res.HoldToken)
+		}
+	}
+}
+
+// loginTests is the data for the Login handler tests
+var loginTests = []struct {
+	name               string
+	email              string
+	expectedStatusCode int
+	expectedHTML       string
+	expectedLocation   string
+}{
+	{
+		"valid-credentials",
+		"me@here.com",
+		http.StatusSeeOther,
+		"",
+		"/",
+	},
+	{
+		"email-case-differs",
+		"ME@Here.com",
+		http.StatusSeeOther,
+		"",
+		"/",
+	},
+	{
+		"invalid-credentials",
+		"jack@nimble.com",
+		http.StatusSeeOther,
+		"",
+		"/user/login",
+	},
+	{
+		"invalid-data",
+		"j",
+		http.StatusOK,
+		`action="/user/login"`,
+		"",
+	},
+	{
+		"guest",
+		"guest@here.com",
+		http.StatusSeeOther,
+		"",
+		"/account/bookings",
+	},
+	{
+		"unverified-guest",
+		"unverified@here.com",
+		http.StatusSeeOther,
+		"",
+		"/user/login",
+	},
+	{
+		"user-missing",
+		"missing@here.com",
+		http.StatusSeeOther,
+		"",
+		"/user/login",
+	},
+}
+
+func TestRepository_PostLogin(t *testing.T) {
+	// range through all tests
+	for _, e := range loginTests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		postedData.Add("password", "password")
+
+		// create request
+		req := httptest.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		// set the header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		// call the handler
+		handler := http.HandlerFunc(Repo.PostLogin)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			// get the URL from test
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		// checking for expected values in HTML
+		if e.expectedHTML != "" {
+			// read the response body into a string
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+func TestRepository_PostRegister(t *testing.T) {
+	tests := []struct {
+		name               string
+		email              string
+		password           string
+		confirm            string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedHTML       string
+	}{
+		{"registered", "new@here.com", "Secret-Passw0rd", "Secret-Passw0rd", http.StatusSeeOther, "/user/login", ""},
+		{"short-password", "new@here.com", "Secr3t", "Secr3t", http.StatusOK, "", "Use at least 8 characters"},
+		{"weak-password", "new@here.com", "secret-password", "secret-password", http.StatusOK, "", "Use at least 8 characters"},
+		{"passwords-differ", "new@here.com", "Secret-Passw0rd", "Other-Passw0rd", http.StatusOK, "", "This field does not match"},
+		{"invalid-email", "new", "Secret-Passw0rd", "Secret-Passw0rd", http.StatusOK, "", "Invalid email address"},
+		{"duplicate-email", "guest@here.com", "Secret-Passw0rd", "Secret-Passw0rd", http.StatusOK, "", "An account with this email already exists"},
+		{"duplicate-email-case-differs", "Guest@Here.com", "Secret-Passw0rd", "Secret-Passw0rd", http.StatusOK, "", "An account with this email already exists"},
+		{"insert-fails", "fail@here.com", "Secret-Passw0rd", "Secret-Passw0rd", http.StatusSeeOther, "/user/register", ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", e.email)
+		postedData.Add("password", e.password)
+		postedData.Add("password_confirm", e.confirm)
+
+		req := httptest.NewRequest("POST", "/user/register", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostRegister)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, location)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_VerifyEmail(t *testing.T) {
+	tests := []struct {
+		name             string
+		token            string
+		expectedLocation string
+		expectedFlash    string
+	}{
+		{"verified", testApp.Signer.Sign(verifyEmailPurpose, 3, time.Now().Add(time.Hour)), "/user/login", "success"},
+		{"link-expired", testApp.Signer.Sign(verifyEmailPurpose, 3, time.Now().Add(-time.Hour)), "/user/login", "error"},
+		{"other-purpose", testApp.Signer.Sign(manageReservationPurpose, 3, time.Now().Add(time.Hour)), "/user/login", "error"},
+		{"not-found", testApp.Signer.Sign(verifyEmailPurpose, 42, time.Now().Add(time.Hour)), "/user/login", "error"},
+		{"update-fails", testApp.Signer.Sign(verifyEmailPurpose, 99, time.Now().Add(time.Hour)), "/", "error"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/user/verify/"+e.token, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.VerifyEmail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, location)
+		}
+		if !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+	}
+}
+
+func TestRepository_PostForgotPassword(t *testing.T) {
+	tests := []struct {
+		name               string
+		email              string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedFlash      string
+	}{
+		{"link-sent", "me@here.com", http.StatusSeeOther, "/user/login", "success"},
+		// nobody can find out whether an address is registered
+		{"not-registered", "nobody@here.com", http.StatusSeeOther, "/user/login", "success"},
+		{"invalid-email", "nobody", http.StatusOK, "", ""},
+		{"lookup-fails", "fail@here.com", http.StatusSeeOther, "/user/forgot-password", "error"},
+		{"store-fails", "broken@here.com", http.StatusSeeOther, "/user/forgot-password", "error"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+
+		req := httptest.NewRequest("POST", "/user/forgot-password", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostForgotPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, location)
+		}
+		if e.expectedFlash != "" && !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+	}
+}
+
+// resetSpy is a database which remembers the users password reset tokens were stored for
+type resetSpy struct {
+	repository.DatabaseRepo
+	userIDs []int
+}
+
+func (db *resetSpy) InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+	db.userIDs = append(db.userIDs, userID)
+	return db.DatabaseRepo.InsertPasswordReset(userID, tokenHash, expiresAt)
+}
+
+func TestRepository_PostForgotPassword_EmailCaseDiffers(t *testing.T) {
+	spy := &resetSpy{DatabaseRepo: Repo.DB}
+	Repo.DB = spy
+	defer func() { Repo.DB = spy.DatabaseRepo }()
+
+	// user 1 registered as me@here.com
+	postedData := url.Values{}
+	postedData.Add("email", "ME@Here.COM")
+
+	req := httptest.NewRequest("POST", "/user/forgot-password", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.PostForgotPassword)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if len(spy.userIDs) != 1 || spy.userIDs[0] != 1 {
+		t.Errorf("expected a reset token for user 1, but got one for %v", spy.userIDs)
+	}
+}
+
+func TestRepository_ShowResetPassword(t *testing.T) {
+	tests := []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid", "valid-reset", http.StatusOK, ""},
+		{"used-or-expired", "other-reset", http.StatusSeeOther, "/user/forgot-password"},
+		{"lookup-fails", "fail-reset", http.StatusSeeOther, "/"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/user/reset-password/"+e.token, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ShowResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, location)
+		}
+		if e.expectedStatusCode == http.StatusOK && !strings.Contains(rr.Body.String(), `action="/user/reset-password/valid-reset"`) {
+			t.Errorf("failed %s: expected the form to post back to the link", e.name)
+		}
+	}
+}
+
+func TestRepository_PostResetPassword(t *testing.T) {
+	tests := []struct {
+		name               string
+		token              string
+		password           string
+		confirm            string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedHTML       string
+	}{
+		{"reset", "valid-reset", "Secret-Passw0rd", "Secret-Passw0rd", http.StatusSeeOther, "/user/login", ""},
+		{"weak-password", "valid-reset", "password", "password", http.StatusOK, "", "Use at least 8 characters"},
+		{"passwords-differ", "valid-reset", "Secret-Passw0rd", "Other-Passw0rd", http.StatusOK, "", "This field does not match"},
+		{"used-or-expired", "other-reset", "Secret-Passw0rd", "Secret-Passw0rd", http.StatusSeeOther, "/user/forgot-password", ""},
+		{"reset-fails", "fail-reset", "Secret-Passw0rd", "Secret-Passw0rd", http.StatusSeeOther, "/", ""},
+	}
+
+	for _, e := range tests {
+		// the user the link was sent to is logged in elsewhere, and so is another user
+		userSession := loggedInSession(t, 1)
+		otherSession := loggedInSession(t, 2)
+
+		postedData := url.Values{}
+		postedData.Add("password", e.password)
+		postedData.Add("password_confirm", e.confirm)
+
+		req := httptest.NewRequest("POST", "/user/reset-password/"+e.token, strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, location)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+
+		_, userLoggedIn, _ := sessionManager.Store.Find(userSession)
+		if reset := e.expectedLocation == "/user/login"; userLoggedIn == reset {
+			t.Errorf("failed %s: expected the user to be logged out everywhere to be %t", e.name, reset)
+		}
+		if _, found, _ := sessionManager.Store.Find(otherSession); !found {
+			t.Errorf("failed %s: expected the other user to stay logged in", e.name)
+		}
+	}
+}
+
+// loggedInSession stores a session of the user, as if logged in with another browser, and returns its token
+func loggedInSession(t *testing.T, userID int) string {
+	ctx, err := sessionManager.Load(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionManager.Put(ctx, "user_id", userID)
+
+	token, _, err := sessionManager.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRepository_AccountBookings(t *testing.T) {
+	tests := []struct {
+		name               string
+		userID             int
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"bookings", 2, http.StatusOK, ""},
+		{"user-missing", 4, http.StatusSeeOther, "/"},
+		{"bookings-fail", 5, http.StatusSeeOther, "/"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/account/bookings", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		sessionManager.Put(ctx, "user_id", e.userID)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AccountBookings)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, location)
+		}
+
+		if e.expectedStatusCode == http.StatusOK {
+			html := rr.Body.String()
+			upcoming := strings.Index(html, "UPCOMING")
+			past := strings.Index(html, "PASTSTAY")
+			// the upcoming stay can be managed, the past one is listed after it
+			if upcoming < 0 || past < upcoming || !strings.Contains(html, "/reservations/manage/") {
+				t.Errorf("failed %s: expected the upcoming stay before the past one", e.name)
+			}
+			if strings.Count(html, "/reservations/manage/") != 1 {
+				t.Errorf("failed %s: expected a manage link for the upcoming stay only", e.name)
+			}
+		}
+	}
+}
+
+var adminPostShowReservationTests = []struct {
+	name                 string
+	url                  string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name: "valid-data-from-new",
+		url:  "/admin/reservations/new/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-new",
+		expectedHTML:         "",
+	},
+	{
+		name: "valid-data-from-all",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-all",
+		expectedHTML:         "",
+	},
+	{
+		name: "valid-data-from-cal",
+		url:  "/admin/reservations/cal/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"year":       {"2022"},
+			"month":      {"01"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-calendar?y=2022&m=01",
+		expectedHTML:         "",
+	},
+}
+
+func TestRepository_AdminPostShowReservation(t *testing.T) {
+	for _, e := range adminPostShowReservationTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req = httptest.NewRequest("POST", "/user/login", strings.NewReader(e.postedData.Encode()))
+		} else {
+			req = httptest.NewRequest("POST", "/user/login", nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		// set the header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		// call the handler
+		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			// get the URL from test
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		// checking for expected values in HTML
+		if e.expectedHTML != "" {
+			// read the response body into a string
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+var adminPostReservationCalendarTests = []struct {
+	name                 string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+	blocks               int
+	reservations         int
+}{
+	{
+		name: "cal",
+		postedData: url.Values{
+			"year":  {time.Now().Format("2006")},
+			"month": {time.Now().Format("01")},
+			fmt.Sprintf("add_block_1_%s", time.Now().AddDate(0, 0, 2).Format("2006-01-2")): {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name: "cal-block-unavailable",
+		postedData: url.Values{
+			"year":  {time.Now().Format("2006")},
+			"month": {time.Now().Format("01")},
+			fmt.Sprintf("add_block_500_%s", time.Now().AddDate(0, 0, 2).Format("2006-01-2")): {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name:                 "cal-blocks",
+		postedData:           url.Values{},
+		expectedResponseCode: http.StatusSeeOther,
+		blocks:               1,
+	},
+	{
+		name:                 "cal-res",
+		postedData:           url.Values{},
+		expectedResponseCode: http.StatusSeeOther,
+		reservations:         1,
+	},
+}
+
+func TestRepository_AdminShowReservation_Permissions(t *testing.T) {
+	tests := []struct {
+		name          string
+		role          models.Role
+		expectActions bool
+	}{
+		{"housekeeping", models.RoleHousekeeping, false},
+		{"front-desk", models.RoleFrontDesk, true},
+		{"owner", models.RoleOwner, true},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/admin/reservations/all/1/show", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		sessionManager.Put(ctx, "user_id", 1)
+		sessionManager.Put(ctx, "access_level", int(e.role))
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+
+		// the reservation can be seen by everyone, but only changed by those allowed to
+		html := rr.Body.String()
+		if !strings.Contains(html, "Confirmation Code") {
+			t.Errorf("failed %s: expected the reservation to be shown", e.name)
+		}
+		hasActions := strings.Contains(html, `value="Save"`) && strings.Contains(html, "Mark as") &&
+			strings.Contains(html, `action="/admin/reservations/all/1/capture`)
+		if hasActions != e.expectActions {
+			t.Errorf("failed %s: expected actions shown to be %t, but got %t", e.name, e.expectActions, hasActions)
+		}
+	}
+}
+
+func TestRepository_AdminReservationsCalendar(t *testing.T) {
+	for _, e := range adminPostReservationCalendarTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req = httptest.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
+		} else {
+			req = httptest.NewRequest("POST", "/admin/reservations-calendar", nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		now := time.Now()
+		bm := make(map[string]int)
+		rm := make(map[string]int)
+
+		currentYear, currentMonth, _ := now.Date()
+		currentLocation := now.Location()
+
+		firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
+		lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
+			rm[d.Format("2006-01-2")] = 0
+			bm[d.Format("2006-01-2")] = 0
+		}
+
+		if e.blocks > 0 {
+			bm[firstOfMonth.Format("2006-01-2")] = e.blocks
+		}
+
+		if e.reservations > 0 {
+			rm[lastOfMonth.Format("2006-01-2")] = e.reservations
+		}
+
+		sessionManager.Put(ctx, "block_map_1", bm)
+		sessionManager.Put(ctx, "reservation_map_1", rm)
+
+		// set the header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		// call the handler
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+	}
+}
+
+var adminUpdateReservationStatusTests = []struct {
+	name                 string
+	url                  string
+	expectedResponseCode int
+	expectedLocation     string
+	expectedFlash        string
+}{
+	{
+		name:                 "confirm",
+		url:                  "/admin/update-reservation-status/new/1/confirmed/action",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-new",
+		expectedFlash:        "success",
+	},
+	{
+		name:                 "confirm-back-to-cal",
+		url:                  "/admin/update-reservation-status/cal/1/confirmed/action?y=2021&m=12",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-calendar?y=2021&m=12",
+		expectedFlash:        "success",
+	},
+	{
+		name:                 "not-allowed",
+		url:                  "/admin/update-reservation-status/all/1/checked_out/action",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-all",
+		expectedFlash:        "error",
+	},
+	{
+		name:                 "final-status",
+		url:                  "/admin/update-reservation-status/all/3/cancelled/action",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-all",
+		expectedFlash:        "error",
+	},
+	{
+		name:                 "changed-in-the-meantime",
+		url:                  "/admin/update-reservation-status/all/2/cancelled/action",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-all",
+		expectedFlash:        "error",
+	},
+}
+
+func TestRepository_AdminUpdateReservationStatus(t *testing.T) {
+	for _, e := range adminUpdateReservationStatusTests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminUpdateReservationStatus)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+
+		if !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+	}
+}
+
+var adminDeleteReservationTests = []struct {
+	name                 string
+	url                  string
+	expectedResponseCode int
+	expectedHTML         string
+}{
+	{
+		name:                 "show-outcome",
+		url:                  "/admin/reservations/cal/1/cancel?y=2021&m=12",
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Refundable share",
+	},
+	{
+		name:                 "already-checked-out",
+		url:                  "/admin/reservations/all/3/cancel",
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name:                 "reservation-not-found",
+		url:                  "/admin/reservations/all/x/cancel",
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+	{
+		name:                 "payments-error",
+		url:                  "/admin/reservations/all/6/cancel",
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminDeleteReservation(t *testing.T) {
+	for _, e := range adminDeleteReservationTests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminPostDeleteReservationTests = []struct {
+	name                 string
+	url                  string
+	expectedResponseCode int
+	expectedLocation     string
+	expectedFlash        string
+}{
+	{
+		name:                 "cancel",
+		url:                  "/admin/reservations/all/1/cancel",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations/all/1/show",
+		expectedFlash:        "success",
+	},
+	{
+		name:                 "cancel-back-to-cal",
+		url:                  "/admin/reservations/cal/1/cancel?y=2021&m=12",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations/cal/1/show?y=2021&m=12",
+		expectedFlash:        "success",
+	},
+	{
+		name:                 "changed-in-the-meantime",
+		url:                  "/admin/reservations/all/2/cancel",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations/all/2/show",
+		expectedFlash:        "error",
+	},
+	{
+		name:                 "already-checked-out",
+		url:                  "/admin/reservations/all/3/cancel",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations/all/3/show",
+		expectedFlash:        "error",
+	},
+	{
+		name:                 "database-error",
+		url:                  "/admin/reservations/all/4/cancel",
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminPostDeleteReservation(t *testing.T) {
+	for _, e := range adminPostDeleteReservationTests {
+		req := httptest.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostDeleteReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		if e.expectedFlash != "" && !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+	}
+}
+
+func TestRepository_Room(t *testing.T) {
+	/*****************************************
+	// 1st case -- room exists
+	*****************************************/
+	req := httptest.NewRequest("GET", "/rooms/generals-quarters", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/rooms/generals-quarters"
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Room)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Room handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	/*****************************************
+	// 2nd case -- room does not exist
+	*****************************************/
+	req = httptest.NewRequest("GET", "/rooms/non-existent", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/rooms/non-existent"
+
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Room handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+}
+
+var adminPostShowRoomTests = []struct {
+	name                 string
+	url                  string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name: "new-room",
+		url:  "/admin/rooms/0",
+		postedData: url.Values{
+			"room_name":    {"Colonel's Cabin"},
+			"active":       {"1"},
+			"max_adults":   {"2"},
+			"max_children": {"0"},
+			"base_price":   {"99.50"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms",
+	},
+	{
+		name: "update-room",
+		url:  "/admin/rooms/1",
+		postedData: url.Values{
+			"room_name":    {"General's Quarters"},
+			"slug":         {"generals-quarters"},
+			"max_adults":   {"2"},
+			"max_children": {"1"},
+			"base_price":   {"120"},
+			"amenities":    {"Wifi\nOcean view"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms",
+	},
+	{
+		name: "missing-name",
+		url:  "/admin/rooms/1",
+		postedData: url.Values{
+			"slug":         {"generals-quarters"},
+			"max_adults":   {"2"},
+			"max_children": {"1"},
+			"base_price":   {"120"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         `action="/admin/rooms/1"`,
+	},
+	{
+		name: "invalid-slug",
+		url:  "/admin/rooms/1",
+		postedData: url.Values{
+			"room_name":    {"General's Quarters"},
+			"slug":         {"General's Quarters"},
+			"max_adults":   {"2"},
+			"max_children": {"1"},
+			"base_price":   {"120"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Only lowercase letters",
+	},
+	{
+		name: "duplicate-slug",
+		url:  "/admin/rooms/0",
+		postedData: url.Values{
+			"room_name":    {"General's Quarters"},
+			"slug":         {"taken"},
+			"max_adults":   {"2"},
+			"max_children": {"1"},
+			"base_price":   {"120"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "already used by another room",
+	},
+	{
+		name: "invalid-price",
+		url:  "/admin/rooms/1",
+		postedData: url.Values{
+			"room_name":    {"General's Quarters"},
+			"slug":         {"generals-quarters"},
+			"max_adults":   {"0"},
+			"max_children": {"1"},
+			"base_price":   {"cheap"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Invalid amount",
+	},
+}
+
+func TestRepository_AdminPostShowRoom(t *testing.T) {
+	for _, e := range adminPostShowRoomTests {
+		req := httptest.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostShowRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoom(t *testing.T) {
+	tests := []struct {
+		name                 string
+		url                  string
+		expectedResponseCode int
+	}{
+		{"deleted", "/admin/delete-room/1/action", http.StatusSeeOther},
+		{"delete-fails", "/admin/delete-room/99/action", http.StatusInternalServerError},
+		{"bad-id", "/admin/delete-room/x/action", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+	}
+}
+
+// multipartPhoto builds a multipart upload body for the room photo form
+func multipartPhoto(t *testing.T, fileName string, content []byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	_ = writer.WriteField("caption", "View from the window")
+	if content != nil {
+		part, err := writer.CreateFormFile("photo", fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = part.Write(content)
+	}
+	_ = writer.Close()
+	return body, writer.FormDataContentType()
+}
+
+func TestRepository_AdminPostRoomPhotos(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	var png bytes.Buffer
+	if err := pngenc.Encode(&png, img); err != nil {
+		t.Fatal(err)
+	}
+
+	// a tiny gif declaring a screen of 65535 x 65535 pixels
+	var gif bytes.Buffer
+	if err := gifenc.Encode(&gif, image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black}), nil); err != nil {
+		t.Fatal(err)
+	}
+	huge := gif.Bytes()
+	copy(huge[6:10], []byte{0xff, 0xff, 0xff, 0xff})
+
+	tests := []struct {
+		name            string
+		fileName        string
+		content         []byte
+		expectedFlash   string
+		expectedMessage string
+	}{
+		{name: "valid-png", fileName: "room.png", content: png.Bytes(), expectedFlash: "success"},
+		{name: "not-an-image", fileName: "room.txt", content: []byte("hello, world"), expectedFlash: "error"},
+		{name: "too-large", fileName: "room.png", content: append(png.Bytes(), make([]byte, maxPhotoSize)...), expectedFlash: "error"},
+		{name: "upload-too-large", fileName: "room.png", content: append(png.Bytes(), make([]byte, maxPhotoUploadSize)...),
+			expectedFlash: "error", expectedMessage: "Photo must not be larger than 5 MB"},
+		{name: "too-many-pixels", fileName: "room.gif", content: huge, expectedFlash: "error",
+			expectedMessage: "Photo must not have more than 25 megapixels"},
+		{name: "missing-file", expectedFlash: "error"},
+	}
+
+	for _, e := range tests {
+		body, contentType := multipartPhoto(t, e.fileName, e.content)
+		req := httptest.NewRequest("POST", "/admin/rooms/1/photos", body)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", contentType)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomPhotos)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != "/admin/rooms/1/photos" {
+			t.Errorf("failed %s: expected location /admin/rooms/1/photos, but got location %s", e.name, actualLoc.String())
+		}
+
+		if !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+		if msg := sessionManager.GetString(ctx, e.expectedFlash); e.expectedMessage != "" && msg != e.expectedMessage {
+			t.Errorf("failed %s: expected message %q but got %q", e.name, e.expectedMessage, msg)
+		}
+	}
+}
+
+func TestRepository_AdminPostUpdateRoomPhotos(t *testing.T) {
+	postedData := url.Values{
+		"caption_1":    {"Balcony"},
+		"sort_order_1": {"2"},
+	}
+	req := httptest.NewRequest("POST", "/admin/rooms/1/photos/update", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminPostUpdateRoomPhotos)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostUpdateRoomPhotos handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+}
+
+func TestRepository_AdminDeleteRoomPhoto(t *testing.T) {
+	tests := []struct {
+		name          string
+		url           string
+		expectedFlash string
+	}{
+		{name: "valid", url: "/admin/delete-room-photo/1/1/action", expectedFlash: "success"},
+		{name: "other-room", url: "/admin/delete-room-photo/2/1/action", expectedFlash: "error"},
+		{name: "missing-photo", url: "/admin/delete-room-photo/1/3/action", expectedFlash: "error"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteRoomPhoto)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+	}
+}
+
+var adminPostShowRatePlanTests = []struct {
+	name                 string
+	url                  string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name: "new-plan",
+		url:  "/admin/rate-plans/0",
+		postedData: url.Values{
+			"name":         {"Summer"},
+			"active":       {"1"},
+			"percent_0":    {"100"},
+			"percent_1":    {"100"},
+			"percent_2":    {"100"},
+			"percent_3":    {"100"},
+			"percent_4":    {"100"},
+			"percent_5":    {"120"},
+			"percent_6":    {"120"},
+			"room_price_1": {"150"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rate-plans/1/show",
+	},
+	{
+		name: "missing-multiplier",
+		url:  "/admin/rate-plans/1",
+		postedData: url.Values{
+			"name":      {"Standard"},
+			"percent_0": {"100"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "is-invalid",
+	},
+}
+
+func TestRepository_AdminPostShowRatePlan(t *testing.T) {
+	for _, e := range adminPostShowRatePlanTests {
+		req := httptest.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostShowRatePlan)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminPostRateSeason(t *testing.T) {
+	tests := []struct {
+		name          string
+		postedData    url.Values
+		expectedFlash string
+	}{
+		{
+			name: "valid",
+			postedData: url.Values{
+				"name":          {"Summer"},
+				"room_id":       {"1"},
+				"start_date":    {"2050-07-01"},
+				"end_date":      {"2050-08-31"},
+				"nightly_price": {"150"},
+			},
+			expectedFlash: "success",
+		},
+		{
+			name: "ends-before-start",
+			postedData: url.Values{
+				"room_id":       {"1"},
+				"start_date":    {"2050-08-31"},
+				"end_date":      {"2050-07-01"},
+				"nightly_price": {"150"},
+			},
+			expectedFlash: "error",
+		},
+		{
+			name: "invalid-date",
+			postedData: url.Values{
+				"room_id":       {"1"},
+				"start_date":    {"invalid"},
+				"end_date":      {"2050-07-01"},
+				"nightly_price": {"150"},
+			},
+			expectedFlash: "error",
+		},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/admin/rate-plans/1/seasons", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRateSeason)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRateSeason(t *testing.T) {
+	tests := []struct {
+		name                 string
+		url                  string
+		expectedResponseCode int
+	}{
+		{"deleted", "/admin/delete-rate-season/1/1/action", http.StatusSeeOther},
+		{"delete-fails", "/admin/delete-rate-season/1/99/action", http.StatusInternalServerError},
+		{"bad-id", "/admin/delete-rate-season/1/x/action", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteRateSeason)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+	}
+}
+
+var adminPostStayRulesTests = []struct {
+	name                 string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedHTML         string
+}{
+	{
+		name: "minimum-stay",
+		postedData: url.Values{
+			"room_id":        {"1"},
+			"restriction_id": {"3"},
+			"start_date":     {"2050-07-01"},
+			"end_date":       {"2050-08-31"},
+			"nights":         {"3"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name: "closed-to-arrival",
+		postedData: url.Values{
+			"room_id":        {"1"},
+			"restriction_id": {"5"},
+			"start_date":     {"2050-12-24"},
+			"end_date":       {"2050-12-24"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name: "minimum-stay-without-nights",
+		postedData: url.Values{
+			"room_id":        {"1"},
+			"restriction_id": {"3"},
+			"start_date":     {"2050-07-01"},
+			"end_date":       {"2050-08-31"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "at least 1",
+	},
+	{
+		name: "not-a-stay-rule",
+		postedData: url.Values{
+			"room_id":        {"1"},
+			"restriction_id": {"2"},
+			"start_date":     {"2050-07-01"},
+			"end_date":       {"2050-08-31"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Invalid rule",
+	},
+	{
+		name: "ends-before-start",
+		postedData: url.Values{
+			"room_id":        {"1"},
+			"restriction_id": {"6"},
+			"start_date":     {"2050-08-31"},
+			"end_date":       {"2050-07-01"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "must not be before",
+	},
+	{
+		name: "insert-error",
+		postedData: url.Values{
+			"room_id":        {"2"},
+			"restriction_id": {"5"},
+			"start_date":     {"2050-12-24"},
+			"end_date":       {"2050-12-24"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+func TestRepository_AdminPostStayRules(t *testing.T) {
+	for _, e := range adminPostStayRulesTests {
+		req := httptest.NewRequest("POST", "/admin/stay-rules", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostStayRules)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminDeleteStayRule(t *testing.T) {
+	tests := []struct {
+		name                 string
+		url                  string
+		expectedResponseCode int
+	}{
+		{"deleted", "/admin/delete-stay-rule/1/action", http.StatusSeeOther},
+		{"delete-fails", "/admin/delete-stay-rule/99/action", http.StatusInternalServerError},
+		{"bad-id", "/admin/delete-stay-rule/x/action", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteStayRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+	}
+}
+
+var adminPostCancellationTierTests = []struct {
+	name                 string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedHTML         string
+}{
+	{
+		name: "valid",
+		postedData: url.Values{
+			"policy_id":    {"1"},
+			"hours_before": {"48"},
+			"fee_percent":  {"25"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name: "fee-above-100",
+		postedData: url.Values{
+			"policy_id":    {"1"},
+			"hours_before": {"48"},
+			"fee_percent":  {"120"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "The fee can&#39;t be more than 100%",
+	},
+	{
+		name: "missing-hours",
+		postedData: url.Values{
+			"policy_id":   {"1"},
+			"fee_percent": {"25"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "This field cannot be blank",
+	},
+	{
+		name: "database-error",
+		postedData: url.Values{
+			"policy_id":    {"2"},
+			"hours_before": {"48"},
+			"fee_percent":  {"25"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+func TestRepository_AdminPostCancellationTier(t *testing.T) {
+	for _, e := range adminPostCancellationTierTests {
+		req := httptest.NewRequest("POST", "/admin/cancellation-policies/tiers", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostCancellationTier)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminPostCancellationPolicies(t *testing.T) {
+	tests := []struct {
+		name         string
+		policyName   string
+		expectedCode int
+	}{
+		{"valid", "Strict", http.StatusSeeOther},
+		{"missing-name", "", http.StatusOK},
+		{"database-error", "fail", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("name", e.policyName)
+
+		req := httptest.NewRequest("POST", "/admin/cancellation-policies", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostCancellationPolicies)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteCancellationTier(t *testing.T) {
+	tests := []struct {
+		name                 string
+		url                  string
+		expectedResponseCode int
+	}{
+		{"deleted", "/admin/delete-cancellation-tier/1/action", http.StatusSeeOther},
+		{"delete-fails", "/admin/delete-cancellation-tier/99/action", http.StatusInternalServerError},
+		{"bad-id", "/admin/delete-cancellation-tier/x/action", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteCancellationTier)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+	}
+}
+
+var adminPostTaxRulesTests = []struct {
+	name                 string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedHTML         string
+}{
+	{
+		name: "percentage",
+		postedData: url.Values{
+			"name":   {"VAT"},
+			"kind":   {"percent"},
+			"amount": {"7.5"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name: "fixed-with-dates",
+		postedData: url.Values{
+			"name":       {"City tax"},
+			"kind":       {"fixed"},
+			"basis":      {"person_night"},
+			"amount":     {"2.00"},
+			"start_date": {"2050-01-01"},
+			"end_date":   {"2050-12-31"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name: "percentage-above-100",
+		postedData: url.Values{
+			"name":   {"VAT"},
+			"kind":   {"percent"},
+			"amount": {"120"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "A percentage can&#39;t be more than 100",
+	},
+	{
+		name: "invalid-basis",
+		postedData: url.Values{
+			"name":   {"Cleaning"},
+			"kind":   {"fixed"},
+			"basis":  {"room"},
+			"amount": {"20"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Invalid basis",
+	},
+	{
+		name: "invalid-amount",
+		postedData: url.Values{
+			"name":   {"Cleaning"},
+			"kind":   {"fixed"},
+			"basis":  {"stay"},
+			"amount": {"-20"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Invalid amount",
+	},
+	{
+		name: "dates-reversed",
+		postedData: url.Values{
+			"name":       {"City tax"},
+			"kind":       {"fixed"},
+			"basis":      {"person_night"},
+			"amount":     {"2.00"},
+			"start_date": {"2050-12-31"},
+			"end_date":   {"2050-01-01"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "The last night must not be before the first night",
+	},
+	{
+		name: "database-error",
+		postedData: url.Values{
+			"name":   {"fail"},
+			"kind":   {"percent"},
+			"amount": {"10"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+func TestRepository_AdminPostTaxRules(t *testing.T) {
+	for _, e := range adminPostTaxRulesTests {
+		req := httptest.NewRequest("POST", "/admin/tax-rules", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostTaxRules)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteTaxRule(t *testing.T) {
+	tests := []struct {
+		name                 string
+		url                  string
+		expectedResponseCode int
+	}{
+		{"deleted", "/admin/delete-tax-rule/1/action", http.StatusSeeOther},
+		{"delete-fails", "/admin/delete-tax-rule/99/action", http.StatusInternalServerError},
+		{"bad-id", "/admin/delete-tax-rule/x/action", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteTaxRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+	}
+}
+
+var adminPostPromoCodesTests = []struct {
+	name                 string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedHTML         string
+}{
+	{
+		name: "percentage",
+		postedData: url.Values{
+			"code":   {"summer-10"},
+			"kind":   {"percent"},
+			"amount": {"10"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name: "fixed-with-limits",
+		postedData: url.Values{
+			"code":        {"LONGSTAY"},
+			"kind":        {"fixed"},
+			"amount":      {"50.00"},
+			"valid_from":  {"2050-01-01"},
+			"valid_until": {"2050-12-31"},
+			"max_uses":    {"100"},
+			"min_nights":  {"7"},
+			"room_id":     {"1", "2"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name: "invalid-code",
+		postedData: url.Values{
+			"code":   {"10% off"},
+			"kind":   {"percent"},
+			"amount": {"10"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Invalid promo code",
+	},
+	{
+		name: "percentage-above-100",
+		postedData: url.Values{
+			"code":   {"FREE"},
+			"kind":   {"percent"},
+			"amount": {"120"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "A percentage can&#39;t be more than 100",
+	},
+	{
+		name: "zero-amount",
+		postedData: url.Values{
+			"code":   {"NOTHING"},
+			"kind":   {"fixed"},
+			"amount": {"0"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "The discount must be more than 0",
+	},
+	{
+		name: "negative-usage-limit",
+		postedData: url.Values{
+			"code":     {"SPRING"},
+			"kind":     {"percent"},
+			"amount":   {"10"},
+			"max_uses": {"-1"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "This field must be a whole number of at least 0",
+	},
+	{
+		name: "dates-reversed",
+		postedData: url.Values{
+			"code":        {"SPRING"},
+			"kind":        {"percent"},
+			"amount":      {"10"},
+			"valid_from":  {"2050-12-31"},
+			"valid_until": {"2050-01-01"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "The last day must not be before the first day",
+	},
+	{
+		name: "duplicate",
+		postedData: url.Values{
+			"code":   {"taken"},
+			"kind":   {"percent"},
+			"amount": {"10"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "This promo code already exists",
+	},
+	{
+		name: "database-error",
+		postedData: url.Values{
+			"code":   {"FAIL"},
+			"kind":   {"percent"},
+			"amount": {"10"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+func TestRepository_AdminPostPromoCodes(t *testing.T) {
+	for _, e := range adminPostPromoCodesTests {
+		req := httptest.NewRequest("POST", "/admin/promo-codes", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostPromoCodes)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminDeletePromoCode(t *testing.T) {
+	tests := []struct {
+		name                 string
+		url                  string
+		expectedResponseCode int
+	}{
+		{"deleted", "/admin/delete-promo-code/1/action", http.StatusSeeOther},
+		{"delete-fails", "/admin/delete-promo-code/99/action", http.StatusInternalServerError},
+		{"bad-id", "/admin/delete-promo-code/x/action", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeletePromoCode)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_ManageReservation(t *testing.T) {
+	valid := testApp.Signer.Sign(manageReservationPurpose, 1, time.Now().Add(time.Hour))
+	expired := testApp.Signer.Sign(manageReservationPurpose, 1, time.Now().Add(-time.Hour))
+
+	tests := []struct {
+		name         string
+		token        string
+		expectedCode int
+		expectedHTML string
+	}{
+		{"valid", valid, http.StatusOK, "Cancel Reservation"},
+		{"valid-change-form", valid, http.StatusOK, "Change Reservation"},
+		{"expired", expired, http.StatusSeeOther, ""},
+		{"tampered", "2" + valid[1:], http.StatusSeeOther, ""},
+		{"other-purpose", testApp.Signer.Sign("other", 1, time.Now().Add(time.Hour)), http.StatusSeeOther, ""},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/reservations/manage/"+e.token, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ManageReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_PostCancelReservation(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		expectedFlash string
+	}{
+		{"cancel", testApp.Signer.Sign(manageReservationPurpose, 1, time.Now().Add(time.Hour)), "success"},
+		{"already-checked-out", testApp.Signer.Sign(manageReservationPurpose, 3, time.Now().Add(time.Hour)), "error"},
+		{"changed-in-the-meantime", testApp.Signer.Sign(manageReservationPurpose, 2, time.Now().Add(time.Hour)), "error"},
+		{"expired", testApp.Signer.Sign(manageReservationPurpose, 1, time.Now().Add(-time.Hour)), "error"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/reservations/manage/"+e.token+"/cancel", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostCancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+	}
+}
+
+var adminReservationInvoiceTests = []struct {
+	name                 string
+	url                  string
+	expectedResponseCode int
+	expectedInvoice      string
+	expectedFlash        string
+}{
+	{"reissued", "/admin/reservations/3/invoice.pdf", http.StatusOK, "INV-000042", ""},
+	{"issued", "/admin/reservations/7/invoice.pdf", http.StatusOK, "INV-000043", ""},
+	{"pending", "/admin/reservations/1/invoice.pdf", http.StatusSeeOther, "", "error"},
+	{"insert-fails", "/admin/reservations/9/invoice.pdf", http.StatusInternalServerError, "", ""},
+	{"bad-id", "/admin/reservations/x/invoice.pdf", http.StatusInternalServerError, "", ""},
+}
+
+func TestRepository_AdminReservationInvoice(t *testing.T) {
+	for _, e := range adminReservationInvoiceTests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedInvoice != "" {
+			if rr.Header().Get("Content-Type") != "application/pdf" {
+				t.Errorf("failed %s: expected a pdf but got %s", e.name, rr.Header().Get("Content-Type"))
+			}
+			if !strings.Contains(rr.Body.String(), "("+e.expectedInvoice+")") {
+				t.Errorf("failed %s: expected invoice %s", e.name, e.expectedInvoice)
+			}
+		}
+
+		if e.expectedFlash != "" && !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+	}
+}
+
+func TestRepository_ReservationInvoice(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		expectedCode  int
+		expectedFlash string
+	}{
+		{"invoice", testApp.Signer.Sign(manageReservationPurpose, 7, time.Now().Add(time.Hour)), http.StatusOK, ""},
+		{"pending", testApp.Signer.Sign(manageReservationPurpose, 1, time.Now().Add(time.Hour)), http.StatusSeeOther, "error"},
+		{"insert-fails", testApp.Signer.Sign(manageReservationPurpose, 9, time.Now().Add(time.Hour)), http.StatusSeeOther, "error"},
+		{"expired", testApp.Signer.Sign(manageReservationPurpose, 7, time.Now().Add(-time.Hour)), http.StatusSeeOther, "error"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/reservations/manage/"+e.token+"/invoice.pdf", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ReservationInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedFlash != "" && !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+	}
+}
+
+func TestRepository_PostChangeReservation(t *testing.T) {
+	valid := testApp.Signer.Sign(manageReservationPurpose, 1, time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name          string
+		token         string
+		postedData    url.Values
+		expectedCode  int
+		expectedFlash string
+		expectedHTML  string
+	}{
+		{
+			name:  "change",
+			token: valid,
+			postedData: url.Values{
+				"start_date": {"2049-12-01"},
+				"end_date":   {"2049-12-03"},
+				"room_id":    {"1"},
+			},
+			expectedCode:  http.StatusSeeOther,
+			expectedFlash: "success",
+		},
+		{
+			name:  "invalid-dates",
+			token: valid,
+			postedData: url.Values{
+				"start_date": {"2050-01-03"},
+				"end_date":   {"2050-01-01"},
+				"room_id":    {"1"},
+			},
+			expectedCode: http.StatusOK,
+			expectedHTML: "Departure must be after arrival",
+		},
+		{
+			name:  "past-arrival",
+			token: valid,
+			postedData: url.Values{
+				"start_date": {"2000-01-01"},
+				"end_date":   {"2000-01-03"},
+				"room_id":    {"1"},
+			},
+			expectedCode: http.StatusOK,
+			expectedHTML: "Arrival can&#39;t be in the past",
+		},
+		{
+			name:  "missing-room",
+			token: valid,
+			postedData: url.Values{
+				"start_date": {"2049-12-01"},
+				"end_date":   {"2049-12-03"},
+			},
+			expectedCode: http.StatusOK,
+			expectedHTML: "This field cannot be blank",
+		},
+		{
+			name:  "unknown-room",
+			token: valid,
+			postedData: url.Values{
+				"start_date": {"2049-12-01"},
+				"end_date":   {"2049-12-03"},
+				"room_id":    {"3"},
+			},
+			expectedCode:  http.StatusSeeOther,
+			expectedFlash: "error",
+		},
+		{
+			name:  "retired-room",
+			token: valid,
+			postedData: url.Values{
+				"start_date": {"2049-12-01"},
+				"end_date":   {"2049-12-03"},
+				"room_id":    {"5"},
+			},
+			expectedCode: http.StatusOK,
+			expectedHTML: "This room is no longer available",
+		},
+		{
+			name:  "not-available",
+			token: valid,
+			postedData: url.Values{
+				"start_date": {"2050-01-02"},
+				"end_date":   {"2050-01-03"},
+				"room_id":    {"1"},
+			},
+			expectedCode:  http.StatusSeeOther,
+			expectedFlash: "error",
+		},
+		{
+			name:  "closed-to-arrival",
+			token: valid,
+			postedData: url.Values{
+				"start_date": {"2049-12-24"},
+				"end_date":   {"2049-12-26"},
+				"room_id":    {"1"},
+			},
+			expectedCode:  http.StatusSeeOther,
+			expectedFlash: "error",
+		},
+		{
+			name:  "booked-in-the-meantime",
+			token: valid,
+			postedData: url.Values{
+				"start_date": {"2049-12-30"},
+				"end_date":   {"2049-12-31"},
+				"room_id":    {"1"},
+			},
+			expectedCode:  http.StatusSeeOther,
+			expectedFlash: "error",
+		},
+		{
+			name:  "update-fails",
+			token: valid,
+			postedData: url.Values{
+				"start_date": {"2049-12-01"},
+				"end_date":   {"2049-12-03"},
+				"room_id":    {"2"},
+			},
+			expectedCode:  http.StatusSeeOther,
+			expectedFlash: "error",
+		},
+		{
+			name:  "with-promo-code",
+			token: testApp.Signer.Sign(manageReservationPurpose, 12, time.Now().Add(time.Hour)),
+			postedData: url.Values{
+				"start_date": {"2049-12-01"},
+				"end_date":   {"2049-12-03"},
+				"room_id":    {"1"},
+			},
+			expectedCode:  http.StatusSeeOther,
+			expectedFlash: "success",
+		},
+		{
+			name:  "promo-code-not-valid-for-room",
+			token: testApp.Signer.Sign(manageReservationPurpose, 11, time.Now().Add(time.Hour)),
+			postedData: url.Values{
+				"start_date": {"2049-12-01"},
+				"end_date":   {"2049-12-03"},
+				"room_id":    {"1"},
+			},
+			expectedCode:  http.StatusSeeOther,
+			expectedFlash: "error",
+		},
+		{
+			name:  "already-checked-out",
+			token: testApp.Signer.Sign(manageReservationPurpose, 3, time.Now().Add(time.Hour)),
+			postedData: url.Values{
+				"start_date": {"2049-12-01"},
+				"end_date":   {"2049-12-03"},
+				"room_id":    {"1"},
+			},
+			expectedCode:  http.StatusSeeOther,
+			expectedFlash: "error",
+		},
+		{
+			name:          "expired",
+			token:         testApp.Signer.Sign(manageReservationPurpose, 1, time.Now().Add(-time.Hour)),
+			postedData:    url.Values{},
+			expectedCode:  http.StatusSeeOther,
+			expectedFlash: "error",
+		},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/reservations/manage/"+e.token+"/change", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostChangeReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+
+		if e.expectedFlash != "" && !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminLookupReservation(t *testing.T) {
+	tests := []struct {
+		name             string
+		code             string
+		expectedLocation string
+		expectedFlash    string
+	}{
+		{"found", "7K3M9QXA", "/admin/reservations/all/1/show", ""},
+		{"found-as-typed", "7k3m-9qxa", "/admin/reservations/all/1/show", ""},
+		{"not-found", "00000000", "/admin/reservations-all", "error"},
+		{"empty", "", "/admin/reservations-all", "error"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/admin/reservations/lookup?code="+url.QueryEscape(e.code), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminLookupReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, location)
+		}
+
+		if e.expectedFlash != "" && !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+	}
+}
 
-		// create request
-		req := httptest.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+func TestRepository_AdminPostCapturePayment(t *testing.T) {
+	tests := []struct {
+		name             string
+		url              string
+		expectedCode     int
+		expectedFlash    string
+		expectedLocation string
+	}{
+		{"capture", "/admin/reservations/all/1/capture", http.StatusSeeOther, "success", "/admin/reservations/all/1/show"},
+		{"already-captured", "/admin/reservations/all/2/capture", http.StatusSeeOther, "error", "/admin/reservations/all/2/show"},
+		{"nothing-to-capture", "/admin/reservations/all/3/capture?y=2050&m=01", http.StatusSeeOther, "error", "/admin/reservations/all/3/show?y=2050&m=01"},
+		{"payments-error", "/admin/reservations/all/6/capture", http.StatusInternalServerError, "", ""},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", e.url, nil)
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
 
-		// set the header
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
 
-		// call the handler
-		handler := http.HandlerFunc(Repo.PostLogin)
+		handler := http.HandlerFunc(Repo.AdminPostCapturePayment)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != e.expectedStatusCode {
-			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
 		}
 
-		if e.expectedLocation != "" {
-			// get the URL from test
-			actualLoc, _ := rr.Result().Location()
-			if actualLoc.String() != e.expectedLocation {
-				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
-			}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, location)
 		}
 
-		// checking for expected values in HTML
-		if e.expectedHTML != "" {
-			// read the response body into a string
-			html := rr.Body.String()
-			if !strings.Contains(html, e.expectedHTML) {
-				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
-			}
+		if e.expectedFlash != "" && !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
 		}
 	}
 }
 
-var adminPostShowReservationTests = []struct {
-	name                 string
-	url                  string
-	postedData           url.Values
-	expectedResponseCode int
-	expectedLocation     string
-	expectedHTML         string
-}{
-	{
-		name: "valid-data-from-new",
-		url:  "/admin/reservations/new/1/show",
-		postedData: url.Values{
-			"first_name": {"John"},
-			"last_name":  {"Smith"},
-			"email":      {"john@smith.com"},
-			"phone":      {"555-555-5555"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "/admin/reservations-new",
-		expectedHTML:         "",
-	},
-	{
-		name: "valid-data-from-all",
-		url:  "/admin/reservations/all/1/show",
-		postedData: url.Values{
-			"first_name": {"John"},
-			"last_name":  {"Smith"},
-			"email":      {"john@smith.com"},
-			"phone":      {"555-555-5555"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "/admin/reservations-all",
-		expectedHTML:         "",
-	},
-	{
-		name: "valid-data-from-cal",
-		url:  "/admin/reservations/cal/1/show",
-		postedData: url.Values{
-			"first_name": {"John"},
-			"last_name":  {"Smith"},
-			"email":      {"john@smith.com"},
-			"phone":      {"555-555-5555"},
-			"year":       {"2022"},
-			"month":      {"01"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "/admin/reservations-calendar?y=2022&m=01",
-		expectedHTML:         "",
-	},
-}
+func TestRepository_AdminPostRefundPayment(t *testing.T) {
+	tests := []struct {
+		name             string
+		url              string
+		amount           string
+		expectedFlash    string
+		expectedLocation string
+	}{
+		{"refund", "/admin/reservations/all/2/refund", "10.00", "success", "/admin/reservations/all/2/show"},
+		{"more-than-captured", "/admin/reservations/all/2/refund", "50.00", "error", "/admin/reservations/all/2/show"},
+		{"invalid-amount", "/admin/reservations/all/2/refund", "ten", "error", "/admin/reservations/all/2/show"},
+		{"nothing-captured", "/admin/reservations/all/1/refund?y=2050&m=01", "10.00", "error", "/admin/reservations/all/1/show?y=2050&m=01"},
+		{"gateway-refuses", "/admin/reservations/all/5/refund", "10.00", "error", "/admin/reservations/all/5/show"},
+	}
 
-func TestRepository_AdminPostShowReservation(t *testing.T) {
-	for _, e := range adminPostShowReservationTests {
-		var req *http.Request
-		if e.postedData != nil {
-			req = httptest.NewRequest("POST", "/user/login", strings.NewReader(e.postedData.Encode()))
-		} else {
-			req = httptest.NewRequest("POST", "/user/login", nil)
-		}
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("amount", e.amount)
+
+		req := httptest.NewRequest("POST", e.url, strings.NewReader(postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
-		req.RequestURI = e.url
-
-		// set the header
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
 		rr := httptest.NewRecorder()
 
-		// call the handler
-		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
+		handler := http.HandlerFunc(Repo.AdminPostRefundPayment)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != e.expectedResponseCode {
-			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
 		}
 
-		if e.expectedLocation != "" {
-			// get the URL from test
-			actualLoc, _ := rr.Result().Location()
-			if actualLoc.String() != e.expectedLocation {
-				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
-			}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, location)
 		}
 
-		// checking for expected values in HTML
-		if e.expectedHTML != "" {
-			// read the response body into a string
-			html := rr.Body.String()
-			if !strings.Contains(html, e.expectedHTML) {
-				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
-			}
+		if !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
 		}
 	}
 }
 
-var adminPostReservationCalendarTests = []struct {
-	name                 string
-	postedData           url.Values
-	expectedResponseCode int
-	expectedLocation     string
-	expectedHTML         string
-	blocks               int
-	reservations         int
-}{
-	{
-		name: "cal",
-		postedData: url.Values{
-			"year":  {time.Now().Format("2006")},
-			"month": {time.Now().Format("01")},
-			fmt.Sprintf("add_block_1_%s", time.Now().AddDate(0, 0, 2).Format("2006-01-2")): {"1"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-	},
-	{
-		name:                 "cal-blocks",
-		postedData:           url.Values{},
-		expectedResponseCode: http.StatusSeeOther,
-		blocks:               1,
-	},
-	{
-		name:                 "cal-res",
-		postedData:           url.Values{},
-		expectedResponseCode: http.StatusSeeOther,
-		reservations:         1,
-	},
+func TestRepository_Availability_Waitlist(t *testing.T) {
+	req := httptest.NewRequest("GET", "/search-availability", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	sessionManager.Put(ctx, "waitlist", models.Reservation{
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+	})
+
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Availability)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Join the Waitlist") {
+		t.Error("expected the waitlist form")
+	}
 }
 
-func TestRepository_AdminReservationsCalendar(t *testing.T) {
-	for _, e := range adminPostReservationCalendarTests {
-		var req *http.Request
-		if e.postedData != nil {
-			req = httptest.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
-		} else {
-			req = httptest.NewRequest("POST", "/admin/reservations-calendar", nil)
-		}
+func TestRepository_PostWaitlist(t *testing.T) {
+	search := models.Reservation{
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+	}
+
+	tests := []struct {
+		name                 string
+		search               bool
+		email                string
+		expectedResponseCode int
+		expectedFlash        string
+		expectedWaitlist     bool
+	}{
+		{"joined", true, "john@smith.com", http.StatusSeeOther, "success", false},
+		{"no-search", false, "john@smith.com", http.StatusSeeOther, "error", false},
+		{"invalid-email", true, "john", http.StatusOK, "", true},
+		{"insert-fails", true, "fail@here.com", http.StatusSeeOther, "error", true},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", e.email)
+
+		req := httptest.NewRequest("POST", "/waitlist", strings.NewReader(postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if e.search {
+			sessionManager.Put(ctx, "waitlist", search)
+		}
 
-		now := time.Now()
-		bm := make(map[string]int)
-		rm := make(map[string]int)
-
-		currentYear, currentMonth, _ := now.Date()
-		currentLocation := now.Location()
+		rr := httptest.NewRecorder()
 
-		firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-		lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+		handler := http.HandlerFunc(Repo.PostWaitlist)
+		handler.ServeHTTP(rr, req)
 
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			rm[d.Format("2006-01-2")] = 0
-			bm[d.Format("2006-01-2")] = 0
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
-
-		if e.blocks > 0 {
-			bm[firstOfMonth.Format("2006-01-2")] = e.blocks
+		if e.expectedFlash != "" && !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
 		}
-
-		if e.reservations > 0 {
-			rm[lastOfMonth.Format("2006-01-2")] = e.reservations
+		if sessionManager.Exists(ctx, "waitlist") != e.expectedWaitlist {
+			t.Errorf("failed %s: expected the search kept for the waitlist to be %t", e.name, e.expectedWaitlist)
 		}
+	}
+}
 
-		sessionManager.Put(ctx, "block_map_1", bm)
-		sessionManager.Put(ctx, "reservation_map_1", rm)
+func TestRepository_ClaimWaitlist(t *testing.T) {
+	tests := []struct {
+		name             string
+		token            string
+		expectedLocation string
+		expectedFlash    string
+	}{
+		{"claimed", testApp.Signer.Sign(waitlistClaimPurpose, 4, time.Now().Add(time.Hour)), "/make-reservation", ""},
+		{"link-expired", testApp.Signer.Sign(waitlistClaimPurpose, 4, time.Now().Add(-time.Hour)), "/search-availability", "error"},
+		{"other-purpose", testApp.Signer.Sign(manageReservationPurpose, 4, time.Now().Add(time.Hour)), "/search-availability", "error"},
+		{"offer-expired", testApp.Signer.Sign(waitlistClaimPurpose, 5, time.Now().Add(time.Hour)), "/search-availability", "error"},
+		{"not-offered", testApp.Signer.Sign(waitlistClaimPurpose, 1, time.Now().Add(time.Hour)), "/search-availability", "error"},
+		{"not-found", testApp.Signer.Sign(waitlistClaimPurpose, 42, time.Now().Add(time.Hour)), "/search-availability", "error"},
+		{"query-fails", testApp.Signer.Sign(waitlistClaimPurpose, 99, time.Now().Add(time.Hour)), "/", "error"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/waitlist/claim/"+e.token, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
 
-		// set the header
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
 
-		// call the handler
-		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		handler := http.HandlerFunc(Repo.ClaimWaitlist)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != e.expectedResponseCode {
-			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, location)
+		}
+		if e.expectedFlash != "" && !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
 		}
 
+		if e.expectedFlash == "" {
+			// the reservation takes over the hold of the offer
+			res, ok := sessionManager.Get(ctx, "reservation").(models.Reservation)
+			if !ok || res.RoomID != 1 || res.HoldToken != "waitlist-hold" || res.Email != "ole@offered.com" || res.Children != 1 {
+				t.Errorf("failed %s: expected the offered room in the reservation but got %+v", e.name, res)
+			}
+		}
 	}
 }
 
-var adminProcessReservationTests = []struct {
-	name                 string
-	queryParams          string
-	expectedResponseCode int
-	expectedLocation     string
-}{
-	{
-		name:                 "process-reservation",
-		queryParams:          "",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
-	{
-		name:                 "process-reservation-back-to-cal",
-		queryParams:          "?y=2021&m=12",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
+func TestRepository_NotifyWaitlist(t *testing.T) {
+	start := time.Date(2049, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2050, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		roomID           int
+		expectedNotified int
+	}{
+		// only the first guest is notified: the second can't arrive on their date, the room is taken for the
+		// third, and the fourth was offered a room in the meantime
+		{"fifo", 1, 1},
+		{"nobody-waiting", 2, 0},
+		{"query-fails", 1000, 0},
+	}
+
+	for _, e := range tests {
+		if notified := Repo.notifyWaitlist(e.roomID, start, end); notified != e.expectedNotified {
+			t.Errorf("failed %s: expected %d guests notified but got %d", e.name, e.expectedNotified, notified)
+		}
+	}
+}
+
+func TestRepository_ReofferExpiredWaitlistOffers(t *testing.T) {
+	notified, err := Repo.ReofferExpiredWaitlistOffers(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the room of the expired offer goes to the guests waiting for room 1
+	if notified != 1 {
+		t.Errorf("expected 1 guest notified but got %d", notified)
+	}
+
+	if _, err := Repo.ReofferExpiredWaitlistOffers(time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("expected the error of the database")
+	}
 }
 
-func TestRepository_AdminProcessReservation(t *testing.T) {
-	for _, e := range adminProcessReservationTests {
-		req := httptest.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/action%s", e.queryParams), nil)
+func TestRepository_AdminShowGuest(t *testing.T) {
+	tests := []struct {
+		name                 string
+		url                  string
+		expectedResponseCode int
+		expectedHTML         string
+	}{
+		{"history", "/admin/guests/1/show", http.StatusOK, "Lifetime Nights</strong>: 5"},
+		{"duplicates", "/admin/guests/1/show", http.StatusOK, "Possible Duplicates"},
+		{"no-history", "/admin/guests/2/show", http.StatusOK, "No reservations yet"},
+		{"not-found", "/admin/guests/42/show", http.StatusSeeOther, ""},
+		{"query-fails", "/admin/guests/99/show", http.StatusInternalServerError, ""},
+		{"history-fails", "/admin/guests/3/show", http.StatusInternalServerError, ""},
+		{"bad-id", "/admin/guests/x/show", http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
 
 		rr := httptest.NewRecorder()
 
-		handler := http.HandlerFunc(Repo.AdminProcessReservation)
+		handler := http.HandlerFunc(Repo.AdminShowGuest)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
+		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
 	}
 }
 
-var adminDeleteReservationTests = []struct {
-	name                 string
-	queryParams          string
-	expectedResponseCode int
-	expectedLocation     string
-}{
-	{
-		name:                 "delete-reservation",
-		queryParams:          "",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
-	{
-		name:                 "delete-reservation-back-to-cal",
-		queryParams:          "?y=2021&m=12",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
-}
+func TestRepository_AdminPostMergeGuest(t *testing.T) {
+	tests := []struct {
+		name                 string
+		url                  string
+		mergeID              string
+		expectedResponseCode int
+		expectedFlash        string
+	}{
+		{"merged", "/admin/guests/1/merge", "2", http.StatusSeeOther, "success"},
+		{"itself", "/admin/guests/1/merge", "1", http.StatusSeeOther, "error"},
+		{"missing-id", "/admin/guests/1/merge", "", http.StatusSeeOther, "error"},
+		{"not-found", "/admin/guests/1/merge", "42", http.StatusSeeOther, "error"},
+		{"merge-fails", "/admin/guests/1/merge", "99", http.StatusInternalServerError, ""},
+		{"bad-id", "/admin/guests/x/merge", "2", http.StatusInternalServerError, ""},
+	}
 
-func TestAdminDeleteReservation(t *testing.T) {
-	for _, e := range adminDeleteReservationTests {
-		req := httptest.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/action%s", e.queryParams), nil)
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("merge_id", e.mergeID)
+
+		req := httptest.NewRequest("POST", e.url, strings.NewReader(postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 		rr := httptest.NewRecorder()
 
-		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+		handler := http.HandlerFunc(Repo.AdminPostMergeGuest)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
+		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
+		if e.expectedFlash != "" && !sessionManager.Exists(ctx, e.expectedFlash) {
+			t.Errorf("failed %s: expected %s message in session", e.name, e.expectedFlash)
+		}
+		if rr.Code == http.StatusSeeOther && rr.Header().Get("Location") != "/admin/guests/1/show" {
+			t.Errorf("failed %s: expected to be sent back to the guest but got %s", e.name, rr.Header().Get("Location"))
+		}
 	}
 }
 
@@ -9,11 +9,15 @@ import (
 	"github.com/loidinhm31/go-bookings-system/internal/config"
 	"github.com/loidinhm31/go-bookings-system/internal/helpers"
 	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"github.com/loidinhm31/go-bookings-system/internal/payments"
 	"github.com/loidinhm31/go-bookings-system/internal/render"
+	"github.com/loidinhm31/go-bookings-system/internal/storage"
+	"github.com/loidinhm31/go-bookings-system/internal/tokens"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -26,6 +30,9 @@ var functions = template.FuncMap{
 	"formatDate": render.FormatDate,
 	"iterate":    render.Iterate,
 	"add":        render.Add,
+	"money":      render.FormatMoney,
+	"join":       strings.Join,
+	"fileURL":    render.FileURL,
 }
 
 func TestMain(m *testing.M) {
@@ -39,6 +46,7 @@ func TestMain(m *testing.M) {
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
+	gob.Register([]models.Reservation{})
 
 	// production value
 	testApp.InProduction = false
@@ -66,6 +74,23 @@ func TestMain(m *testing.M) {
 	testApp.TemplateCache = map[string]*template.Template{}
 	testApp.UseCache = true // not need to rebuild template, use template cache for testing
 
+	uploadsDir, err := os.MkdirTemp("", "uploads")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(uploadsDir)
+	testApp.Storage = storage.NewLocalStorage(uploadsDir, "/uploads")
+
+	testApp.BaseURL = "http://localhost:8080"
+	testApp.Signer = tokens.NewSigner([]byte("test-signing-key"))
+
+	// the test repository's payments for reservations 1 and 2 refer to these fake_1 and fake_2 payments
+	gateway := payments.NewFakeGateway()
+	_, _ = gateway.Authorize(3000, "4242424242424242")
+	ref, _ := gateway.Authorize(3000, "4242424242424242")
+	_ = gateway.Capture(ref, 3000)
+	testApp.Payments = gateway
+
 	repo := NewTestRepo(&testApp)
 	NewHandlers(repo)
 
@@ -94,22 +119,43 @@ func getRoutes() http.Handler {
 
 	mux.Get("/about", Repo.About)
 
-	mux.Get("/generals-quarters", Repo.Generals)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+
+	mux.Get("/reservations/manage/{token}", Repo.ManageReservation)
+	mux.Post("/reservations/manage/{token}/cancel", Repo.PostCancelReservation)
+	mux.Post("/reservations/manage/{token}/change", Repo.PostChangeReservation)
+	mux.Get("/reservations/manage/{token}/invoice.pdf", Repo.ReservationInvoice)
 
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
+	mux.Get("/api/v1/rooms/{id}/availability", Repo.RoomAvailabilityJSON)
+	mux.Post("/waitlist", Repo.PostWaitlist)
+	mux.Get("/waitlist/claim/{token}", Repo.ClaimWaitlist)
 
 	mux.Get("/contact", Repo.Contact)
 
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
+	mux.Get("/make-reservation/add-room", Repo.AddRoom)
+	mux.Get("/make-reservation/remove-room/{index}", Repo.RemoveRoom)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostLogin)
 	mux.Get("/user/logout", Repo.Logout)
+	mux.Get("/user/register", Repo.ShowRegister)
+	mux.Post("/user/register", Repo.PostRegister)
+	mux.Get("/user/verify/{token}", Repo.VerifyEmail)
+	mux.Get("/user/forgot-password", Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/reset-password/{token}", Repo.ShowResetPassword)
+	mux.Post("/user/reset-password/{token}", Repo.PostResetPassword)
+
+	mux.Get("/account/bookings", Repo.AccountBookings)
 
 	mux.Get("/admin/dashboard", Repo.AdminDashboard)
 
@@ -117,12 +163,56 @@ func getRoutes() http.Handler {
 	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
 	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
 	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
-	mux.Get("/admin/process-reservation/{src}/{id}/action", Repo.AdminProcessReservation)
-	mux.Get("/admin/delete-reservation/{src}/{id}/action", Repo.AdminDeleteReservation)
-
+	mux.Get("/admin/update-reservation-status/{src}/{id}/{status}/action", Repo.AdminUpdateReservationStatus)
+	mux.Get("/admin/reservations/{src}/{id}/cancel", Repo.AdminDeleteReservation)
+	mux.Post("/admin/reservations/{src}/{id}/cancel", Repo.AdminPostDeleteReservation)
+	mux.Post("/admin/reservations/{src}/{id}/capture", Repo.AdminPostCapturePayment)
+	mux.Post("/admin/reservations/{src}/{id}/refund", Repo.AdminPostRefundPayment)
+	mux.Get("/admin/reservations/{id}/invoice.pdf", Repo.AdminReservationInvoice)
+
+	mux.Get("/admin/reservations/lookup", Repo.AdminLookupReservation)
 	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
 
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/{id}/show", Repo.AdminShowRoom)
+	mux.Post("/admin/rooms/{id}", Repo.AdminPostShowRoom)
+	mux.Get("/admin/delete-room/{id}/action", Repo.AdminDeleteRoom)
+
+	mux.Get("/admin/rooms/{id}/photos", Repo.AdminRoomPhotos)
+	mux.Post("/admin/rooms/{id}/photos", Repo.AdminPostRoomPhotos)
+	mux.Post("/admin/rooms/{id}/photos/update", Repo.AdminPostUpdateRoomPhotos)
+	mux.Get("/admin/delete-room-photo/{id}/{photoID}/action", Repo.AdminDeleteRoomPhoto)
+
+	mux.Get("/admin/rate-plans", Repo.AdminRatePlans)
+	mux.Get("/admin/rate-plans/{id}/show", Repo.AdminShowRatePlan)
+	mux.Post("/admin/rate-plans/{id}", Repo.AdminPostShowRatePlan)
+	mux.Post("/admin/rate-plans/{id}/seasons", Repo.AdminPostRateSeason)
+	mux.Get("/admin/delete-rate-season/{id}/{seasonID}/action", Repo.AdminDeleteRateSeason)
+
+	mux.Get("/admin/stay-rules", Repo.AdminStayRules)
+	mux.Post("/admin/stay-rules", Repo.AdminPostStayRules)
+	mux.Get("/admin/delete-stay-rule/{id}/action", Repo.AdminDeleteStayRule)
+
+	mux.Get("/admin/cancellation-policies", Repo.AdminCancellationPolicies)
+	mux.Post("/admin/cancellation-policies", Repo.AdminPostCancellationPolicies)
+	mux.Post("/admin/cancellation-policies/tiers", Repo.AdminPostCancellationTier)
+	mux.Get("/admin/delete-cancellation-tier/{id}/action", Repo.AdminDeleteCancellationTier)
+
+	mux.Get("/admin/tax-rules", Repo.AdminTaxRules)
+	mux.Post("/admin/tax-rules", Repo.AdminPostTaxRules)
+	mux.Get("/admin/delete-tax-rule/{id}/action", Repo.AdminDeleteTaxRule)
+
+	mux.Get("/admin/promo-codes", Repo.AdminPromoCodes)
+	mux.Post("/admin/promo-codes", Repo.AdminPostPromoCodes)
+	mux.Get("/admin/delete-promo-code/{id}/action", Repo.AdminDeletePromoCode)
+
+	mux.Get("/admin/waitlist", Repo.AdminWaitlist)
+
+	mux.Get("/admin/guests", Repo.AdminGuests)
+	mux.Get("/admin/guests/{id}/show", Repo.AdminShowGuest)
+	mux.Post("/admin/guests/{id}/merge", Repo.AdminPostMergeGuest)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 	/**
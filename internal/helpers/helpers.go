@@ -1,10 +1,16 @@
 package helpers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/loidinhm31/go-bookings-system/internal/config"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
 	"net/http"
+	"regexp"
 	"runtime/debug"
+	"strconv"
+	"strings"
 )
 
 var app *config.AppConfig
@@ -28,3 +34,62 @@ func IsAuthenticated(r *http.Request) bool {
 	exists := app.SessionManager.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// UserRole returns the role of the logged in user, a guest without permissions when nobody is logged in
+func UserRole(r *http.Request) models.Role {
+	if !IsAuthenticated(r) {
+		return models.RoleGuest
+	}
+	return models.Role(app.SessionManager.GetInt(r.Context(), "access_level"))
+}
+
+// IsStaff reports whether the logged in user can use the admin area
+func IsStaff(r *http.Request) bool {
+	return UserRole(r).IsStaff()
+}
+
+// Can reports whether the logged in user has the permission
+func Can(r *http.Request, p models.Permission) bool {
+	return UserRole(r).Can(p)
+}
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a name like "General's Quarters" into an url slug like "generals-quarters"
+func Slugify(name string) string {
+	slug := strings.ToLower(strings.ReplaceAll(name, "'", ""))
+	slug = nonSlugChars.ReplaceAllString(slug, "-")
+	return strings.Trim(slug, "-")
+}
+
+// ParseMoney converts an amount like "99.50" to cents
+func ParseMoney(amount string) (int, error) {
+	whole, fraction, _ := strings.Cut(strings.TrimSpace(amount), ".")
+	cents, err := strconv.Atoi(whole)
+	if err != nil {
+		return 0, err
+	}
+	cents *= 100
+
+	if fraction != "" {
+		if len(fraction) == 1 {
+			fraction += "0"
+		}
+		f, err := strconv.Atoi(fraction[:2])
+		if err != nil {
+			return 0, err
+		}
+		cents += f
+	}
+	return cents, nil
+}
+
+// RandomHex returns n random bytes encoded as hex, for use in file names and tokens
+func RandomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,119 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+// ErrUnsupportedType is returned for uploads which are not jpeg, png or gif images
+var ErrUnsupportedType = errors.New("unsupported image type")
+
+// ErrTooManyPixels is returned for images which are larger than MaxPixels
+var ErrTooManyPixels = errors.New("image has too many pixels")
+
+// MaxPixels is the largest image decoded, a small file may declare a huge image which takes gigabytes to decode
+const MaxPixels = 25_000_000
+
+// extensions maps the supported content types to the file extension used to store them
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// Extension sniffs the content type of data and returns the file extension for it
+func Extension(data []byte) (string, error) {
+	ext, ok := extensions[http.DetectContentType(data)]
+	if !ok {
+		return "", ErrUnsupportedType
+	}
+	return ext, nil
+}
+
+// Decode decodes an image in any of the supported formats. The size declared in its header is checked first,
+// ErrTooManyPixels is returned without decoding an image larger than MaxPixels
+func Decode(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// Thumbnail scales src down to fit into maxWidth x maxHeight, keeping the aspect ratio.
+// Images which already fit are returned unchanged
+func Thumbnail(src image.Image, maxWidth, maxHeight int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return src
+	}
+
+	// scale by the side which exceeds its limit the most
+	tw, th := maxWidth, h*maxWidth/w
+	if th > maxHeight {
+		tw, th = w*maxHeight/h, maxHeight
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		// area of the source image covered by this row of the thumbnail
+		sy0 := b.Min.Y + y*h/th
+		sy1 := b.Min.Y + (y+1)*h/th
+		for x := 0; x < tw; x++ {
+			sx0 := b.Min.X + x*w/tw
+			sx1 := b.Min.X + (x+1)*w/tw
+			dst.Set(x, y, average(src, sx0, sy0, sx1, sy1))
+		}
+	}
+	return dst
+}
+
+// average returns the mean color of the source pixels in the given box
+func average(src image.Image, x0, y0, x1, y1 int) color.Color {
+	var r, g, b, a, n uint64
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			cr, cg, cb, ca := src.At(x, y).RGBA()
+			r += uint64(cr)
+			g += uint64(cg)
+			b += uint64(cb)
+			a += uint64(ca)
+			n++
+		}
+	}
+	if n == 0 {
+		return src.At(x0, y0)
+	}
+	return color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)}
+}
+
+// EncodeJPEG encodes img as a jpeg
+func EncodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,87 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+func TestThumbnail(t *testing.T) {
+	var tests = []struct {
+		name           string
+		width, height  int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{"landscape", 1600, 900, 400, 225},
+		{"portrait", 900, 1600, 168, 300},
+		{"already-small", 200, 100, 200, 100},
+	}
+
+	for _, e := range tests {
+		src := image.NewRGBA(image.Rect(0, 0, e.width, e.height))
+		thumb := Thumbnail(src, 400, 300)
+
+		b := thumb.Bounds()
+		if b.Dx() != e.expectedWidth || b.Dy() != e.expectedHeight {
+			t.Errorf("failed %s: expected %dx%d but got %dx%d", e.name, e.expectedWidth, e.expectedHeight, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestThumbnailKeepsColor(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 800, 800))
+	red := color.RGBA{R: 255, A: 255}
+	for y := 0; y < 800; y++ {
+		for x := 0; x < 800; x++ {
+			src.Set(x, y, red)
+		}
+	}
+
+	thumb := Thumbnail(src, 100, 100)
+	r, g, b, _ := thumb.At(50, 50).RGBA()
+	if r>>8 != 255 || g != 0 || b != 0 {
+		t.Errorf("expected a red thumbnail, got %v", thumb.At(50, 50))
+	}
+}
+
+func TestExtension(t *testing.T) {
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+
+	ext, err := Extension(buf.Bytes())
+	if err != nil || ext != ".png" {
+		t.Errorf("expected .png, got %q, %v", ext, err)
+	}
+
+	_, err = Extension([]byte("just some text"))
+	if err != ErrUnsupportedType {
+		t.Error("accepted text as an image")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var small bytes.Buffer
+	if err := gif.Encode(&small, image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black}), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Decode(small.Bytes())
+	if err != nil || img.Bounds().Dx() != 2 {
+		t.Errorf("expected a 2 pixels wide image, got %v, %v", img, err)
+	}
+
+	// the same file declaring a screen of 65535 x 65535 pixels
+	huge := append([]byte{}, small.Bytes()...)
+	copy(huge[6:10], []byte{0xff, 0xff, 0xff, 0xff})
+	if _, err = Decode(huge); err != ErrTooManyPixels {
+		t.Errorf("expected ErrTooManyPixels, got %v", err)
+	}
+
+	if _, err = Decode([]byte("hello, world")); err == nil {
+		t.Error("expected an error for data which is no image")
+	}
+}
@@ -0,0 +1,199 @@
+package invoices
+
+import (
+	"fmt"
+	"github.com/loidinhm31/go-bookings-system/internal/constants"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"github.com/loidinhm31/go-bookings-system/internal/pdf"
+	"github.com/loidinhm31/go-bookings-system/internal/pricing"
+	"github.com/loidinhm31/go-bookings-system/internal/render"
+)
+
+// Seller is the name the invoices are issued under
+const Seller = "Forth Berg Bed and Breakfast"
+
+// Build returns the invoice of a reservation, without number and issue date which are set when it is stored.
+// The stay is charged night by night as quoted, grouped by nightly price, unless the quote no longer matches the
+// booked total, e.g. because the rate plan was edited since, in which case the booked total is charged as one line.
+// The discount of a promo code and the taxes follow as charged on the reservation. A cancelled reservation is charged its cancellation fee instead
+func Build(res models.Reservation, quote pricing.Quote, history []models.Payment, cancellation *models.Cancellation) models.Invoice {
+	inv := models.Invoice{
+		ReservationID:    res.ID,
+		ConfirmationCode: res.ConfirmationCode,
+		BillTo:           fmt.Sprintf("%s %s", res.FirstName, res.LastName),
+		Email:            res.Email,
+		RoomName:         res.Room.RoomName,
+		StartDate:        res.StartDate,
+		EndDate:          res.EndDate,
+	}
+
+	stay := res.TotalPrice + res.Discount
+	for _, t := range res.Taxes {
+		stay -= t.Amount
+	}
+
+	switch {
+	case res.Status == models.StatusCancelled:
+		if cancellation != nil {
+			description := fmt.Sprintf("Cancellation fee, %d%% of %s", cancellation.FeePercent, render.FormatMoney(res.TotalPrice))
+			inv.Lines = append(inv.Lines, charge(description, 1, cancellation.Fee))
+		}
+	case quote.Subtotal == stay:
+		inv.Lines = append(inv.Lines, nightLines(res.Room.RoomName, quote)...)
+	default:
+		description := fmt.Sprintf("%s, %d nights", res.Room.RoomName, len(quote.Nights))
+		inv.Lines = append(inv.Lines, charge(description, 1, stay))
+	}
+
+	if res.Status != models.StatusCancelled {
+		if res.Discount > 0 {
+			inv.Lines = append(inv.Lines, charge("Promo code "+res.PromoCode, 1, -res.Discount))
+		}
+		for _, t := range res.Taxes {
+			inv.Lines = append(inv.Lines, charge(t.Name, 1, t.Amount))
+		}
+	}
+
+	for _, p := range history {
+		var line models.InvoiceLine
+		switch p.Kind {
+		case models.PaymentCapture:
+			line = payment(fmt.Sprintf("Payment received %s (%s)", p.CreatedAt.Format(constants.Layout), p.Reference), p.Amount)
+		case models.PaymentRefund:
+			line = payment(fmt.Sprintf("Refund %s (%s)", p.CreatedAt.Format(constants.Layout), p.Reference), -p.Amount)
+		default:
+			// authorizations are not collected yet
+			continue
+		}
+		inv.Lines = append(inv.Lines, line)
+	}
+
+	for _, line := range inv.Lines {
+		if line.Kind == models.InvoiceLinePayment {
+			inv.Paid += line.Amount
+		} else {
+			inv.Total += line.Amount
+		}
+	}
+	return inv
+}
+
+// nightLines charges the nights of the quote, one line per nightly price in the order the prices first occur
+func nightLines(roomName string, quote pricing.Quote) []models.InvoiceLine {
+	var lines []models.InvoiceLine
+	index := make(map[int]int)
+	for _, night := range quote.Nights {
+		i, ok := index[night.Price]
+		if !ok {
+			index[night.Price] = len(lines)
+			lines = append(lines, charge(fmt.Sprintf("%s, night", roomName), 0, night.Price))
+			i = len(lines) - 1
+		}
+		lines[i].Quantity++
+		lines[i].Amount = lines[i].Quantity * lines[i].UnitPrice
+	}
+	return lines
+}
+
+func charge(description string, quantity, unitPrice int) models.InvoiceLine {
+	return models.InvoiceLine{
+		Kind:        models.InvoiceLineCharge,
+		Description: description,
+		Quantity:    quantity,
+		UnitPrice:   unitPrice,
+		Amount:      quantity * unitPrice,
+	}
+}
+
+func payment(description string, amount int) models.InvoiceLine {
+	return models.InvoiceLine{
+		Kind:        models.InvoiceLinePayment,
+		Description: description,
+		Quantity:    1,
+		UnitPrice:   amount,
+		Amount:      amount,
+	}
+}
+
+// layout of the invoice page, in points from the top left corner
+const (
+	left      = 50.0
+	right     = pdf.PageWidth - 50
+	quantityX = 370.0
+	unitX     = 460.0
+	rowHeight = 16.0
+	bottom    = pdf.PageHeight - 70
+)
+
+// Render lays out the invoice as a PDF document
+func Render(inv models.Invoice) *pdf.Document {
+	doc := pdf.New()
+	doc.AddPage()
+
+	doc.Text(left, 70, pdf.HelveticaBold, 22, "INVOICE")
+	doc.TextRight(right, 62, pdf.HelveticaBold, 12, inv.Label())
+	doc.TextRight(right, 78, pdf.Helvetica, 10, "Issued "+inv.IssuedAt.Format(constants.Layout))
+
+	doc.Text(left, 120, pdf.HelveticaBold, 10, Seller)
+
+	doc.Text(left, 150, pdf.HelveticaBold, 10, "Bill to")
+	doc.Text(left, 165, pdf.Helvetica, 10, inv.BillTo)
+	doc.Text(left, 180, pdf.Helvetica, 10, inv.Email)
+
+	doc.Text(330, 150, pdf.HelveticaBold, 10, "Reservation "+inv.ConfirmationCode)
+	doc.Text(330, 165, pdf.Helvetica, 10, inv.RoomName)
+	doc.Text(330, 180, pdf.Helvetica, 10, fmt.Sprintf("%s to %s",
+		inv.StartDate.Format(constants.Layout), inv.EndDate.Format(constants.Layout)))
+
+	y := 220.0
+	header := func() {
+		doc.Text(left, y, pdf.HelveticaBold, 10, "Description")
+		doc.TextRight(quantityX, y, pdf.HelveticaBold, 10, "Qty")
+		doc.TextRight(unitX, y, pdf.HelveticaBold, 10, "Unit price")
+		doc.TextRight(right, y, pdf.HelveticaBold, 10, "Amount")
+		doc.Line(left, y+5, right, y+5)
+		y += rowHeight + 4
+	}
+
+	// row moves to the next row, continuing on a new page when the current one is full
+	row := func() {
+		y += rowHeight
+		if y > bottom {
+			doc.AddPage()
+			y = 70
+			header()
+		}
+	}
+
+	header()
+	for _, kind := range []models.InvoiceLineKind{models.InvoiceLineCharge, models.InvoiceLinePayment} {
+		for _, line := range inv.Lines {
+			if line.Kind != kind {
+				continue
+			}
+			doc.Text(left, y, pdf.Helvetica, 10, line.Description)
+			doc.TextRight(quantityX, y, pdf.Helvetica, 10, fmt.Sprint(line.Quantity))
+			doc.TextRight(unitX, y, pdf.Helvetica, 10, render.FormatMoney(line.UnitPrice))
+			doc.TextRight(right, y, pdf.Helvetica, 10, render.FormatMoney(line.Amount))
+			row()
+		}
+
+		doc.Line(unitX-60, y-rowHeight+5, right, y-rowHeight+5)
+		if kind == models.InvoiceLineCharge {
+			doc.TextRight(unitX, y, pdf.HelveticaBold, 10, "Total")
+			doc.TextRight(right, y, pdf.HelveticaBold, 10, render.FormatMoney(inv.Total))
+		} else {
+			doc.TextRight(unitX, y, pdf.HelveticaBold, 10, "Paid")
+			doc.TextRight(right, y, pdf.HelveticaBold, 10, render.FormatMoney(inv.Paid))
+		}
+		row()
+		row()
+	}
+
+	doc.TextRight(unitX, y, pdf.HelveticaBold, 12, "Balance due")
+	doc.TextRight(right, y, pdf.HelveticaBold, 12, render.FormatMoney(inv.BalanceDue()))
+
+	doc.Text(left, pdf.PageHeight-40, pdf.Helvetica, 8,
+		fmt.Sprintf("%s - %s - thank you for staying with us", Seller, inv.Label()))
+	return doc
+}
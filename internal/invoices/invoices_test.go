@@ -0,0 +1,201 @@
+package invoices
+
+import (
+	"bytes"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"github.com/loidinhm31/go-bookings-system/internal/pricing"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+// testQuote prices a thursday at 100.00 and friday and saturday at 120.00
+var testQuote = pricing.Quote{
+	Nights: []pricing.Night{
+		{Date: date("2050-06-09"), Price: 10000},
+		{Date: date("2050-06-10"), Price: 12000},
+		{Date: date("2050-06-11"), Price: 12000},
+	},
+	Subtotal: 34000,
+	Total:    34000,
+}
+
+var testHistory = []models.Payment{
+	{Kind: models.PaymentAuthorization, Amount: 10200, Reference: "fake_1"},
+	{Kind: models.PaymentCapture, Amount: 10200, Reference: "fake_1", CreatedAt: date("2050-06-01")},
+	{Kind: models.PaymentRefund, Amount: 2000, Reference: "fake_1", CreatedAt: date("2050-06-02")},
+}
+
+func testReservation(status models.ReservationStatus, total int) models.Reservation {
+	return models.Reservation{
+		ID:               1,
+		ConfirmationCode: "7K3M9QXA",
+		FirstName:        "John",
+		LastName:         "Smith",
+		Email:            "john@smith.com",
+		StartDate:        date("2050-06-09"),
+		EndDate:          date("2050-06-12"),
+		Room:             models.Room{RoomName: "General's Quarters"},
+		Status:           status,
+		TotalPrice:       total,
+	}
+}
+
+// discounted redeems a promo code on a reservation
+func discounted(res models.Reservation) models.Reservation {
+	res.PromoCodeID = 1
+	res.PromoCode = "SUMMER10"
+	res.Discount = 3400
+	return res
+}
+
+// taxed adds the taxes charged on a reservation
+func taxed(res models.Reservation) models.Reservation {
+	res.Taxes = []models.ReservationTax{{Name: "VAT", Amount: 3400}, {Name: "City tax", Amount: 1200}}
+	return res
+}
+
+func TestBuild(t *testing.T) {
+	cancellation := &models.Cancellation{FeePercent: 50, Fee: 17000}
+
+	var tests = []struct {
+		name            string
+		res             models.Reservation
+		cancellation    *models.Cancellation
+		expectedCharges []models.InvoiceLine
+		expectedTotal   int
+	}{
+		{
+			"nights-grouped-by-price",
+			testReservation(models.StatusConfirmed, 34000),
+			nil,
+			[]models.InvoiceLine{
+				{Description: "General's Quarters, night", Quantity: 1, UnitPrice: 10000, Amount: 10000},
+				{Description: "General's Quarters, night", Quantity: 2, UnitPrice: 12000, Amount: 24000},
+			},
+			34000,
+		},
+		{
+			"repriced-since-booking",
+			testReservation(models.StatusCheckedOut, 30000),
+			nil,
+			[]models.InvoiceLine{
+				{Description: "General's Quarters, 3 nights", Quantity: 1, UnitPrice: 30000, Amount: 30000},
+			},
+			30000,
+		},
+		{
+			"taxes",
+			taxed(testReservation(models.StatusConfirmed, 34000+3400+1200)),
+			nil,
+			[]models.InvoiceLine{
+				{Description: "General's Quarters, night", Quantity: 1, UnitPrice: 10000, Amount: 10000},
+				{Description: "General's Quarters, night", Quantity: 2, UnitPrice: 12000, Amount: 24000},
+				{Description: "VAT", Quantity: 1, UnitPrice: 3400, Amount: 3400},
+				{Description: "City tax", Quantity: 1, UnitPrice: 1200, Amount: 1200},
+			},
+			38600,
+		},
+		{
+			"taxes-repriced-since-booking",
+			taxed(testReservation(models.StatusConfirmed, 30000+3400+1200)),
+			nil,
+			[]models.InvoiceLine{
+				{Description: "General's Quarters, 3 nights", Quantity: 1, UnitPrice: 30000, Amount: 30000},
+				{Description: "VAT", Quantity: 1, UnitPrice: 3400, Amount: 3400},
+				{Description: "City tax", Quantity: 1, UnitPrice: 1200, Amount: 1200},
+			},
+			34600,
+		},
+		{
+			"discount",
+			taxed(discounted(testReservation(models.StatusConfirmed, 34000-3400+3400+1200))),
+			nil,
+			[]models.InvoiceLine{
+				{Description: "General's Quarters, night", Quantity: 1, UnitPrice: 10000, Amount: 10000},
+				{Description: "General's Quarters, night", Quantity: 2, UnitPrice: 12000, Amount: 24000},
+				{Description: "Promo code SUMMER10", Quantity: 1, UnitPrice: -3400, Amount: -3400},
+				{Description: "VAT", Quantity: 1, UnitPrice: 3400, Amount: 3400},
+				{Description: "City tax", Quantity: 1, UnitPrice: 1200, Amount: 1200},
+			},
+			35200,
+		},
+		{
+			"cancelled",
+			testReservation(models.StatusCancelled, 34000),
+			cancellation,
+			[]models.InvoiceLine{
+				{Description: "Cancellation fee, 50% of 340.00", Quantity: 1, UnitPrice: 17000, Amount: 17000},
+			},
+			17000,
+		},
+		{
+			"cancelled-without-record",
+			testReservation(models.StatusCancelled, 34000),
+			nil,
+			nil,
+			0,
+		},
+	}
+
+	for _, e := range tests {
+		inv := Build(e.res, testQuote, testHistory, e.cancellation)
+
+		var charges []models.InvoiceLine
+		for _, line := range inv.Lines {
+			if line.Kind == models.InvoiceLineCharge {
+				line.Kind = ""
+				charges = append(charges, line)
+			}
+		}
+		if len(charges) != len(e.expectedCharges) {
+			t.Errorf("failed %s: expected %d charges but got %d", e.name, len(e.expectedCharges), len(charges))
+			continue
+		}
+		for i, line := range charges {
+			if line != e.expectedCharges[i] {
+				t.Errorf("failed %s: expected charge %+v but got %+v", e.name, e.expectedCharges[i], line)
+			}
+		}
+
+		if inv.Total != e.expectedTotal {
+			t.Errorf("failed %s: expected total %d but got %d", e.name, e.expectedTotal, inv.Total)
+		}
+		// the capture less the refund, the authorization isn't a payment
+		if inv.Paid != 8200 {
+			t.Errorf("failed %s: expected 8200 paid but got %d", e.name, inv.Paid)
+		}
+		if inv.BalanceDue() != e.expectedTotal-8200 {
+			t.Errorf("failed %s: expected balance due %d but got %d", e.name, e.expectedTotal-8200, inv.BalanceDue())
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	inv := Build(testReservation(models.StatusConfirmed, 34000), testQuote, testHistory, nil)
+	inv.Number = 42
+	inv.IssuedAt = date("2050-06-12")
+
+	out := Render(inv).Bytes()
+	for _, want := range []string{"(INV-000042)", "(Issued 2050-06-12)", "(John Smith)", "(Reservation 7K3M9QXA)",
+		"(General's Quarters, night)", "(240.00)", "(Refund 2050-06-02 \\(fake_1\\))", "(-20.00)", "(258.00)"} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("expected the invoice to contain %s", want)
+		}
+	}
+}
+
+func TestRender_Pages(t *testing.T) {
+	inv := models.Invoice{}
+	for i := 0; i < 60; i++ {
+		inv.Lines = append(inv.Lines, charge("night", 1, 10000))
+	}
+
+	if pages := Render(inv).PageCount(); pages != 2 {
+		t.Errorf("expected the lines to continue on a second page but got %d pages", pages)
+	}
+}
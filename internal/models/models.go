@@ -1,27 +1,175 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
+
+// Role is what a user is allowed to do, it is stored as the access level of the user
+type Role int
+
+const (
+	RoleGuest        Role = 0 // a guest with an account, who can see their own bookings
+	RoleHousekeeping Role = 1 // housekeeping, who can see the reservations and the rooms
+	RoleFrontDesk    Role = 2 // the front desk, who handles the reservations, payments and guests
+	RoleManager      Role = 3 // a manager, who runs the rooms, rates and refunds as well
+	RoleOwner        Role = 4 // the owner, who can do everything
+)
+
+// Roles lists every role, from the fewest permissions to the most
+var Roles = []Role{RoleGuest, RoleHousekeeping, RoleFrontDesk, RoleManager, RoleOwner}
+
+// Label returns the role as shown to people
+func (r Role) Label() string {
+	switch r {
+	case RoleGuest:
+		return "Guest"
+	case RoleHousekeeping:
+		return "Housekeeping"
+	case RoleFrontDesk:
+		return "Front Desk"
+	case RoleManager:
+		return "Manager"
+	case RoleOwner:
+		return "Owner"
+	}
+	return fmt.Sprintf("Role %d", int(r))
+}
+
+// IsStaff reports whether the role can use the admin area
+func (r Role) IsStaff() bool {
+	return r >= RoleHousekeeping
+}
+
+// Can reports whether the role has the permission, as given by the permission matrix
+func (r Role) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Permission is an action in the admin area which only some roles are allowed to do
+type Permission string
+
+const (
+	PermViewReservations Permission = "view-reservations" // see the reservations and the calendar
+	PermEditReservations Permission = "edit-reservations" // change, cancel and block reservations, and their status
+	PermCapturePayments  Permission = "capture-payments"  // capture the payments authorized at booking
+	PermRefundPayments   Permission = "refund-payments"   // refund captured payments
+	PermViewRooms        Permission = "view-rooms"        // see the rooms
+	PermManageRooms      Permission = "manage-rooms"      // add, change and delete rooms and their photos
+	PermManageRates      Permission = "manage-rates"      // manage rate plans, stay rules and promo codes
+	PermManagePolicies   Permission = "manage-policies"   // manage cancellation policies and tax rules
+	PermViewGuests       Permission = "view-guests"       // see the guest profiles and the waitlist
+	PermMergeGuests      Permission = "merge-guests"      // merge duplicate guest profiles
+)
+
+// rolePermissions is the permission matrix, the roles not in it have no permissions
+var rolePermissions = map[Role][]Permission{
+	RoleHousekeeping: {PermViewReservations, PermViewRooms},
+	RoleFrontDesk: {PermViewReservations, PermViewRooms,
+		PermEditReservations, PermCapturePayments, PermViewGuests},
+	RoleManager: {PermViewReservations, PermViewRooms,
+		PermEditReservations, PermCapturePayments, PermViewGuests,
+		PermRefundPayments, PermManageRooms, PermManageRates, PermMergeGuests},
+	RoleOwner: {PermViewReservations, PermViewRooms,
+		PermEditReservations, PermCapturePayments, PermViewGuests,
+		PermRefundPayments, PermManageRooms, PermManageRates, PermMergeGuests,
+		PermManagePolicies},
+}
 
 // User is the user model
 type User struct {
-	ID          int
-	FirstName   string
-	LastName    string
-	Email       string
-	Password    string
-	AccessLevel int
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID              int
+	FirstName       string
+	LastName        string
+	Email           string
+	Password        string
+	AccessLevel     int
+	EmailVerifiedAt time.Time // zero until the user followed the link sent to their email address
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// Role returns the role the access level of the user stands for
+func (u User) Role() Role {
+	return Role(u.AccessLevel)
+}
+
+// IsStaff reports whether the user can use the admin area
+func (u User) IsStaff() bool {
+	return u.Role().IsStaff()
 }
 
 // Room is the room model
 type Room struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID                   int
+	RoomName             string
+	Slug                 string
+	Active               bool
+	MaxAdults            int
+	MaxChildren          int
+	Description          string
+	Amenities            []string
+	BedConfiguration     string
+	BasePrice            int // nightly price in cents
+	CancellationPolicyID int // zero when cancellations are free
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+// RoomPhoto is the room photo model
+type RoomPhoto struct {
+	ID            int
+	RoomID        int
+	FileName      string
+	ThumbnailName string
+	Caption       string
+	SortOrder     int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// RatePlan is the rate plan model
+type RatePlan struct {
+	ID              int
+	Name            string
+	Active          bool
+	WeekdayPercents [7]int      // nightly price multiplier in percent, indexed by time.Weekday
+	RoomPrices      map[int]int // nightly price in cents, by room id
+	Seasons         []RateSeason
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
 }
 
+// RateSeason is the rate season model, it overrides the nightly price of a room for a date range
+type RateSeason struct {
+	ID           int
+	RatePlanID   int
+	RoomID       int
+	Name         string
+	StartDate    time.Time
+	EndDate      time.Time // last night the season applies to
+	NightlyPrice int       // in cents
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Room         Room
+}
+
+// restriction ids, as seeded in the restrictions table
+const (
+	RestrictionReservation       = 1
+	RestrictionOwnerBlock        = 2
+	RestrictionMinimumStay       = 3
+	RestrictionMaximumStay       = 4
+	RestrictionClosedToArrival   = 5
+	RestrictionClosedToDeparture = 6
+	RestrictionHold              = 7
+)
+
 // Restriction is the restriction model
 type Restriction struct {
 	ID              int
@@ -32,18 +180,353 @@ type Restriction struct {
 
 // Reservation is the reservation model
 type Reservation struct {
+	ID               int
+	ConfirmationCode string
+	FirstName        string
+	LastName         string
+	Email            string
+	Phone            string
+	StartDate        time.Time
+	EndDate          time.Time
+	RoomID           int
+	Adults           int
+	Children         int
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Room             Room
+	Status           ReservationStatus
+	StatusChangedAt  time.Time
+	TotalPrice       int              // in cents, including the taxes and the discount
+	Taxes            []ReservationTax // the taxes and fees included in the total
+	PromoCodeID      int              // zero without promo code
+	PromoCode        string
+	Discount         int       // in cents, taken off the total
+	GroupID          int       // the first reservation of a booking of several rooms, zero for a single room
+	GuestID          int       // the guest profile the reservation is linked to, zero when it isn't
+	HoldToken        string    // the hold placed on the room while the guest fills in the reservation, session only
+	HoldExpiresAt    time.Time // when that hold is released, session only
+}
+
+// ReservationStatus is a state in the lifecycle of a reservation, see booking.Transition
+type ReservationStatus string
+
+const (
+	StatusPending    ReservationStatus = "pending"
+	StatusConfirmed  ReservationStatus = "confirmed"
+	StatusCheckedIn  ReservationStatus = "checked_in"
+	StatusCheckedOut ReservationStatus = "checked_out"
+	StatusCancelled  ReservationStatus = "cancelled"
+	StatusNoShow     ReservationStatus = "no_show"
+)
+
+// ReservationStatuses lists every reservation status in lifecycle order
+var ReservationStatuses = []ReservationStatus{
+	StatusPending,
+	StatusConfirmed,
+	StatusCheckedIn,
+	StatusCheckedOut,
+	StatusCancelled,
+	StatusNoShow,
+}
+
+// Label returns the status as shown to people
+func (s ReservationStatus) Label() string {
+	switch s {
+	case StatusPending:
+		return "Pending"
+	case StatusConfirmed:
+		return "Confirmed"
+	case StatusCheckedIn:
+		return "Checked In"
+	case StatusCheckedOut:
+		return "Checked Out"
+	case StatusCancelled:
+		return "Cancelled"
+	case StatusNoShow:
+		return "No Show"
+	}
+	return string(s)
+}
+
+// ReservationStatusChange is the reservation status history model
+type ReservationStatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    ReservationStatus
+	ToStatus      ReservationStatus
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// TaxKind tells how the amount of a tax rule is charged
+type TaxKind string
+
+const (
+	TaxPercent TaxKind = "percent" // a share of the nightly prices
+	TaxFixed   TaxKind = "fixed"   // an amount per unit of the basis
+)
+
+// Label returns the tax kind as shown to people
+func (k TaxKind) Label() string {
+	switch k {
+	case TaxPercent:
+		return "Percentage"
+	case TaxFixed:
+		return "Fixed amount"
+	}
+	return string(k)
+}
+
+// TaxBasis is what the fixed amount of a tax rule is charged for
+type TaxBasis string
+
+const (
+	TaxPerStay        TaxBasis = "stay"
+	TaxPerNight       TaxBasis = "night"
+	TaxPerPerson      TaxBasis = "person"
+	TaxPerPersonNight TaxBasis = "person_night"
+)
+
+// TaxBases lists every tax basis in the order they are offered
+var TaxBases = []TaxBasis{TaxPerStay, TaxPerNight, TaxPerPerson, TaxPerPersonNight}
+
+// Label returns the tax basis as shown to people
+func (b TaxBasis) Label() string {
+	switch b {
+	case TaxPerStay:
+		return "Per stay"
+	case TaxPerNight:
+		return "Per night"
+	case TaxPerPerson:
+		return "Per person"
+	case TaxPerPersonNight:
+		return "Per person per night"
+	}
+	return string(b)
+}
+
+// TaxRule is the tax rule model, it adds a tax or fee to stays with nights in its date range
+type TaxRule struct {
 	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
+	Name      string
+	Kind      TaxKind
+	Basis     TaxBasis  // ignored for percentages, which are taken of the nightly prices
+	Amount    int       // in cents, or in hundredths of a percent for percentages
+	StartDate time.Time // zero when the rule has no start
+	EndDate   time.Time // last night the rule applies to, zero when the rule has no end
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Room      Room
-	Processed int
+}
+
+// ReservationTax is a tax or fee charged on a reservation, as calculated when it was priced
+type ReservationTax struct {
+	ID            int
+	ReservationID int
+	Name          string
+	Amount        int // in cents
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// DiscountKind tells how the discount of a promo code is calculated
+type DiscountKind string
+
+const (
+	DiscountPercent DiscountKind = "percent" // a share of the nightly prices
+	DiscountFixed   DiscountKind = "fixed"   // an amount off the stay
+)
+
+// PromoCode is the promo code model, it takes a discount off the nightly prices of a stay
+type PromoCode struct {
+	ID         int
+	Code       string
+	Kind       DiscountKind
+	Amount     int       // in cents, or in hundredths of a percent for percentages
+	ValidFrom  time.Time // first day the code can be redeemed, zero when the code has no start
+	ValidUntil time.Time // last day the code can be redeemed, zero when the code has no end
+	MaxUses    int       // zero for unlimited
+	Uses       int       // reservations which redeemed the code and were not cancelled
+	MinNights  int       // zero for any stay
+	RoomIDs    []int     // the rooms the code is valid for, empty for every room
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Guest is the guest model, the profile the reservations of the same guest are linked to.
+// Guests are matched by their normalized email address when they book, profiles sharing a phone number are merged by hand
+type Guest struct {
+	ID             int
+	FirstName      string
+	LastName       string
+	Email          string
+	Phone          string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Stays          int // the stays the guest checked in for
+	LifetimeNights int // the nights of those stays
+}
+
+// WaitlistStatus is a state of a waitlist entry, guests wait until a room is offered to them,
+// which they claim by booking it or let expire
+type WaitlistStatus string
+
+const (
+	WaitlistWaiting  WaitlistStatus = "waiting"
+	WaitlistNotified WaitlistStatus = "notified"
+	WaitlistClaimed  WaitlistStatus = "claimed"
+	WaitlistExpired  WaitlistStatus = "expired"
+)
+
+// Label returns the status as shown to people
+func (s WaitlistStatus) Label() string {
+	switch s {
+	case WaitlistWaiting:
+		return "Waiting"
+	case WaitlistNotified:
+		return "Offered"
+	case WaitlistClaimed:
+		return "Claimed"
+	case WaitlistExpired:
+		return "Expired"
+	}
+	return string(s)
+}
+
+// WaitlistEntry is the waitlist model, a guest waiting for a room to become free for their stay
+type WaitlistEntry struct {
+	ID             int
+	FirstName      string
+	LastName       string
+	Email          string
+	StartDate      time.Time
+	EndDate        time.Time
+	Adults         int
+	Children       int
+	Status         WaitlistStatus
+	RoomID         int       // the room offered to the guest, zero while waiting
+	HoldToken      string    // the hold on the offered room until the claim expires
+	NotifiedAt     time.Time // zero while waiting
+	ClaimExpiresAt time.Time // zero while waiting
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Room           Room
+}
+
+// CancellationPolicy is the cancellation policy model, its tiers set the fee charged for cancelling shortly before arrival
+type CancellationPolicy struct {
+	ID        int
+	Name      string
+	Tiers     []CancellationTier
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CancellationTier charges FeePercent of the total price for cancellations less than HoursBefore hours before check-in
+type CancellationTier struct {
+	ID          int
+	PolicyID    int
+	HoursBefore int
+	FeePercent  int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Cancellation records how the cancellation policy applied when a reservation was cancelled
+type Cancellation struct {
+	ID            int
+	ReservationID int
+	PolicyName    string
+	HoursBefore   int
+	FeePercent    int
+	Fee           int // in cents
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// PaymentKind is the kind of operation a payment records
+type PaymentKind string
+
+const (
+	PaymentAuthorization PaymentKind = "authorization"
+	PaymentCapture       PaymentKind = "capture"
+	PaymentRefund        PaymentKind = "refund"
+	PaymentVoid          PaymentKind = "void"
+)
+
+// Label returns the payment kind as shown to people
+func (k PaymentKind) Label() string {
+	switch k {
+	case PaymentAuthorization:
+		return "Authorization"
+	case PaymentCapture:
+		return "Capture"
+	case PaymentRefund:
+		return "Refund"
+	case PaymentVoid:
+		return "Void"
+	}
+	return string(k)
+}
+
+// Payment is the payment model, one row per operation on the payment gateway
+type Payment struct {
+	ID            int
+	ReservationID int
+	Kind          PaymentKind
+	Amount        int // in cents
+	Reference     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// InvoiceLineKind tells whether an invoice line is charged to the guest or paid by them
+type InvoiceLineKind string
+
+const (
+	InvoiceLineCharge  InvoiceLineKind = "charge"
+	InvoiceLinePayment InvoiceLineKind = "payment"
+)
+
+// Invoice is the invoice model, a snapshot of what was charged and paid for a reservation when it was first issued
+type Invoice struct {
+	ID               int
+	ReservationID    int
+	Number           int // sequential, without gaps
+	ConfirmationCode string
+	BillTo           string
+	Email            string
+	RoomName         string
+	StartDate        time.Time
+	EndDate          time.Time
+	Lines            []InvoiceLine
+	Total            int // charges in cents
+	Paid             int // payments less refunds in cents
+	IssuedAt         time.Time
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// Label returns the invoice number as printed on the invoice
+func (i Invoice) Label() string {
+	return fmt.Sprintf("INV-%06d", i.Number)
+}
+
+// BalanceDue returns what is left to pay, negative when the guest paid too much
+func (i Invoice) BalanceDue() int {
+	return i.Total - i.Paid
+}
+
+// InvoiceLine is a line of an invoice. Discounts are charges and refunds are payments with negative amounts
+type InvoiceLine struct {
+	ID          int
+	InvoiceID   int
+	Kind        InvoiceLineKind
+	Description string
+	Quantity    int
+	UnitPrice   int // in cents
+	Amount      int // in cents
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
 
 // RoomRestriction is the room restriction model
@@ -54,9 +537,27 @@ type RoomRestriction struct {
 	RoomID        int
 	ReservationID int
 	RestrictionID int
+	Nights        int       // length of stay for the minimum and maximum stay rules
+	HoldToken     string    // identifies the guest a hold was placed for
+	ExpiresAt     time.Time // when a hold is released, zero for other restrictions
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
 	Reservation   Reservation
 	Restriction   Restriction
 }
+
+// FreeGap is a range of nights, from StartDate up to but not including EndDate, in which a room is free
+type FreeGap struct {
+	Room      Room
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// RuleViolation tells which stay rule rejected a stay in a room
+type RuleViolation struct {
+	RoomID        int
+	RoomName      string
+	RestrictionID int
+	Reason        string
+}
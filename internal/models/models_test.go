@@ -0,0 +1,50 @@
+package models
+
+import "testing"
+
+func TestRole_Can(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       Role
+		permission Permission
+		expected   bool
+	}{
+		{"guest", RoleGuest, PermViewReservations, false},
+		{"housekeeping-views", RoleHousekeeping, PermViewReservations, true},
+		{"housekeeping-edits", RoleHousekeeping, PermEditReservations, false},
+		{"front-desk-edits", RoleFrontDesk, PermEditReservations, true},
+		{"front-desk-refunds", RoleFrontDesk, PermRefundPayments, false},
+		{"manager-refunds", RoleManager, PermRefundPayments, true},
+		{"manager-policies", RoleManager, PermManagePolicies, false},
+		{"owner-policies", RoleOwner, PermManagePolicies, true},
+		{"unknown-role", Role(42), PermViewReservations, false},
+	}
+
+	for _, e := range tests {
+		if got := e.role.Can(e.permission); got != e.expected {
+			t.Errorf("failed %s: expected %t but got %t", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestRole_Permissions(t *testing.T) {
+	// every role can do everything the role before it can
+	for i := 1; i < len(Roles); i++ {
+		for _, p := range rolePermissions[Roles[i-1]] {
+			if !Roles[i].Can(p) {
+				t.Errorf("%s can %s, but %s can't", Roles[i-1].Label(), p, Roles[i].Label())
+			}
+		}
+	}
+}
+
+func TestRole_IsStaff(t *testing.T) {
+	if RoleGuest.IsStaff() {
+		t.Error("guest is staff")
+	}
+	for _, r := range Roles[1:] {
+		if !r.IsStaff() {
+			t.Errorf("%s is not staff", r.Label())
+		}
+	}
+}
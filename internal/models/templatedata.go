@@ -14,4 +14,11 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	IsStaff         int
+	Role            Role
+}
+
+// Can reports whether the logged in user has the permission, for templates to hide what the user can't do
+func (td *TemplateData) Can(p Permission) bool {
+	return td.Role.Can(p)
 }
@@ -0,0 +1,94 @@
+package payments
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DeclinedCard is the card number the fake gateway declines every authorization for
+const DeclinedCard = "4000000000000002"
+
+// fakePayment is the state of a payment kept by the fake gateway
+type fakePayment struct {
+	authorized int
+	captured   int
+	refunded   int
+}
+
+// FakeGateway is an in-process gateway for development and tests, which moves no money.
+// It keeps payments in memory, so they are forgotten on restart
+type FakeGateway struct {
+	mu       sync.Mutex
+	next     int
+	payments map[string]*fakePayment
+}
+
+// NewFakeGateway creates a fake gateway without any payments
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{
+		payments: make(map[string]*fakePayment),
+	}
+}
+
+// Authorize accepts any card but DeclinedCard
+func (g *FakeGateway) Authorize(amount int, cardNumber string) (string, error) {
+	if amount < 0 {
+		return "", ErrInvalidAmount
+	}
+	if cardNumber == DeclinedCard {
+		return "", ErrDeclined
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.next++
+	reference := fmt.Sprintf("fake_%d", g.next)
+	g.payments[reference] = &fakePayment{authorized: amount}
+	return reference, nil
+}
+
+// Capture collects up to the authorized amount
+func (g *FakeGateway) Capture(reference string, amount int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[reference]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	if amount <= 0 || amount > p.authorized-p.captured {
+		return ErrInvalidAmount
+	}
+	p.captured += amount
+	return nil
+}
+
+// Refund pays back up to the captured amount
+func (g *FakeGateway) Refund(reference string, amount int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[reference]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	if amount <= 0 || amount > p.captured-p.refunded {
+		return ErrInvalidAmount
+	}
+	p.refunded += amount
+	return nil
+}
+
+// Void releases the authorized amount which was not captured
+func (g *FakeGateway) Void(reference string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[reference]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	p.authorized = p.captured
+	return nil
+}
@@ -0,0 +1,82 @@
+package payments
+
+import (
+	"errors"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+)
+
+// ErrDeclined is returned when the card issuer refuses an authorization
+var ErrDeclined = errors.New("payment was declined")
+
+// ErrUnknownPayment is returned for references the gateway doesn't know
+var ErrUnknownPayment = errors.New("unknown payment reference")
+
+// ErrInvalidAmount is returned for negative amounts, and amounts above what can be captured or refunded
+var ErrInvalidAmount = errors.New("invalid payment amount")
+
+// DepositPercent is the share of the total price authorized when a reservation is made
+const DepositPercent = 30
+
+// PaymentGateway takes payments from guests. Amounts are in cents
+type PaymentGateway interface {
+	// Authorize reserves amount on the card, and returns the reference the payment is captured and refunded with
+	Authorize(amount int, cardNumber string) (string, error)
+	// Capture collects amount of an authorized payment
+	Capture(reference string, amount int) error
+	// Refund pays back amount of a captured payment
+	Refund(reference string, amount int) error
+	// Void releases the authorized amount of a payment which was not captured, nothing more can be captured after
+	Void(reference string) error
+}
+
+// Deposit returns the amount authorized as deposit for a stay with the given total price, rounded to the nearest cent
+func Deposit(total int) int {
+	return (total*DepositPercent + 50) / 100
+}
+
+// GroupDeposit returns the deposit authorized for rooms booked together. It is the sum of the deposits of the rooms,
+// so each room is given its own share of the deposit
+func GroupDeposit(stays []models.Reservation) int {
+	deposit := 0
+	for _, res := range stays {
+		deposit += Deposit(res.TotalPrice)
+	}
+	return deposit
+}
+
+// Balance sums up the payment history of a reservation
+type Balance struct {
+	Reference  string
+	Authorized int
+	Captured   int
+	Refunded   int
+}
+
+// Summarize returns the balance of the payment history of a reservation
+func Summarize(history []models.Payment) Balance {
+	var b Balance
+	for _, p := range history {
+		switch p.Kind {
+		case models.PaymentAuthorization:
+			b.Reference = p.Reference
+			b.Authorized += p.Amount
+		case models.PaymentCapture:
+			b.Captured += p.Amount
+		case models.PaymentRefund:
+			b.Refunded += p.Amount
+		case models.PaymentVoid:
+			b.Authorized -= p.Amount
+		}
+	}
+	return b
+}
+
+// Capturable returns the authorized amount which was not captured yet
+func (b Balance) Capturable() int {
+	return b.Authorized - b.Captured
+}
+
+// Refundable returns the captured amount which was not refunded yet
+func (b Balance) Refundable() int {
+	return b.Captured - b.Refunded
+}
@@ -0,0 +1,112 @@
+package payments
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"testing"
+)
+
+func TestDeposit(t *testing.T) {
+	var tests = []struct {
+		total    int
+		expected int
+	}{
+		{0, 0},
+		{10000, 3000},
+		{12345, 3704},
+	}
+
+	for _, e := range tests {
+		if got := Deposit(e.total); got != e.expected {
+			t.Errorf("expected a deposit of %d for %d, got %d", e.expected, e.total, got)
+		}
+	}
+}
+
+func TestGroupDeposit(t *testing.T) {
+	stays := []models.Reservation{{TotalPrice: 12345}, {TotalPrice: 12345}, {TotalPrice: 10000}}
+
+	// every room is rounded on its own, 3704 + 3704 + 3000 instead of 30% of 34690
+	if got := GroupDeposit(stays); got != 10408 {
+		t.Errorf("expected a deposit of 10408, got %d", got)
+	}
+	if got := GroupDeposit(nil); got != 0 {
+		t.Errorf("expected no deposit without rooms, got %d", got)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	history := []models.Payment{
+		{Kind: models.PaymentAuthorization, Amount: 3000, Reference: "fake_1"},
+		{Kind: models.PaymentCapture, Amount: 3000, Reference: "fake_1"},
+		{Kind: models.PaymentRefund, Amount: 1000, Reference: "fake_1"},
+	}
+
+	b := Summarize(history)
+	if b.Reference != "fake_1" {
+		t.Errorf("expected reference fake_1, got %s", b.Reference)
+	}
+	if b.Capturable() != 0 {
+		t.Errorf("expected nothing left to capture, got %d", b.Capturable())
+	}
+	if b.Refundable() != 2000 {
+		t.Errorf("expected 2000 left to refund, got %d", b.Refundable())
+	}
+}
+
+func TestSummarize_Void(t *testing.T) {
+	history := []models.Payment{
+		{Kind: models.PaymentAuthorization, Amount: 3000, Reference: "fake_1"},
+		{Kind: models.PaymentVoid, Amount: 3000, Reference: "fake_1"},
+	}
+
+	if b := Summarize(history); b.Capturable() != 0 {
+		t.Errorf("expected nothing left to capture after the void, got %d", b.Capturable())
+	}
+}
+
+func TestFakeGateway(t *testing.T) {
+	g := NewFakeGateway()
+
+	_, err := g.Authorize(3000, DeclinedCard)
+	if err != ErrDeclined {
+		t.Errorf("expected the declined card to be declined, got %v", err)
+	}
+
+	ref, err := g.Authorize(3000, "4242424242424242")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = g.Refund(ref, 1000); err != ErrInvalidAmount {
+		t.Errorf("refunded a payment which was not captured")
+	}
+	if err = g.Capture(ref, 4000); err != ErrInvalidAmount {
+		t.Errorf("captured more than authorized")
+	}
+	if err = g.Capture(ref, 3000); err != nil {
+		t.Errorf("failed to capture: %v", err)
+	}
+	if err = g.Refund(ref, 1000); err != nil {
+		t.Errorf("failed to refund: %v", err)
+	}
+	if err = g.Refund(ref, 2500); err != ErrInvalidAmount {
+		t.Errorf("refunded more than captured")
+	}
+	if err = g.Capture("fake_unknown", 1); err != ErrUnknownPayment {
+		t.Errorf("captured an unknown payment")
+	}
+
+	ref, err = g.Authorize(3000, "4242424242424242")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = g.Void(ref); err != nil {
+		t.Errorf("failed to void: %v", err)
+	}
+	if err = g.Capture(ref, 1); err != ErrInvalidAmount {
+		t.Errorf("captured a voided payment")
+	}
+	if err = g.Void("fake_unknown"); err != ErrUnknownPayment {
+		t.Errorf("voided an unknown payment")
+	}
+}
@@ -0,0 +1,74 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestDocument_Bytes(t *testing.T) {
+	doc := New()
+	doc.Text(50, 50, HelveticaBold, 18, "INVOICE")
+	doc.Line(50, 60, 545, 60)
+	doc.AddPage()
+	doc.TextRight(545, 50, Helvetica, 10, "100.00")
+
+	out := doc.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) {
+		t.Error("expected the PDF header")
+	}
+	if !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Error("expected the PDF trailer")
+	}
+	if !bytes.Contains(out, []byte("/Count 2")) {
+		t.Error("expected two pages")
+	}
+	if !bytes.Contains(out, []byte("BT /F2 18.00 Tf 50.00 792.00 Td (INVOICE) Tj ET")) {
+		t.Error("expected the title drawn from the top of the page")
+	}
+
+	// every xref entry must point at the start of its object
+	m := regexp.MustCompile(`startxref\n(\d+)`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("expected startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	if len(entries) != 8 {
+		t.Fatalf("expected 8 objects but got %d", len(entries))
+	}
+	for i, e := range entries {
+		offset, _ := strconv.Atoi(string(e[1]))
+		if !bytes.HasPrefix(out[offset:], []byte(fmt.Sprintf("%d 0 obj", i+1))) {
+			t.Errorf("xref entry %d doesn't point at its object", i+1)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	var tests = []struct {
+		in       string
+		expected string
+	}{
+		{"plain", "plain"},
+		{"(a) \\ b", "\\(a\\) \\\\ b"},
+		{"2 × 100.00", "2 \\327 100.00"},
+		{"Café – 5€", "Caf\\351 \\226 5\\200"},
+		{"日本", "??"},
+	}
+
+	for _, e := range tests {
+		if got := escape(e.in); got != e.expected {
+			t.Errorf("escape(%q): expected %q but got %q", e.in, e.expected, got)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	if w := TextWidth("100.00", 10); w != 30.58 {
+		t.Errorf("expected 30.58 but got %v", w)
+	}
+}
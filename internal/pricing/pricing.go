@@ -0,0 +1,131 @@
+package pricing
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"time"
+)
+
+// Night is the price of a single night of a stay
+type Night struct {
+	Date  time.Time
+	Price int // in cents
+}
+
+// Quote is the night by night price of a stay, with the discount taken off and the taxes and fees charged on top
+type Quote struct {
+	Nights   []Night
+	Subtotal int // the nights in cents
+	Discount int // in cents
+	Taxes    []models.ReservationTax
+	Total    int // the nights less the discount plus the taxes in cents
+}
+
+// Calculate prices every night of the reservation with the rate plan, takes off the discount of the promo code,
+// and adds the taxes of the rules. Without a rate plan (zero ID) every night is charged at the base price of the room,
+// without promo code (empty code) nothing is taken off.
+// Percentage taxes are taken of the nightly prices after the discount, which is spread over the nights by price
+func Calculate(res models.Reservation, room models.Room, plan models.RatePlan, rules []models.TaxRule, promo models.PromoCode) Quote {
+	var quote Quote
+	for d := res.StartDate; d.Before(res.EndDate); d = d.AddDate(0, 0, 1) {
+		price := NightlyPrice(d, room, plan)
+		quote.Nights = append(quote.Nights, Night{Date: d, Price: price})
+		quote.Subtotal += price
+	}
+
+	taxed := quote.Nights
+	// free nights have nothing to spread a discount over
+	if promo.Code != "" && quote.Subtotal > 0 {
+		quote.Discount = Discount(promo, quote.Subtotal)
+
+		taxed = make([]Night, len(quote.Nights))
+		for i, night := range quote.Nights {
+			taxed[i] = Night{Date: night.Date, Price: night.Price * (quote.Subtotal - quote.Discount) / quote.Subtotal}
+		}
+	}
+
+	quote.Total = quote.Subtotal - quote.Discount
+	for _, rule := range rules {
+		amount := Tax(rule, taxed, res.Adults+res.Children)
+		if amount == 0 {
+			continue
+		}
+		quote.Taxes = append(quote.Taxes, models.ReservationTax{Name: rule.Name, Amount: amount})
+		quote.Total += amount
+	}
+	return quote
+}
+
+// Discount returns what the promo code takes off the price of the nights, rounded to the nearest cent.
+// A fixed discount never takes off more than the nights cost
+func Discount(promo models.PromoCode, subtotal int) int {
+	if promo.Kind == models.DiscountPercent {
+		return (subtotal*promo.Amount + 5000) / 10000
+	}
+	if promo.Amount > subtotal {
+		return subtotal
+	}
+	return promo.Amount
+}
+
+// Tax returns what the rule charges for a stay of the given nights and number of guests, rounded to the nearest cent.
+// Only the nights within the date range of the rule are taxed, a rule covering none of them charges nothing
+func Tax(rule models.TaxRule, nights []Night, guests int) int {
+	covered, prices := 0, 0
+	for _, night := range nights {
+		if !rule.StartDate.IsZero() && night.Date.Before(rule.StartDate) {
+			continue
+		}
+		if !rule.EndDate.IsZero() && night.Date.After(rule.EndDate) {
+			continue
+		}
+		covered++
+		prices += night.Price
+	}
+	if covered == 0 {
+		return 0
+	}
+
+	if rule.Kind == models.TaxPercent {
+		return (prices*rule.Amount + 5000) / 10000
+	}
+
+	switch rule.Basis {
+	case models.TaxPerNight:
+		return rule.Amount * covered
+	case models.TaxPerPerson:
+		return rule.Amount * guests
+	case models.TaxPerPersonNight:
+		return rule.Amount * guests * covered
+	}
+	return rule.Amount
+}
+
+// NightlyPrice returns the price of the night starting on date.
+// The room price of the plan is overridden by a season covering the date, where the latest starting season wins,
+// and is then scaled by the multiplier of the weekday
+func NightlyPrice(date time.Time, room models.Room, plan models.RatePlan) int {
+	price := room.BasePrice
+	if plan.ID == 0 {
+		return price
+	}
+
+	if p, ok := plan.RoomPrices[room.ID]; ok {
+		price = p
+	}
+
+	var season *models.RateSeason
+	for i, s := range plan.Seasons {
+		if s.RoomID != room.ID || date.Before(s.StartDate) || date.After(s.EndDate) {
+			continue
+		}
+		if season == nil || s.StartDate.After(season.StartDate) {
+			season = &plan.Seasons[i]
+		}
+	}
+	if season != nil {
+		price = season.NightlyPrice
+	}
+
+	percent := plan.WeekdayPercents[date.Weekday()]
+	return (price*percent + 50) / 100
+}
@@ -0,0 +1,235 @@
+package pricing
+
+import (
+	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"testing"
+	"time"
+)
+
+var plan = models.RatePlan{
+	ID:              1,
+	Name:            "Standard",
+	Active:          true,
+	WeekdayPercents: [7]int{100, 100, 100, 100, 100, 120, 125},
+	RoomPrices:      map[int]int{1: 10000},
+	Seasons: []models.RateSeason{
+		{
+			RoomID:       1,
+			Name:         "Summer",
+			StartDate:    date("2050-07-01"),
+			EndDate:      date("2050-08-31"),
+			NightlyPrice: 15000,
+		},
+		{
+			RoomID:       1,
+			Name:         "Festival",
+			StartDate:    date("2050-07-14"),
+			EndDate:      date("2050-07-15"),
+			NightlyPrice: 30000,
+		},
+		{
+			RoomID:       2,
+			Name:         "Other room",
+			StartDate:    date("2050-01-01"),
+			EndDate:      date("2050-12-31"),
+			NightlyPrice: 99900,
+		},
+	},
+}
+
+var room = models.Room{ID: 1, BasePrice: 8000}
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func TestNightlyPrice(t *testing.T) {
+	var tests = []struct {
+		name     string
+		date     string
+		plan     models.RatePlan
+		expected int
+	}{
+		{"no-plan", "2050-06-06", models.RatePlan{}, 8000},
+		{"room-price", "2050-06-06", plan, 10000},
+		{"friday", "2050-06-03", plan, 12000},
+		{"saturday", "2050-06-04", plan, 12500},
+		{"season-first-night", "2050-07-01", plan, 18000},
+		{"season-last-night", "2050-08-31", plan, 15000},
+		{"after-season", "2050-09-01", plan, 10000},
+		{"overlapping-season", "2050-07-14", plan, 30000},
+		{"room-not-in-plan", "2050-06-06", models.RatePlan{ID: 2, WeekdayPercents: plan.WeekdayPercents}, 8000},
+	}
+
+	for _, e := range tests {
+		price := NightlyPrice(date(e.date), room, e.plan)
+		if price != e.expected {
+			t.Errorf("failed %s: expected %d but got %d", e.name, e.expected, price)
+		}
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	// thursday to sunday
+	res := models.Reservation{
+		RoomID:    1,
+		StartDate: date("2050-06-02"),
+		EndDate:   date("2050-06-05"),
+	}
+
+	quote := Calculate(res, room, plan, nil, models.PromoCode{})
+	if len(quote.Nights) != 3 {
+		t.Fatalf("expected 3 nights but got %d", len(quote.Nights))
+	}
+	if quote.Total != 10000+12000+12500 {
+		t.Errorf("expected total %d but got %d", 10000+12000+12500, quote.Total)
+	}
+	if !quote.Nights[2].Date.Equal(date("2050-06-04")) {
+		t.Errorf("expected last night on 2050-06-04 but got %s", quote.Nights[2].Date)
+	}
+}
+
+// thursday to sunday, 100.00, 120.00 and 125.00
+var nights = []Night{
+	{Date: date("2050-06-02"), Price: 10000},
+	{Date: date("2050-06-03"), Price: 12000},
+	{Date: date("2050-06-04"), Price: 12500},
+}
+
+func TestTax(t *testing.T) {
+	var tests = []struct {
+		name     string
+		rule     models.TaxRule
+		expected int
+	}{
+		{"vat", models.TaxRule{Kind: models.TaxPercent, Amount: 1000}, 3450},
+		{"vat-rounded", models.TaxRule{Kind: models.TaxPercent, Amount: 775}, 2674},
+		{"per-stay", models.TaxRule{Kind: models.TaxFixed, Basis: models.TaxPerStay, Amount: 500}, 500},
+		{"per-night", models.TaxRule{Kind: models.TaxFixed, Basis: models.TaxPerNight, Amount: 500}, 1500},
+		{"per-person", models.TaxRule{Kind: models.TaxFixed, Basis: models.TaxPerPerson, Amount: 500}, 1000},
+		{"per-person-night", models.TaxRule{Kind: models.TaxFixed, Basis: models.TaxPerPersonNight, Amount: 250}, 1500},
+		{"from-friday", models.TaxRule{Kind: models.TaxFixed, Basis: models.TaxPerNight, Amount: 500,
+			StartDate: date("2050-06-03")}, 1000},
+		{"until-friday", models.TaxRule{Kind: models.TaxPercent, Amount: 1000,
+			EndDate: date("2050-06-03")}, 2200},
+		{"friday-only", models.TaxRule{Kind: models.TaxFixed, Basis: models.TaxPerStay, Amount: 500,
+			StartDate: date("2050-06-03"), EndDate: date("2050-06-03")}, 500},
+		{"before-stay", models.TaxRule{Kind: models.TaxFixed, Basis: models.TaxPerStay, Amount: 500,
+			StartDate: date("2050-01-01"), EndDate: date("2050-06-01")}, 0},
+		{"after-stay", models.TaxRule{Kind: models.TaxFixed, Basis: models.TaxPerStay, Amount: 500,
+			StartDate: date("2050-06-05")}, 0},
+	}
+
+	for _, e := range tests {
+		tax := Tax(e.rule, nights, 2)
+		if tax != e.expected {
+			t.Errorf("failed %s: expected %d but got %d", e.name, e.expected, tax)
+		}
+	}
+}
+
+func TestCalculate_Taxes(t *testing.T) {
+	res := models.Reservation{
+		RoomID:    1,
+		StartDate: date("2050-06-02"),
+		EndDate:   date("2050-06-05"),
+		Adults:    2,
+		Children:  1,
+	}
+	rules := []models.TaxRule{
+		{Name: "VAT", Kind: models.TaxPercent, Amount: 1000},
+		{Name: "City tax", Kind: models.TaxFixed, Basis: models.TaxPerPersonNight, Amount: 200},
+		{Name: "Old city tax", Kind: models.TaxFixed, Basis: models.TaxPerPersonNight, Amount: 100, EndDate: date("2049-12-31")},
+	}
+
+	quote := Calculate(res, room, plan, rules, models.PromoCode{})
+	if quote.Subtotal != 34500 {
+		t.Errorf("expected subtotal 34500 but got %d", quote.Subtotal)
+	}
+	if len(quote.Taxes) != 2 {
+		t.Fatalf("expected 2 taxes but got %d", len(quote.Taxes))
+	}
+	if quote.Taxes[0].Name != "VAT" || quote.Taxes[0].Amount != 3450 {
+		t.Errorf("expected VAT of 3450 but got %+v", quote.Taxes[0])
+	}
+	if quote.Taxes[1].Name != "City tax" || quote.Taxes[1].Amount != 1800 {
+		t.Errorf("expected city tax of 1800 but got %+v", quote.Taxes[1])
+	}
+	if quote.Total != 34500+3450+1800 {
+		t.Errorf("expected total %d but got %d", 34500+3450+1800, quote.Total)
+	}
+}
+
+func TestDiscount(t *testing.T) {
+	var tests = []struct {
+		name     string
+		promo    models.PromoCode
+		expected int
+	}{
+		{"percent", models.PromoCode{Kind: models.DiscountPercent, Amount: 1000}, 3450},
+		{"percent-rounded", models.PromoCode{Kind: models.DiscountPercent, Amount: 1250}, 4313},
+		{"fixed", models.PromoCode{Kind: models.DiscountFixed, Amount: 5000}, 5000},
+		{"fixed-above-price", models.PromoCode{Kind: models.DiscountFixed, Amount: 50000}, 34500},
+	}
+
+	for _, e := range tests {
+		discount := Discount(e.promo, 34500)
+		if discount != e.expected {
+			t.Errorf("failed %s: expected %d but got %d", e.name, e.expected, discount)
+		}
+	}
+}
+
+func TestCalculate_Discount(t *testing.T) {
+	res := models.Reservation{
+		RoomID:    1,
+		StartDate: date("2050-06-02"),
+		EndDate:   date("2050-06-05"),
+		Adults:    2,
+	}
+	rules := []models.TaxRule{
+		{Name: "VAT", Kind: models.TaxPercent, Amount: 1000},
+		{Name: "City tax", Kind: models.TaxFixed, Basis: models.TaxPerPersonNight, Amount: 200},
+	}
+	promo := models.PromoCode{ID: 1, Code: "SPRING", Kind: models.DiscountFixed, Amount: 4500}
+
+	quote := Calculate(res, room, plan, rules, promo)
+	if quote.Discount != 4500 {
+		t.Errorf("expected discount 4500 but got %d", quote.Discount)
+	}
+	// the VAT is taken of the 300.00 left after the discount, the city tax doesn't change
+	if quote.Taxes[0].Amount != 3000 {
+		t.Errorf("expected VAT of 3000 but got %d", quote.Taxes[0].Amount)
+	}
+	if quote.Taxes[1].Amount != 1200 {
+		t.Errorf("expected city tax of 1200 but got %d", quote.Taxes[1].Amount)
+	}
+	if quote.Total != 34500-4500+3000+1200 {
+		t.Errorf("expected total %d but got %d", 34500-4500+3000+1200, quote.Total)
+	}
+}
+
+func TestCalculate_DiscountFreeNights(t *testing.T) {
+	res := models.Reservation{
+		RoomID:    1,
+		StartDate: date("2050-06-02"),
+		EndDate:   date("2050-06-04"),
+		Adults:    2,
+	}
+	free := models.Room{ID: 1}
+	rules := []models.TaxRule{
+		{Name: "VAT", Kind: models.TaxPercent, Amount: 1000},
+		{Name: "City tax", Kind: models.TaxFixed, Basis: models.TaxPerPersonNight, Amount: 200},
+	}
+	promo := models.PromoCode{ID: 1, Code: "HALF", Kind: models.DiscountPercent, Amount: 5000}
+
+	quote := Calculate(res, free, models.RatePlan{}, rules, promo)
+	if quote.Subtotal != 0 || quote.Discount != 0 {
+		t.Errorf("expected nothing to discount but got subtotal %d and discount %d", quote.Subtotal, quote.Discount)
+	}
+	// only the city tax is charged for the free nights
+	if len(quote.Taxes) != 1 || quote.Total != 800 {
+		t.Errorf("expected a total of 800 from the city tax but got %d", quote.Total)
+	}
+}
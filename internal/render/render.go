@@ -12,6 +12,7 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -21,6 +22,9 @@ var functions = template.FuncMap{
 	"formatDate": FormatDate,
 	"iterate":    Iterate,
 	"add":        Add,
+	"money":      FormatMoney,
+	"join":       strings.Join,
+	"fileURL":    FileURL,
 }
 
 // NewRenderer sets the config for the template package
@@ -99,6 +103,10 @@ func addDefaultData(templateData *models.TemplateData, r *http.Request) *models.
 
 	if app.SessionManager.Exists(r.Context(), "user_id") {
 		templateData.IsAuthenticated = 1
+		templateData.Role = models.Role(app.SessionManager.GetInt(r.Context(), "access_level"))
+	}
+	if templateData.Role.IsStaff() {
+		templateData.IsStaff = 1
 	}
 	return templateData
 }
@@ -126,3 +134,18 @@ func Iterate(count int) []int {
 func Add(a, b int) int {
 	return a + b
 }
+
+// FormatMoney formats an amount in cents like 12050 as 120.50
+func FormatMoney(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// FileURL returns the url an uploaded file is served from
+func FileURL(name string) string {
+	return app.Storage.URL(name)
+}
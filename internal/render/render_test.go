@@ -66,3 +66,21 @@ func getSession() (*http.Request, error) {
 func TestNewTemplates(t *testing.T) {
 	NewRenderer(app)
 }
+
+func TestFormatMoney(t *testing.T) {
+	var tests = []struct {
+		cents    int
+		expected string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{12050, "120.50"},
+		{-250, "-2.50"},
+	}
+
+	for _, e := range tests {
+		if got := FormatMoney(e.cents); got != e.expected {
+			t.Errorf("FormatMoney(%d): expected %s but got %s", e.cents, e.expected, got)
+		}
+	}
+}
@@ -1,11 +1,31 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/loidinhm31/go-bookings-system/internal/booking"
 	"github.com/loidinhm31/go-bookings-system/internal/config"
+	"github.com/loidinhm31/go-bookings-system/internal/models"
 	"github.com/loidinhm31/go-bookings-system/internal/repository"
+	"strings"
+	"time"
 )
 
+// exclusionViolation is the postgres error code raised when an exclusion constraint is violated
+const exclusionViolation = "23P01"
+
+// uniqueViolation is the postgres error code raised when a unique index is violated
+const uniqueViolation = "23505"
+
+// blockingRestrictions lists the restriction ids which make a room unavailable: reservations, owner blocks and holds.
+// An expired hold keeps blocking until it is released, see DeleteExpiredHolds
+const blockingRestrictions = `(1, 2, 7)`
+
+// stayRuleRestrictions lists the restriction ids of the stay rules, see booking.CheckStayRules
+const stayRuleRestrictions = `(3, 4, 5, 6)`
+
 type postgresDbRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
@@ -28,3 +48,251 @@ func NewTestingRepo(testApp *config.AppConfig) repository.DatabaseRepo {
 		App: testApp,
 	}
 }
+
+// mapRestrictionError converts a violation of the room restrictions overlap constraint to repository.ErrRoomUnavailable
+func mapRestrictionError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == exclusionViolation {
+		return repository.ErrRoomUnavailable
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err was raised by a unique index
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// roomColumns is the column list read by scanRoom, the rooms table is aliased as r
+const roomColumns = `r.id, r.room_name, r.slug, r.active, r.max_adults, r.max_children, r.description, 
+	r.amenities, r.bed_configuration, r.base_price, coalesce(r.cancellation_policy_id, 0), r.created_at, r.updated_at`
+
+// scanRoom reads the room columns, followed by the columns read into dest, if any
+func scanRoom(row rowScanner, room *models.Room, dest ...interface{}) error {
+	var amenities string
+	err := row.Scan(append([]interface{}{
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Active,
+		&room.MaxAdults,
+		&room.MaxChildren,
+		&room.Description,
+		&amenities,
+		&room.BedConfiguration,
+		&room.BasePrice,
+		&room.CancellationPolicyID,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	}, dest...)...)
+	if err != nil {
+		return err
+	}
+	if amenities != "" {
+		room.Amenities = strings.Split(amenities, "\n")
+	}
+	return nil
+}
+
+// reservationColumns is the column list read by scanReservation, the reservations table is aliased as r
+// and the joined rooms table as rm
+const reservationColumns = `r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
+	r.end_date, r.room_id, r.adults, r.children, r.total_price, r.created_at, r.updated_at, r.status, 
+	coalesce(r.status_changed_at, r.created_at), coalesce(r.promo_code_id, 0), r.promo_code, r.discount, coalesce(r.group_id, 0), 
+	coalesce(r.guest_id, 0), rm.id, rm.room_name`
+
+// reservationStatus returns the status a new reservation is stored with, pending unless set
+func reservationStatus(res models.Reservation) string {
+	if res.Status == "" {
+		return string(models.StatusPending)
+	}
+	return string(res.Status)
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// queryer runs queries returning rows, on the database or inside a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// maxConfirmationCodeAttempts is how often a new confirmation code is drawn when the previous one was taken
+const maxConfirmationCodeAttempts = 5
+
+// insertReservation inserts a reservation with a fresh confirmation code, and returns its id and code.
+// A code which is already taken inserts nothing, so another code is drawn. A room joining the group of a booking
+// shares the confirmation code of the first room instead, which is passed in res.ConfirmationCode.
+// Only single reservations and the first rooms of groups hold their code uniquely
+func insertReservation(ctx context.Context, q queryRower, res models.Reservation) (int, string, error) {
+	stmt := `INSERT INTO reservations (confirmation_code, first_name, last_name, email, phone, 
+            start_date, end_date, room_id, adults, children, total_price, status, status_changed_at, 
+            promo_code_id, promo_code, discount, group_id, guest_id, created_at, updated_at) 
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, nullif($14, 0), $15, $16, 
+                    nullif($17, 0), $18, $19, $20) 
+            ON CONFLICT (confirmation_code) WHERE group_id IS NULL OR group_id = id DO NOTHING 
+            returning id;`
+
+	guestID, err := matchGuest(ctx, q, res)
+	if err != nil {
+		return 0, "", err
+	}
+
+	for i := 0; i < maxConfirmationCodeAttempts; i++ {
+		code := res.ConfirmationCode
+		if res.GroupID == 0 {
+			code, err = booking.NewConfirmationCode()
+			if err != nil {
+				return 0, "", err
+			}
+		}
+
+		var newID int
+		err = q.QueryRowContext(ctx, stmt,
+			code,
+			res.FirstName,
+			res.LastName,
+			res.Email,
+			res.Phone,
+			res.StartDate,
+			res.EndDate,
+			res.RoomID,
+			res.Adults,
+			res.Children,
+			res.TotalPrice,
+			reservationStatus(res),
+			time.Now(),
+			res.PromoCodeID,
+			res.PromoCode,
+			res.Discount,
+			res.GroupID,
+			guestID,
+			time.Now(),
+			time.Now(),
+		).Scan(&newID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		return newID, code, nil
+	}
+	return 0, "", errors.New("could not find a free confirmation code")
+}
+
+// matchGuest returns the guest profile of the guest making a reservation, matched by normalized email address only,
+// people sharing a phone are left to the merge tool. A matched profile keeps its name and fills in the phone number
+// if it was missing. A new profile is created for a guest who isn't matched
+func matchGuest(ctx context.Context, q queryRower, res models.Reservation) (int, error) {
+	// the unique index on the email address makes concurrent bookings with a new address share one profile
+	var guestID int
+	err := q.QueryRowContext(ctx, `INSERT INTO guests (first_name, last_name, email, phone, email_normalized, 
+			phone_normalized, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
+			ON CONFLICT (email_normalized) WHERE email_normalized <> '' DO UPDATE 
+			SET phone = CASE WHEN guests.phone_normalized = '' THEN excluded.phone ELSE guests.phone END, 
+			    phone_normalized = CASE WHEN guests.phone_normalized = '' THEN excluded.phone_normalized 
+			        ELSE guests.phone_normalized END, 
+			    updated_at = excluded.updated_at 
+			returning id`,
+		res.FirstName,
+		res.LastName,
+		strings.TrimSpace(res.Email),
+		res.Phone,
+		booking.NormalizeEmail(res.Email),
+		booking.NormalizePhone(res.Phone),
+		time.Now(),
+		time.Now(),
+	).Scan(&guestID)
+	return guestID, err
+}
+
+func scanReservation(row rowScanner, res *models.Reservation) error {
+	return row.Scan(
+		&res.ID,
+		&res.ConfirmationCode,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.Adults,
+		&res.Children,
+		&res.TotalPrice,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Status,
+		&res.StatusChangedAt,
+		&res.PromoCodeID,
+		&res.PromoCode,
+		&res.Discount,
+		&res.GroupID,
+		&res.GuestID,
+		&res.Room.ID,
+		&res.Room.RoomName)
+}
+
+// waitlistColumns is the column list read by scanWaitlistEntry, the waitlist table is aliased as w
+// and joined with the offered room as rm
+const waitlistColumns = `w.id, w.first_name, w.last_name, w.email, w.start_date, w.end_date, w.adults, w.children, 
+	w.status, coalesce(w.room_id, 0), w.hold_token, w.notified_at, w.claim_expires_at, w.created_at, w.updated_at, 
+	coalesce(rm.id, 0), coalesce(rm.room_name, '')`
+
+func scanWaitlistEntry(row rowScanner, e *models.WaitlistEntry) error {
+	var notifiedAt, claimExpiresAt sql.NullTime
+	err := row.Scan(
+		&e.ID,
+		&e.FirstName,
+		&e.LastName,
+		&e.Email,
+		&e.StartDate,
+		&e.EndDate,
+		&e.Adults,
+		&e.Children,
+		&e.Status,
+		&e.RoomID,
+		&e.HoldToken,
+		&notifiedAt,
+		&claimExpiresAt,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+		&e.Room.ID,
+		&e.Room.RoomName)
+	if err != nil {
+		return err
+	}
+	e.NotifiedAt = notifiedAt.Time
+	e.ClaimExpiresAt = claimExpiresAt.Time
+	return nil
+}
+
+// ratePlanColumns is the column list read by scanRatePlan, the rate_plans table is aliased as p
+const ratePlanColumns = `p.id, p.name, p.active, p.sunday_percent, p.monday_percent, p.tuesday_percent, 
+	p.wednesday_percent, p.thursday_percent, p.friday_percent, p.saturday_percent, p.created_at, p.updated_at`
+
+func scanRatePlan(row rowScanner, p *models.RatePlan) error {
+	return row.Scan(
+		&p.ID,
+		&p.Name,
+		&p.Active,
+		&p.WeekdayPercents[time.Sunday],
+		&p.WeekdayPercents[time.Monday],
+		&p.WeekdayPercents[time.Tuesday],
+		&p.WeekdayPercents[time.Wednesday],
+		&p.WeekdayPercents[time.Thursday],
+		&p.WeekdayPercents[time.Friday],
+		&p.WeekdayPercents[time.Saturday],
+		&p.CreatedAt,
+		&p.UpdatedAt)
+}
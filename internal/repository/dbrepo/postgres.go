@@ -2,9 +2,14 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"github.com/loidinhm31/go-bookings-system/internal/booking"
 	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"github.com/loidinhm31/go-bookings-system/internal/repository"
 	"golang.org/x/crypto/bcrypt"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -12,31 +17,12 @@ func (m *postgresDbRepo) AllUsers() bool {
 	return true
 }
 
-func (m *postgresDbRepo) InsertReservation(res models.Reservation) (int, error) {
+// InsertReservation inserts a reservation, and returns its id and the confirmation code it was given
+func (m *postgresDbRepo) InsertReservation(res models.Reservation) (int, string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var newID int
-
-	stmt := `INSERT INTO reservations (first_name, last_name, email, phone, 
-            start_date, end_date, room_id, created_at, updated_at) 
-            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id;`
-
-	err := m.DB.QueryRowContext(ctx, stmt,
-		res.FirstName,
-		res.LastName,
-		res.Email,
-		res.Phone,
-		res.StartDate,
-		res.EndDate,
-		res.RoomID,
-		time.Now(),
-		time.Now(),
-	).Scan(&newID)
-	if err != nil {
-		return 0, err
-	}
-	return newID, nil
+	return insertReservation(ctx, m.DB, res)
 }
 
 func (m *postgresDbRepo) InsertRoomRestriction(r models.RoomRestriction) error {
@@ -57,14 +43,353 @@ func (m *postgresDbRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 		r.RestrictionID,
 	)
 
+	if err != nil {
+		return mapRestrictionError(err)
+	}
+	return nil
+}
+
+// CreateReservation inserts a reservation together with its room restriction in a single transaction,
+// see CreateReservations
+func (m *postgresDbRepo) CreateReservation(res models.Reservation) (int, string, error) {
+	created, err := m.CreateReservations([]models.Reservation{res})
+	if err != nil {
+		return 0, "", err
+	}
+	return created[0].ID, created[0].ConfirmationCode, nil
+}
+
+// CreateReservations inserts the reservations of one booking together with their room restrictions
+// in a single transaction, and returns them with their ids and confirmation codes.
+// Availability of each room is checked again inside the transaction, so nothing is written
+// when any of the dates were taken in the meantime. The same goes for the usage limit of a redeemed promo code.
+// A stay rejected by a stay rule of its room returns a *repository.StayRuleError.
+// When several rooms are booked, all reservations are grouped under the first one and share its confirmation code.
+// A stay with a hold token takes over the hold placed for it, see PlaceHold
+func (m *postgresDbRepo) CreateReservations(stays []models.Reservation) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// lock the room rows in id order, so concurrent bookings for the same rooms are serialized without deadlocks
+	var roomIDs []int
+	for _, res := range stays {
+		roomIDs = append(roomIDs, res.RoomID)
+	}
+	sort.Ints(roomIDs)
+	for i, id := range roomIDs {
+		if i > 0 && roomIDs[i-1] == id {
+			continue
+		}
+		var roomID int
+		err = tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, id).Scan(&roomID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range roomIDs {
+		err = releaseExpiredHolds(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, res := range stays {
+		if res.PromoCodeID != 0 {
+			// a booking redeems its promo code once, however many rooms it covers
+			err = redeemPromoCode(ctx, tx, res.PromoCodeID)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	// the hold placed for the guest doesn't count, it turns into the reservation
+	query := `SELECT count(id) 
+			FROM room_restrictions 
+			WHERE room_id = $1 
+			  AND end_date > $2 
+			  AND start_date < $3
+			  AND NOT (restriction_id = $4 AND hold_token = $5)
+			  AND restriction_id IN ` + blockingRestrictions
+
+	stmt := `INSERT INTO room_restrictions(start_date, end_date, room_id, reservation_id,
+            created_at, updated_at, restriction_id)
+            VALUES ($1, $2, $3, $4, $5, $6, $7);`
+
+	convert := `UPDATE room_restrictions 
+			SET restriction_id = $1, reservation_id = $2, start_date = $3, end_date = $4, 
+			    hold_token = '', expires_at = NULL, updated_at = $5 
+			WHERE room_id = $6 
+			  AND restriction_id = $7 
+			  AND hold_token = $8`
+
+	created := make([]models.Reservation, 0, len(stays))
+	for _, res := range stays {
+		// the restrictions of the rooms inserted before are seen as well
+		var numRows int
+		err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate,
+			models.RestrictionHold, res.HoldToken).Scan(&numRows)
+		if err != nil {
+			return nil, err
+		}
+		if numRows > 0 {
+			return nil, repository.ErrRoomUnavailable
+		}
+
+		rules, err := stayRules(ctx, tx, res.StartDate, res.EndDate, res.RoomID)
+		if err != nil {
+			return nil, err
+		}
+		if violation := booking.CheckStayRules(res.StartDate, res.EndDate, rules); violation != nil {
+			violation.RoomName = res.Room.RoomName
+			return nil, &repository.StayRuleError{Violation: *violation}
+		}
+
+		if len(created) > 0 {
+			res.GroupID = created[0].ID
+			res.ConfirmationCode = created[0].ConfirmationCode
+		}
+		res.ID, res.ConfirmationCode, err = insertReservation(ctx, tx, res)
+		if err != nil {
+			return nil, err
+		}
+		if len(created) == 0 && len(stays) > 1 {
+			res.GroupID = res.ID
+			_, err = tx.ExecContext(ctx, `UPDATE reservations SET group_id = id WHERE id = $1`, res.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// turn the hold into the reservation, or reserve the room anew when the hold was released already
+		var converted int64
+		if res.HoldToken != "" {
+			result, err := tx.ExecContext(ctx, convert, models.RestrictionReservation, res.ID, res.StartDate,
+				res.EndDate, time.Now(), res.RoomID, models.RestrictionHold, res.HoldToken)
+			if err != nil {
+				return nil, mapRestrictionError(err)
+			}
+			converted, err = result.RowsAffected()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if converted == 0 {
+			_, err = tx.ExecContext(ctx, stmt,
+				res.StartDate,
+				res.EndDate,
+				res.RoomID,
+				res.ID,
+				time.Now(),
+				time.Now(),
+				models.RestrictionReservation,
+			)
+			if err != nil {
+				return nil, mapRestrictionError(err)
+			}
+		}
+		res.HoldToken = ""
+		res.HoldExpiresAt = time.Time{}
+
+		err = insertReservationTaxes(ctx, tx, res.ID, res.Taxes)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, res)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// PlaceHold holds a room for a guest until the hold expires, so nobody else can book it in the meantime.
+// Holds of the same guest on the room are replaced. It returns repository.ErrRoomUnavailable when the room
+// is reserved, blocked or held for someone else
+func (m *postgresDbRepo) PlaceHold(hold models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, hold.RoomID).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	err = releaseExpiredHolds(ctx, tx, hold.RoomID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE room_id = $1 AND restriction_id = $2 AND hold_token = $3`,
+		hold.RoomID, models.RestrictionHold, hold.HoldToken)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO room_restrictions(start_date, end_date, room_id, restriction_id, hold_token, expires_at,
+            created_at, updated_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		hold.StartDate,
+		hold.EndDate,
+		hold.RoomID,
+		models.RestrictionHold,
+		hold.HoldToken,
+		hold.ExpiresAt,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return mapRestrictionError(err)
+	}
+
+	return tx.Commit()
+}
+
+// ReleaseHold removes the holds placed for a guest, e.g. when they picked another room
+func (m *postgresDbRepo) ReleaseHold(token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM room_restrictions WHERE restriction_id = $1 AND hold_token = $2`,
+		models.RestrictionHold, token)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteExpiredHolds releases the holds which expired by now, and returns how many were released
+func (m *postgresDbRepo) DeleteExpiredHolds(now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM room_restrictions WHERE restriction_id = $1 AND expires_at <= $2`,
+		models.RestrictionHold, now)
+	if err != nil {
+		return 0, err
+	}
+
+	released, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(released), nil
+}
+
+// releaseExpiredHolds releases the expired holds on a room without waiting for DeleteExpiredHolds,
+// so they don't stand in the way of a new booking
+func releaseExpiredHolds(ctx context.Context, tx *sql.Tx, roomID int) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE room_id = $1 AND restriction_id = $2 AND expires_at <= $3`,
+		roomID, models.RestrictionHold, time.Now())
+	return err
+}
+
+// redeemPromoCode locks the promo code row, so concurrent redemptions of the same code are serialized,
+// and returns repository.ErrPromoCodeUsedUp when its usage limit is reached.
+// Cancelled reservations don't count, so cancelling gives the use back, and the rooms of one booking count once
+func redeemPromoCode(ctx context.Context, tx *sql.Tx, id int) error {
+	var maxUses int
+	err := tx.QueryRowContext(ctx, `SELECT max_uses FROM promo_codes WHERE id = $1 FOR UPDATE`, id).Scan(&maxUses)
+	if err != nil {
+		return err
+	}
+	if maxUses == 0 {
+		return nil
+	}
+
+	var uses int
+	query := `SELECT count(DISTINCT coalesce(group_id, id)) 
+			FROM reservations 
+			WHERE promo_code_id = $1 
+			  AND status <> $2`
+
+	err = tx.QueryRowContext(ctx, query, id, string(models.StatusCancelled)).Scan(&uses)
 	if err != nil {
 		return err
 	}
+	if uses >= maxUses {
+		return repository.ErrPromoCodeUsedUp
+	}
 	return nil
 }
 
-// SearchAvailabilityByRoomIDAndDates returns true if availability exists for roomID, and false if no availability
-func (m *postgresDbRepo) SearchAvailabilityByRoomIDAndDates(start, end time.Time, roomID int) (bool, error) {
+// insertReservationTaxes stores the tax breakdown of a reservation
+func insertReservationTaxes(ctx context.Context, tx *sql.Tx, reservationID int, taxes []models.ReservationTax) error {
+	stmt := `INSERT INTO reservation_taxes (reservation_id, name, amount, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5)`
+
+	for _, t := range taxes {
+		_, err := tx.ExecContext(ctx, stmt, reservationID, t.Name, t.Amount, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getReservationTaxes returns the tax breakdown of a reservation
+func (m *postgresDbRepo) getReservationTaxes(ctx context.Context, id int) ([]models.ReservationTax, error) {
+	var taxes []models.ReservationTax
+
+	query := `SELECT id, reservation_id, name, amount, created_at, updated_at
+			FROM reservation_taxes 
+			WHERE reservation_id = $1
+			ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return taxes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.ReservationTax
+		err := rows.Scan(
+			&t.ID,
+			&t.ReservationID,
+			&t.Name,
+			&t.Amount,
+			&t.CreatedAt,
+			&t.UpdatedAt)
+		if err != nil {
+			return taxes, err
+		}
+		taxes = append(taxes, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return taxes, err
+	}
+	return taxes, nil
+}
+
+// SearchAvailabilityByRoomIDAndDates checks whether a room is free for the stay and allowed by its stay rules.
+// The violated rule is returned when the room is free, but a stay rule rejects the stay
+func (m *postgresDbRepo) SearchAvailabilityByRoomIDAndDates(start, end time.Time, roomID int) (bool, *models.RuleViolation, error) {
+	return m.SearchAvailabilityByRoomIDAndDatesExcluding(start, end, roomID, 0)
+}
+
+// SearchAvailabilityByRoomIDAndDatesExcluding works like SearchAvailabilityByRoomIDAndDates,
+// but ignores the room restriction of the given reservation, so the reservation can be moved
+func (m *postgresDbRepo) SearchAvailabilityByRoomIDAndDatesExcluding(start, end time.Time, roomID, reservationID int) (bool, *models.RuleViolation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -74,75 +399,227 @@ func (m *postgresDbRepo) SearchAvailabilityByRoomIDAndDates(start, end time.Time
 			FROM room_restrictions 
 			WHERE room_id = $1 
 			  AND end_date > $2 
-			  AND start_date < $3;`
+			  AND start_date < $3
+			  AND coalesce(reservation_id, 0) <> $4
+			  AND restriction_id IN ` + blockingRestrictions
 
-	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
+	row := m.DB.QueryRowContext(ctx, query, roomID, start, end, reservationID)
 	err := row.Scan(&numRows)
 	if err != nil {
-		return false, err
+		return false, nil, err
 	}
 
 	// check override date range
-	if numRows == 0 {
-		return true, nil
+	if numRows > 0 {
+		return false, nil, nil
+	}
+
+	rules, err := stayRules(ctx, m.DB, start, end, roomID)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if violation := booking.CheckStayRules(start, end, rules); violation != nil {
+		return false, violation, nil
+	}
+	return true, nil, nil
+}
+
+// stayRules returns the stay rules which may apply to a stay from start to end, for one room or for all rooms when roomID is 0
+func stayRules(ctx context.Context, q queryer, start, end time.Time, roomID int) ([]models.RoomRestriction, error) {
+	var rules []models.RoomRestriction
+
+	query := `SELECT rr.id, rr.room_id, rr.restriction_id, rr.start_date, rr.end_date, rr.nights
+			FROM room_restrictions rr 
+			WHERE rr.restriction_id IN ` + stayRuleRestrictions + `
+			  AND rr.end_date > $1 
+			  AND rr.start_date <= $2
+			  AND ($3 = 0 OR rr.room_id = $3)
+			ORDER BY rr.start_date, rr.id`
+
+	rows, err := q.QueryContext(ctx, query, start, end, roomID)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err := rows.Scan(
+			&rr.ID,
+			&rr.RoomID,
+			&rr.RestrictionID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&rr.Nights)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, rr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
 	}
-	return false, nil
+	return rules, nil
 }
 
-// SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
-func (m *postgresDbRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+// GetStayRulesForRoom returns the stay rules of a room which cover any date from start to end,
+// or those of all rooms when roomID is 0
+func (m *postgresDbRepo) GetStayRulesForRoom(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var rooms []models.Room
+	return stayRules(ctx, m.DB, start, end, roomID)
+}
+
+// SearchAvailabilityForAllRooms returns the rooms which fit the party, as booking.Fits, and are free for the stay.
+// Free rooms which are rejected by one of their stay rules are left out, and reported as violations instead
+func (m *postgresDbRepo) SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, []models.RuleViolation, error) {
+	var violations []models.RuleViolation
 
-	query := `SELECT r.id, r.room_name 
+	rooms, err := m.queryRooms(`SELECT `+roomColumns+` 
 			FROM rooms r 
-			WHERE r.id NOT IN (
+			WHERE r.active = true 
+			  AND r.max_adults >= $3 
+			  AND r.max_adults + r.max_children >= $3 + $4 
+			  AND r.id NOT IN (
 			    SELECT room_id FROM room_restrictions rr 
 			    WHERE rr.end_date > $1 
 					AND rr.start_date < $2
-			)`
+					AND rr.restriction_id IN `+blockingRestrictions+`
+			)
+			ORDER BY r.base_price, r.room_name`, start, end, adults, children)
+	if err != nil {
+		return rooms, violations, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	rules, err := stayRules(ctx, m.DB, start, end, 0)
 	if err != nil {
-		return rooms, err
+		return rooms, violations, err
+	}
+
+	rulesByRoom := make(map[int][]models.RoomRestriction)
+	for _, rule := range rules {
+		rulesByRoom[rule.RoomID] = append(rulesByRoom[rule.RoomID], rule)
 	}
 
+	var allowed []models.Room
+	for _, room := range rooms {
+		if violation := booking.CheckStayRules(start, end, rulesByRoom[room.ID]); violation != nil {
+			violation.RoomName = room.RoomName
+			violations = append(violations, *violation)
+			continue
+		}
+		allowed = append(allowed, room)
+	}
+	return allowed, violations, nil
+}
+
+// SearchFreeGaps returns the ranges of at least the given nights between start and end in which an active room
+// fitting the party is free, ordered by room and date. The gaps are computed in a single query: the reservations
+// and blocks of each room are sorted, and a gap lies before every one starting after all earlier ones ended,
+// and after the last one
+func (m *postgresDbRepo) SearchFreeGaps(start, end time.Time, nights, adults, children int) ([]models.FreeGap, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var gaps []models.FreeGap
+
+	query := `WITH busy AS (
+			  SELECT room_id, greatest(start_date, $1::date) AS start_date, least(end_date, $2::date) AS end_date
+			  FROM room_restrictions 
+			  WHERE end_date > $1 
+			    AND start_date < $2 
+			    AND restriction_id IN ` + blockingRestrictions + `
+			), ordered AS (
+			  SELECT room_id, start_date, 
+			    max(end_date) OVER (PARTITION BY room_id ORDER BY start_date, end_date 
+			      ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING) AS previous_end
+			  FROM busy
+			), gaps AS (
+			  SELECT room_id, coalesce(previous_end, $1::date) AS start_date, start_date AS end_date 
+			  FROM ordered 
+			  WHERE start_date > coalesce(previous_end, $1::date)
+			  UNION ALL
+			  SELECT room_id, max(end_date), $2::date 
+			  FROM busy 
+			  GROUP BY room_id 
+			  HAVING max(end_date) < $2::date
+			  UNION ALL
+			  SELECT id, $1::date, $2::date 
+			  FROM rooms 
+			  WHERE id NOT IN (SELECT room_id FROM busy)
+			)
+			SELECT ` + roomColumns + `, g.start_date, g.end_date
+			FROM gaps g 
+			JOIN rooms r ON (r.id = g.room_id)
+			WHERE r.active = true 
+			  AND r.max_adults >= $4 
+			  AND r.max_adults + r.max_children >= $4 + $5 
+			  AND g.end_date - g.start_date >= $3
+			ORDER BY r.base_price, r.room_name, g.start_date`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, nights, adults, children)
+	if err != nil {
+		return gaps, err
+	}
+	defer rows.Close()
+
 	for rows.Next() {
-		var room models.Room
-		err := rows.Scan(
-			&room.ID,
-			&room.RoomName,
-		)
+		var g models.FreeGap
+		err := scanRoom(rows, &g.Room, &g.StartDate, &g.EndDate)
 		if err != nil {
-			return rooms, err
+			return gaps, err
 		}
-		rooms = append(rooms, room)
+		gaps = append(gaps, g)
 	}
+
 	if err = rows.Err(); err != nil {
-		return rooms, err
+		return gaps, err
 	}
-	defer rows.Close()
-
-	return rooms, nil
+	return gaps, nil
 }
 
+// GetRoomByID gets a room by id, retired rooms included, repository.ErrNotFound if there is no such room
 func (m *postgresDbRepo) GetRoomByID(id int) (models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var room models.Room
 
-	query := `SELECT * FROM rooms where id = $1`
+	query := `SELECT ` + roomColumns + ` 
+			FROM rooms r 
+			WHERE r.id = $1`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&room.ID,
-		&room.RoomName,
-		&room.CreatedAt,
-		&room.UpdatedAt,
-	)
+	err := scanRoom(row, &room)
+	if errors.Is(err, sql.ErrNoRows) {
+		return room, repository.ErrNotFound
+	}
+	if err != nil {
+		return room, err
+	}
+	return room, nil
+}
+
+// GetRoomBySlug returns an active room by its url slug
+func (m *postgresDbRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var room models.Room
+
+	query := `SELECT ` + roomColumns + ` 
+			FROM rooms r 
+			WHERE r.slug = $1 
+			  AND r.active = true`
+
+	row := m.DB.QueryRowContext(ctx, query, slug)
+	err := scanRoom(row, &room)
 	if err != nil {
 		return room, err
 	}
@@ -153,11 +630,14 @@ func (m *postgresDbRepo) GetUserByID(id int) (models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `SELECT u.* FROM users u WHERE id = $1`
+	query := `SELECT u.id, u.first_name, u.last_name, u.email, u.password, u.access_level, u.email_verified_at, 
+			u.created_at, u.updated_at 
+			FROM users u WHERE id = $1`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
 
 	var u models.User
+	var emailVerifiedAt sql.NullTime
 	err := row.Scan(
 		&u.ID,
 		&u.FirstName,
@@ -165,12 +645,14 @@ func (m *postgresDbRepo) GetUserByID(id int) (models.User, error) {
 		&u.Email,
 		&u.Password,
 		&u.AccessLevel,
+		&emailVerifiedAt,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
 	if err != nil {
 		return u, err
 	}
+	u.EmailVerifiedAt = emailVerifiedAt.Time
 	return u, nil
 }
 
@@ -205,9 +687,9 @@ func (m *postgresDbRepo) Authenticate(email, testPassword string) (int, string,
 	var id int
 	var hashedPassword string
 
-	query := `SELECT u.id, u.password FROM users u WHERE u.email = $1`
+	query := `SELECT u.id, u.password FROM users u WHERE lower(u.email) = $1`
 
-	row := m.DB.QueryRowContext(ctx, query, email)
+	row := m.DB.QueryRowContext(ctx, query, booking.NormalizeEmail(email))
 	err := row.Scan(&id, &hashedPassword)
 	if err != nil {
 		return id, "", err
@@ -222,63 +704,196 @@ func (m *postgresDbRepo) Authenticate(email, testPassword string) (int, string,
 	return id, hashedPassword, nil
 }
 
-func (m *postgresDbRepo) AllReservations() ([]models.Reservation, error) {
+// InsertUser registers a user with the password hashed by bcrypt, as checked by Authenticate, and returns the id
+// of the user. The email address is unverified and stored as booking.NormalizeEmail. It returns
+// repository.ErrDuplicateEmail when it is already registered
+func (m *postgresDbRepo) InsertUser(u models.User) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var reservations []models.Reservation
-
-	query := `SELECT r.*, rm.id, rm.room_name
-			FROM reservations r 
-			LEFT JOIN rooms rm on (r.room_id = rm.id) 
-			ORDER BY r.start_date ASC`
-
-	rows, err := m.DB.QueryContext(ctx, query)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), 12)
 	if err != nil {
-		return reservations, err
+		return 0, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var res models.Reservation
-		err := rows.Scan(
-			&res.ID,
-			&res.FirstName,
-			&res.LastName,
-			&res.Email,
-			&res.Phone,
-			&res.StartDate,
-			&res.EndDate,
-			&res.RoomID,
-			&res.CreatedAt,
-			&res.UpdatedAt,
-			&res.Processed,
-			&res.Room.ID,
-			&res.Room.RoomName)
-		if err != nil {
-			return reservations, err
-		}
-		reservations = append(reservations, res)
+	stmt := `INSERT INTO users (first_name, last_name, email, password, access_level, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7) 
+			returning id`
+
+	var newID int
+	err = m.DB.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		booking.NormalizeEmail(u.Email),
+		string(hashedPassword),
+		u.AccessLevel,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrDuplicateEmail
+	} else if err != nil {
+		return 0, err
 	}
-	if err = rows.Err(); err != nil {
-		return reservations, err
+	return newID, nil
+}
+
+// VerifyUserEmail records that the user followed the link sent to their email address, verifying it again keeps
+// the time it was verified first
+func (m *postgresDbRepo) VerifyUserEmail(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE users 
+			SET email_verified_at = coalesce(email_verified_at, $1), 
+			    updated_at = $2 
+			WHERE id = $3`
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now(), time.Now(), id)
+	if err != nil {
+		return err
 	}
-	return reservations, nil
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
 }
 
-func (m *postgresDbRepo) AllNewReservations() ([]models.Reservation, error) {
+// GetReservationsForAccount returns the reservations made with the email address of an account, the latest stay
+// first. Only the address itself counts, a guest profile may link reservations made by somebody else
+func (m *postgresDbRepo) GetReservationsForAccount(email string) ([]models.Reservation, error) {
+	return m.queryReservations(`SELECT `+reservationColumns+`
+			FROM reservations r 
+			LEFT JOIN rooms rm on (r.room_id = rm.id) 
+			WHERE lower(trim(r.email)) = $1 
+			ORDER BY r.start_date DESC, r.id DESC`, booking.NormalizeEmail(email))
+}
+
+// GetUserByEmail returns the user registered with the email address, in any letter case. It returns
+// repository.ErrNotFound when nobody is registered with it
+func (m *postgresDbRepo) GetUserByEmail(email string) (models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var reservations []models.Reservation
+	query := `SELECT u.id FROM users u WHERE lower(u.email) = $1`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, query, booking.NormalizeEmail(email)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, repository.ErrNotFound
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	return m.GetUserByID(id)
+}
+
+// InsertPasswordReset stores the hash of a password reset token sent to the user, the token itself is never stored
+func (m *postgresDbRepo) InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO password_resets (user_id, token_hash, expires_at, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, tokenHash, expiresAt, time.Now(), time.Now())
+	return err
+}
+
+// GetPasswordResetUserID returns the user a password reset token was sent to. It returns repository.ErrNotFound
+// when the token is unknown, used or expired
+func (m *postgresDbRepo) GetPasswordResetUserID(tokenHash string, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT pr.user_id FROM password_resets pr 
+			WHERE pr.token_hash = $1 AND pr.used_at IS NULL AND pr.expires_at > $2`
+
+	var userID int
+	err := m.DB.QueryRowContext(ctx, query, tokenHash, now).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, repository.ErrNotFound
+	}
+	return userID, err
+}
+
+// ResetPassword sets the new password, hashed by bcrypt, of the user a password reset token was sent to and returns
+// the id of the user. The token and any other reset token of the user can't be used again. It returns
+// repository.ErrNotFound when the token is unknown, used or expired
+func (m *postgresDbRepo) ResetPassword(tokenHash, password string, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	query := `SELECT r.*, rm.id, rm.room_name
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// lock the token, so it is used once only
+	query := `SELECT pr.user_id FROM password_resets pr 
+			WHERE pr.token_hash = $1 AND pr.used_at IS NULL AND pr.expires_at > $2 
+			FOR UPDATE`
+
+	var userID int
+	err = tx.QueryRowContext(ctx, query, tokenHash, now).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, repository.ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `UPDATE users SET password = $1, updated_at = $2 WHERE id = $3`
+	_, err = tx.ExecContext(ctx, stmt, string(hashedPassword), time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `UPDATE password_resets SET used_at = $1, updated_at = $2 WHERE user_id = $3 AND used_at IS NULL`
+	_, err = tx.ExecContext(ctx, stmt, now, time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// AllReservations returns the reservations with the given status, or all reservations when status is empty
+func (m *postgresDbRepo) AllReservations(status models.ReservationStatus) ([]models.Reservation, error) {
+	return m.queryReservations(`SELECT `+reservationColumns+`
 			FROM reservations r 
 			LEFT JOIN rooms rm on (r.room_id = rm.id) 
-			WHERE r.processed = 0
-			ORDER BY r.start_date ASC`
+			WHERE ($1 = '' OR r.status = $1)
+			ORDER BY r.start_date ASC`, string(status))
+}
 
-	rows, err := m.DB.QueryContext(ctx, query)
+// AllNewReservations returns the reservations which are still pending
+func (m *postgresDbRepo) AllNewReservations() ([]models.Reservation, error) {
+	return m.queryReservations(`SELECT `+reservationColumns+`
+			FROM reservations r 
+			LEFT JOIN rooms rm on (r.room_id = rm.id) 
+			WHERE r.status = $1
+			ORDER BY r.start_date ASC`, string(models.StatusPending))
+}
+
+func (m *postgresDbRepo) queryReservations(query string, args ...interface{}) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return reservations, err
 	}
@@ -286,20 +901,7 @@ func (m *postgresDbRepo) AllNewReservations() ([]models.Reservation, error) {
 
 	for rows.Next() {
 		var res models.Reservation
-		err := rows.Scan(
-			&res.ID,
-			&res.FirstName,
-			&res.LastName,
-			&res.Email,
-			&res.Phone,
-			&res.StartDate,
-			&res.EndDate,
-			&res.RoomID,
-			&res.CreatedAt,
-			&res.UpdatedAt,
-			&res.Processed,
-			&res.Room.ID,
-			&res.Room.RoomName)
+		err := scanReservation(rows, &res)
 		if err != nil {
 			return reservations, err
 		}
@@ -317,26 +919,52 @@ func (m *postgresDbRepo) GetReservationByID(id int) (models.Reservation, error)
 
 	var res models.Reservation
 
-	query := `SELECT r.*, rm.id, rm.room_name
+	query := `SELECT ` + reservationColumns + `
 			FROM reservations r 
 			LEFT JOIN rooms rm on (r.room_id = rm.id) 
 			WHERE r.id = $1`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&res.ID,
-		&res.FirstName,
-		&res.LastName,
-		&res.Email,
-		&res.Phone,
-		&res.StartDate,
-		&res.EndDate,
-		&res.RoomID,
-		&res.CreatedAt,
-		&res.UpdatedAt,
-		&res.Processed,
-		&res.Room.ID,
-		&res.Room.RoomName)
+	err := scanReservation(row, &res)
+	if err != nil {
+		return res, err
+	}
+
+	res.Taxes, err = m.getReservationTaxes(ctx, id)
+	if err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// GetReservationsInGroup returns the reservations of a booking of several rooms, the first one first
+func (m *postgresDbRepo) GetReservationsInGroup(groupID int) ([]models.Reservation, error) {
+	return m.queryReservations(`SELECT `+reservationColumns+`
+			FROM reservations r 
+			LEFT JOIN rooms rm on (r.room_id = rm.id) 
+			WHERE r.group_id = $1
+			ORDER BY r.id ASC`, groupID)
+}
+
+// GetReservationByCode returns the reservation with the given confirmation code. The rooms of a booking share
+// one code, the first room is returned for them, see GetReservationsInGroup
+func (m *postgresDbRepo) GetReservationByCode(code string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var res models.Reservation
+
+	query := `SELECT ` + reservationColumns + `
+			FROM reservations r 
+			LEFT JOIN rooms rm on (r.room_id = rm.id) 
+			WHERE r.confirmation_code = $1 
+			  AND (r.group_id IS NULL OR r.group_id = r.id)`
+
+	row := m.DB.QueryRowContext(ctx, query, code)
+	err := scanReservation(row, &res)
+	if errors.Is(err, sql.ErrNoRows) {
+		return res, repository.ErrNotFound
+	}
 	if err != nil {
 		return res, err
 	}
@@ -368,131 +996,542 @@ func (m *postgresDbRepo) UpdateReservation(res models.Reservation) error {
 	return nil
 }
 
+// DeleteReservation deletes a reservation. When the first room of a booking is deleted,
+// the next room takes over the group, so the other rooms keep sharing their confirmation code
 func (m *postgresDbRepo) DeleteReservation(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// only one room of a group holds the shared code in the unique index, so the deleted room leaves the group
+	// before the next room takes it over
+	leave := `UPDATE reservations 
+			SET group_id = (SELECT min(id) FROM reservations WHERE group_id = $1 AND id <> $1) 
+			WHERE id = $1 
+			  AND group_id = $1`
+
+	_, err = tx.ExecContext(ctx, leave, id)
+	if err != nil {
+		return err
+	}
+
+	regroup := `UPDATE reservations 
+			SET group_id = (SELECT min(id) FROM reservations WHERE group_id = $1 AND id <> $1) 
+			WHERE group_id = $1`
+
+	_, err = tx.ExecContext(ctx, regroup, id)
+	if err != nil {
+		return err
+	}
+
 	stmt := `DELETE FROM reservations 
 			WHERE id = $1`
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	_, err = tx.ExecContext(ctx, stmt, id)
 	if err != nil {
 		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
-func (m *postgresDbRepo) UpdateProcessedForReservation(id, processed int) error {
+// UpdateReservationStatus moves a reservation from one status to another and records the change.
+// repository.ErrStatusChanged is returned when the reservation is no longer in the from status.
+// Cancelling a reservation releases its room
+func (m *postgresDbRepo) UpdateReservationStatus(id int, from, to models.ReservationStatus) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = changeReservationStatus(ctx, tx, id, from, to)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// changeReservationStatus moves a reservation from one status to another and records the change in its history.
+// Cancelled reservations release their room
+func changeReservationStatus(ctx context.Context, tx *sql.Tx, id int, from, to models.ReservationStatus) error {
 	stmt := `UPDATE reservations 
-			SET processed = $1, 
-			    updated_at = $2
-			WHERE id = $3`
+			SET status = $1, 
+			    status_changed_at = $2,
+			    updated_at = $3
+			WHERE id = $4 AND status = $5`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
-		processed,
+	result, err := tx.ExecContext(ctx, stmt,
+		string(to),
+		time.Now(),
 		time.Now(),
-		id)
+		id,
+		string(from))
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return repository.ErrStatusChanged
+	}
+
+	stmt = `INSERT INTO reservation_status_changes (reservation_id, from_status, to_status, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5)`
+
+	_, err = tx.ExecContext(ctx, stmt, id, string(from), string(to), time.Now(), time.Now())
 	if err != nil {
 		return err
 	}
+
+	if to == models.StatusCancelled {
+		_, err = tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE reservation_id = $1`, id)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (m *postgresDbRepo) AllRooms() ([]models.Room, error) {
+// CancelReservation cancels a reservation which is in the from status, and records how its cancellation policy applied
+func (m *postgresDbRepo) CancelReservation(c models.Cancellation, from models.ReservationStatus) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var rooms []models.Room
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = changeReservationStatus(ctx, tx, c.ReservationID, from, models.StatusCancelled)
+	if err != nil {
+		return err
+	}
 
-	query := `SELECT r.* FROM rooms r ORDER BY r.room_name`
+	stmt := `INSERT INTO reservation_cancellations (reservation_id, policy_name, hours_before, fee_percent, fee, 
+            created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	_, err = tx.ExecContext(ctx, stmt,
+		c.ReservationID,
+		c.PolicyName,
+		c.HoursBefore,
+		c.FeePercent,
+		c.Fee,
+		time.Now(),
+		time.Now(),
+	)
 	if err != nil {
-		return rooms, err
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetCancellationForReservation returns the recorded cancellation of a reservation
+func (m *postgresDbRepo) GetCancellationForReservation(id int) (models.Cancellation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var c models.Cancellation
+
+	query := `SELECT id, reservation_id, policy_name, hours_before, fee_percent, fee, created_at, updated_at
+			FROM reservation_cancellations 
+			WHERE reservation_id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&c.ID,
+		&c.ReservationID,
+		&c.PolicyName,
+		&c.HoursBefore,
+		&c.FeePercent,
+		&c.Fee,
+		&c.CreatedAt,
+		&c.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, repository.ErrNotFound
+	}
+	if err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// GetStatusChangesForReservation returns the status history of a reservation, oldest first
+func (m *postgresDbRepo) GetStatusChangesForReservation(id int) ([]models.ReservationStatusChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var changes []models.ReservationStatusChange
+
+	query := `SELECT id, reservation_id, from_status, to_status, created_at, updated_at
+			FROM reservation_status_changes 
+			WHERE reservation_id = $1
+			ORDER BY created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return changes, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var rm models.Room
+		var c models.ReservationStatusChange
 		err := rows.Scan(
-			&rm.ID,
-			&rm.RoomName,
-			&rm.CreatedAt,
-			&rm.UpdatedAt,
-		)
+			&c.ID,
+			&c.ReservationID,
+			&c.FromStatus,
+			&c.ToStatus,
+			&c.CreatedAt,
+			&c.UpdatedAt)
 		if err != nil {
-			return rooms, err
+			return changes, err
 		}
-		rooms = append(rooms, rm)
+		changes = append(changes, c)
 	}
 
 	if err = rows.Err(); err != nil {
-		return rooms, err
+		return changes, err
 	}
-	return rooms, nil
+	return changes, nil
 }
 
-func (m *postgresDbRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+// AllRooms returns all active rooms
+func (m *postgresDbRepo) AllRooms() ([]models.Room, error) {
+	return m.queryRooms(`SELECT ` + roomColumns + ` 
+			FROM rooms r 
+			WHERE r.active = true 
+			ORDER BY r.room_name`)
+}
+
+// AllRoomsWithRetired returns all rooms, including the retired ones
+func (m *postgresDbRepo) AllRoomsWithRetired() ([]models.Room, error) {
+	return m.queryRooms(`SELECT ` + roomColumns + ` 
+			FROM rooms r 
+			ORDER BY r.active DESC, r.room_name`)
+}
+
+func (m *postgresDbRepo) queryRooms(query string, args ...interface{}) ([]models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var roomRestrictions []models.RoomRestriction
-
-	query := `SELECT rr.id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.room_id, rr.start_date, rr.end_date
-			FROM room_restrictions rr 
-			WHERE rr.end_date > $1 
-			AND rr.start_date <= $2 
-			AND rr.room_id = $3`
+	var rooms []models.Room
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return rooms, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var rr models.RoomRestriction
-		err := rows.Scan(
-			&rr.ID,
-			&rr.ReservationID,
-			&rr.RestrictionID,
-			&rr.RoomID,
-			&rr.StartDate,
-			&rr.EndDate)
+		var rm models.Room
+		err := scanRoom(rows, &rm)
 		if err != nil {
-			return nil, err
+			return rooms, err
 		}
-		roomRestrictions = append(roomRestrictions, rr)
+		rooms = append(rooms, rm)
 	}
+
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return rooms, err
 	}
-	return roomRestrictions, nil
+	return rooms, nil
 }
 
-func (m *postgresDbRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+// InsertRoom inserts a new room and returns its id
+func (m *postgresDbRepo) InsertRoom(room models.Room) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `INSERT INTO room_restrictions(start_date, end_date, room_id, restriction_id, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6)`
+	var newID int
 
-	_, err := m.DB.ExecContext(ctx, stmt,
-		startDate,
-		startDate.AddDate(0, 0, 1),
-		id,
-		2,
+	stmt := `INSERT INTO rooms (room_name, slug, active, max_adults, max_children, description, 
+            amenities, bed_configuration, base_price, cancellation_policy_id, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, nullif($10, 0), $11, $12) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Active,
+		room.MaxAdults,
+		room.MaxChildren,
+		room.Description,
+		strings.Join(room.Amenities, "\n"),
+		room.BedConfiguration,
+		room.BasePrice,
+		room.CancellationPolicyID,
 		time.Now(),
-		time.Now())
-	if err != nil {
+		time.Now(),
+	).Scan(&newID)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrDuplicateSlug
+	} else if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdateRoom updates the details of a room
+func (m *postgresDbRepo) UpdateRoom(room models.Room) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE rooms 
+			SET room_name = $1, 
+			    slug = $2,
+			    active = $3,
+			    max_adults = $4,
+			    max_children = $5,
+			    description = $6,
+			    amenities = $7,
+			    bed_configuration = $8,
+			    base_price = $9,
+			    cancellation_policy_id = nullif($10, 0),
+			    updated_at = $11
+			WHERE id = $12`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Active,
+		room.MaxAdults,
+		room.MaxChildren,
+		room.Description,
+		strings.Join(room.Amenities, "\n"),
+		room.BedConfiguration,
+		room.BasePrice,
+		room.CancellationPolicyID,
+		time.Now(),
+		room.ID)
+	if isUniqueViolation(err) {
+		return repository.ErrDuplicateSlug
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteRoom retires a room, so it is no longer offered to guests. The room row is kept,
+// since deleting it would cascade to its reservations
+func (m *postgresDbRepo) DeleteRoom(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE rooms 
+			SET active = false, 
+			    updated_at = $1
+			WHERE id = $2`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetPhotosForRoom returns the photos of a room in display order
+func (m *postgresDbRepo) GetPhotosForRoom(roomID int) ([]models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var photos []models.RoomPhoto
+
+	query := `SELECT p.id, p.room_id, p.file_name, p.thumbnail_name, p.caption, p.sort_order, p.created_at, p.updated_at
+			FROM room_photos p 
+			WHERE p.room_id = $1 
+			ORDER BY p.sort_order, p.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return photos, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.RoomPhoto
+		err := rows.Scan(
+			&p.ID,
+			&p.RoomID,
+			&p.FileName,
+			&p.ThumbnailName,
+			&p.Caption,
+			&p.SortOrder,
+			&p.CreatedAt,
+			&p.UpdatedAt)
+		if err != nil {
+			return photos, err
+		}
+		photos = append(photos, p)
+	}
+	if err = rows.Err(); err != nil {
+		return photos, err
+	}
+	return photos, nil
+}
+
+func (m *postgresDbRepo) GetRoomPhotoByID(id int) (models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p models.RoomPhoto
+
+	query := `SELECT p.id, p.room_id, p.file_name, p.thumbnail_name, p.caption, p.sort_order, p.created_at, p.updated_at
+			FROM room_photos p 
+			WHERE p.id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&p.ID,
+		&p.RoomID,
+		&p.FileName,
+		&p.ThumbnailName,
+		&p.Caption,
+		&p.SortOrder,
+		&p.CreatedAt,
+		&p.UpdatedAt)
+	if err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// InsertRoomPhoto inserts a photo after the existing photos of the room
+func (m *postgresDbRepo) InsertRoomPhoto(photo models.RoomPhoto) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `INSERT INTO room_photos (room_id, file_name, thumbnail_name, caption, sort_order, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, 
+			        (SELECT coalesce(max(sort_order), 0) + 1 FROM room_photos WHERE room_id = $1), 
+			        $5, $6) 
+			returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		photo.RoomID,
+		photo.FileName,
+		photo.ThumbnailName,
+		photo.Caption,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdateRoomPhoto updates the caption and the position of a photo
+func (m *postgresDbRepo) UpdateRoomPhoto(photo models.RoomPhoto) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE room_photos 
+			SET caption = $1, 
+			    sort_order = $2,
+			    updated_at = $3
+			WHERE id = $4`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		photo.Caption,
+		photo.SortOrder,
+		time.Now(),
+		photo.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *postgresDbRepo) DeleteRoomPhoto(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `DELETE FROM room_photos WHERE id = $1`
+
+	_, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (m *postgresDbRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var roomRestrictions []models.RoomRestriction
+
+	query := `SELECT rr.id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.room_id, rr.start_date, rr.end_date, 
+			rr.expires_at
+			FROM room_restrictions rr 
+			WHERE rr.end_date > $1 
+			AND rr.start_date <= $2 
+			AND rr.room_id = $3
+			AND rr.restriction_id IN ` + blockingRestrictions
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rr models.RoomRestriction
+		var expiresAt sql.NullTime
+		err := rows.Scan(
+			&rr.ID,
+			&rr.ReservationID,
+			&rr.RestrictionID,
+			&rr.RoomID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		rr.ExpiresAt = expiresAt.Time
+		roomRestrictions = append(roomRestrictions, rr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roomRestrictions, nil
+}
+
+func (m *postgresDbRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO room_restrictions(start_date, end_date, room_id, restriction_id, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		startDate,
+		startDate.AddDate(0, 0, 1),
+		id,
+		2,
+		time.Now(),
+		time.Now())
+	if err != nil {
+		return mapRestrictionError(err)
+	}
+	return nil
+}
+
 func (m *postgresDbRepo) DeleteBlockRoomRestrictionByID(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -505,3 +1544,1370 @@ func (m *postgresDbRepo) DeleteBlockRoomRestrictionByID(id int) error {
 	}
 	return nil
 }
+
+func (m *postgresDbRepo) AllRatePlans() ([]models.RatePlan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var plans []models.RatePlan
+
+	query := `SELECT ` + ratePlanColumns + `
+			FROM rate_plans p 
+			ORDER BY p.name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return plans, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.RatePlan
+		err := scanRatePlan(rows, &p)
+		if err != nil {
+			return plans, err
+		}
+		plans = append(plans, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return plans, err
+	}
+	return plans, nil
+}
+
+// GetRatePlanByID returns a rate plan together with its room prices and seasons
+func (m *postgresDbRepo) GetRatePlanByID(id int) (models.RatePlan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p models.RatePlan
+
+	query := `SELECT ` + ratePlanColumns + `
+			FROM rate_plans p 
+			WHERE p.id = $1`
+
+	err := scanRatePlan(m.DB.QueryRowContext(ctx, query, id), &p)
+	if err != nil {
+		return p, err
+	}
+
+	err = m.loadRatePlanDetails(ctx, &p)
+	if err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// GetRatePlanForRoom returns the active rate plan pricing the room, the oldest plan wins when several do.
+// A rate plan with a zero ID is returned when no plan prices the room
+func (m *postgresDbRepo) GetRatePlanForRoom(roomID int) (models.RatePlan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p models.RatePlan
+
+	query := `SELECT ` + ratePlanColumns + `
+			FROM rate_plans p 
+			WHERE p.active = true 
+			  AND EXISTS (SELECT 1 FROM rate_plan_rooms rpr WHERE rpr.rate_plan_id = p.id AND rpr.room_id = $1)
+			ORDER BY p.id
+			LIMIT 1`
+
+	err := scanRatePlan(m.DB.QueryRowContext(ctx, query, roomID), &p)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.RatePlan{}, nil
+	} else if err != nil {
+		return p, err
+	}
+
+	err = m.loadRatePlanDetails(ctx, &p)
+	if err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// loadRatePlanDetails reads the room prices and the seasons of a rate plan
+func (m *postgresDbRepo) loadRatePlanDetails(ctx context.Context, p *models.RatePlan) error {
+	p.RoomPrices = make(map[int]int)
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT room_id, nightly_price FROM rate_plan_rooms WHERE rate_plan_id = $1`, p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roomID, price int
+		err := rows.Scan(&roomID, &price)
+		if err != nil {
+			return err
+		}
+		p.RoomPrices[roomID] = price
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	query := `SELECT s.id, s.rate_plan_id, s.room_id, s.name, s.start_date, s.end_date, s.nightly_price, 
+			s.created_at, s.updated_at, r.room_name
+			FROM rate_seasons s 
+			LEFT JOIN rooms r ON (s.room_id = r.id)
+			WHERE s.rate_plan_id = $1
+			ORDER BY s.start_date, r.room_name`
+
+	seasonRows, err := m.DB.QueryContext(ctx, query, p.ID)
+	if err != nil {
+		return err
+	}
+	defer seasonRows.Close()
+
+	for seasonRows.Next() {
+		var s models.RateSeason
+		err := seasonRows.Scan(
+			&s.ID,
+			&s.RatePlanID,
+			&s.RoomID,
+			&s.Name,
+			&s.StartDate,
+			&s.EndDate,
+			&s.NightlyPrice,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+			&s.Room.RoomName)
+		if err != nil {
+			return err
+		}
+		s.Room.ID = s.RoomID
+		p.Seasons = append(p.Seasons, s)
+	}
+	return seasonRows.Err()
+}
+
+// InsertRatePlan inserts a rate plan together with its room prices
+func (m *postgresDbRepo) InsertRatePlan(p models.RatePlan) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+
+	stmt := `INSERT INTO rate_plans (name, active, sunday_percent, monday_percent, tuesday_percent, 
+            wednesday_percent, thursday_percent, friday_percent, saturday_percent, created_at, updated_at) 
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		p.Name,
+		p.Active,
+		p.WeekdayPercents[time.Sunday],
+		p.WeekdayPercents[time.Monday],
+		p.WeekdayPercents[time.Tuesday],
+		p.WeekdayPercents[time.Wednesday],
+		p.WeekdayPercents[time.Thursday],
+		p.WeekdayPercents[time.Friday],
+		p.WeekdayPercents[time.Saturday],
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertRatePlanRooms(ctx, tx, newID, p.RoomPrices)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdateRatePlan updates a rate plan and replaces its room prices
+func (m *postgresDbRepo) UpdateRatePlan(p models.RatePlan) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE rate_plans 
+			SET name = $1, 
+			    active = $2,
+			    sunday_percent = $3,
+			    monday_percent = $4,
+			    tuesday_percent = $5,
+			    wednesday_percent = $6,
+			    thursday_percent = $7,
+			    friday_percent = $8,
+			    saturday_percent = $9,
+			    updated_at = $10
+			WHERE id = $11`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		p.Name,
+		p.Active,
+		p.WeekdayPercents[time.Sunday],
+		p.WeekdayPercents[time.Monday],
+		p.WeekdayPercents[time.Tuesday],
+		p.WeekdayPercents[time.Wednesday],
+		p.WeekdayPercents[time.Thursday],
+		p.WeekdayPercents[time.Friday],
+		p.WeekdayPercents[time.Saturday],
+		time.Now(),
+		p.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM rate_plan_rooms WHERE rate_plan_id = $1`, p.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertRatePlanRooms(ctx, tx, p.ID, p.RoomPrices)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertRatePlanRooms(ctx context.Context, tx *sql.Tx, ratePlanID int, prices map[int]int) error {
+	stmt := `INSERT INTO rate_plan_rooms (rate_plan_id, room_id, nightly_price, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5)`
+
+	for roomID, price := range prices {
+		_, err := tx.ExecContext(ctx, stmt, ratePlanID, roomID, price, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *postgresDbRepo) InsertRateSeason(s models.RateSeason) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `INSERT INTO rate_seasons (rate_plan_id, room_id, name, start_date, end_date, nightly_price, 
+            created_at, updated_at) 
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		s.RatePlanID,
+		s.RoomID,
+		s.Name,
+		s.StartDate,
+		s.EndDate,
+		s.NightlyPrice,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+func (m *postgresDbRepo) DeleteRateSeason(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM rate_seasons WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AllStayRules returns the stay rules which have not ended yet
+func (m *postgresDbRepo) AllStayRules() ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rules []models.RoomRestriction
+
+	query := `SELECT rr.id, rr.room_id, rr.restriction_id, rr.start_date, rr.end_date, rr.nights, 
+			rm.room_name, r.restriction_name
+			FROM room_restrictions rr 
+			LEFT JOIN rooms rm ON (rr.room_id = rm.id)
+			LEFT JOIN restrictions r ON (rr.restriction_id = r.id)
+			WHERE rr.restriction_id IN ` + stayRuleRestrictions + `
+			  AND rr.end_date > $1
+			ORDER BY rr.start_date, rm.room_name`
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err := rows.Scan(
+			&rr.ID,
+			&rr.RoomID,
+			&rr.RestrictionID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&rr.Nights,
+			&rr.Room.RoomName,
+			&rr.Restriction.RestrictionName)
+		if err != nil {
+			return rules, err
+		}
+		rr.Room.ID = rr.RoomID
+		rr.Restriction.ID = rr.RestrictionID
+		rules = append(rules, rr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+func (m *postgresDbRepo) InsertStayRule(r models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO room_restrictions(start_date, end_date, room_id, restriction_id, nights, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		r.StartDate,
+		r.EndDate,
+		r.RoomID,
+		r.RestrictionID,
+		r.Nights,
+		time.Now(),
+		time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *postgresDbRepo) DeleteStayRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `DELETE FROM room_restrictions WHERE id = $1 AND restriction_id IN ` + stayRuleRestrictions
+
+	_, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateReservationDates moves a reservation and its room restriction to other dates or another room,
+// after checking in the same transaction that nothing but the reservation itself occupies the room
+func (m *postgresDbRepo) UpdateReservationDates(res models.Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the room row, so concurrent bookings for the same room are serialized
+	var roomID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query := `SELECT count(id) 
+			FROM room_restrictions 
+			WHERE room_id = $1 
+			  AND end_date > $2 
+			  AND start_date < $3
+			  AND coalesce(reservation_id, 0) <> $4
+			  AND restriction_id IN ` + blockingRestrictions
+
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate, res.ID).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomUnavailable
+	}
+
+	stmt := `UPDATE reservations 
+			SET start_date = $1, 
+			    end_date = $2,
+			    room_id = $3,
+			    total_price = $4,
+			    discount = $5,
+			    updated_at = $6
+			WHERE id = $7`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		res.TotalPrice,
+		res.Discount,
+		time.Now(),
+		res.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `UPDATE room_restrictions 
+			SET start_date = $1, 
+			    end_date = $2,
+			    room_id = $3,
+			    updated_at = $4
+			WHERE reservation_id = $5`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		time.Now(),
+		res.ID)
+	if err != nil {
+		return mapRestrictionError(err)
+	}
+
+	// the stay was priced again, so are its taxes
+	_, err = tx.ExecContext(ctx, `DELETE FROM reservation_taxes WHERE reservation_id = $1`, res.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertReservationTaxes(ctx, tx, res.ID, res.Taxes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertPayment records an operation on the payment gateway for a reservation
+func (m *postgresDbRepo) InsertPayment(p models.Payment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO payments (reservation_id, kind, amount, reference, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		p.ReservationID,
+		p.Kind,
+		p.Amount,
+		p.Reference,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetPaymentsForReservation returns the payment history of a reservation, oldest first
+func (m *postgresDbRepo) GetPaymentsForReservation(id int) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var history []models.Payment
+
+	query := `SELECT id, reservation_id, kind, amount, reference, created_at, updated_at
+			FROM payments 
+			WHERE reservation_id = $1
+			ORDER BY created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err := rows.Scan(
+			&p.ID,
+			&p.ReservationID,
+			&p.Kind,
+			&p.Amount,
+			&p.Reference,
+			&p.CreatedAt,
+			&p.UpdatedAt)
+		if err != nil {
+			return history, err
+		}
+		history = append(history, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return history, err
+	}
+	return history, nil
+}
+
+// AllCancellationPolicies returns the cancellation policies with their tiers, the tiers longest before check-in first
+func (m *postgresDbRepo) AllCancellationPolicies() ([]models.CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var policies []models.CancellationPolicy
+
+	query := `SELECT p.id, p.name, p.created_at, p.updated_at, 
+			coalesce(t.id, 0), coalesce(t.hours_before, 0), coalesce(t.fee_percent, 0)
+			FROM cancellation_policies p 
+			LEFT JOIN cancellation_policy_tiers t on (t.cancellation_policy_id = p.id)
+			ORDER BY p.name, p.id, t.hours_before DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return policies, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.CancellationPolicy
+		var t models.CancellationTier
+		err := rows.Scan(
+			&p.ID,
+			&p.Name,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+			&t.ID,
+			&t.HoursBefore,
+			&t.FeePercent)
+		if err != nil {
+			return policies, err
+		}
+
+		if len(policies) == 0 || policies[len(policies)-1].ID != p.ID {
+			policies = append(policies, p)
+		}
+		if t.ID != 0 {
+			t.PolicyID = p.ID
+			last := &policies[len(policies)-1]
+			last.Tiers = append(last.Tiers, t)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return policies, err
+	}
+	return policies, nil
+}
+
+// GetCancellationPolicyForRoom returns the cancellation policy of a room with its tiers,
+// a room without a policy gets a policy without tiers, so cancellations are free
+func (m *postgresDbRepo) GetCancellationPolicyForRoom(roomID int) (models.CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var policy models.CancellationPolicy
+
+	query := `SELECT p.id, p.name, p.created_at, p.updated_at
+			FROM cancellation_policies p 
+			JOIN rooms r on (r.cancellation_policy_id = p.id)
+			WHERE r.id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, roomID).Scan(
+		&policy.ID,
+		&policy.Name,
+		&policy.CreatedAt,
+		&policy.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, err
+	}
+
+	query = `SELECT id, cancellation_policy_id, hours_before, fee_percent, created_at, updated_at
+			FROM cancellation_policy_tiers 
+			WHERE cancellation_policy_id = $1
+			ORDER BY hours_before DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, policy.ID)
+	if err != nil {
+		return policy, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.CancellationTier
+		err := rows.Scan(
+			&t.ID,
+			&t.PolicyID,
+			&t.HoursBefore,
+			&t.FeePercent,
+			&t.CreatedAt,
+			&t.UpdatedAt)
+		if err != nil {
+			return policy, err
+		}
+		policy.Tiers = append(policy.Tiers, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
+
+// InsertCancellationPolicy inserts a cancellation policy without tiers and returns its id
+func (m *postgresDbRepo) InsertCancellationPolicy(p models.CancellationPolicy) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `INSERT INTO cancellation_policies (name, created_at, updated_at) 
+			VALUES ($1, $2, $3) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, p.Name, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// InsertCancellationTier adds a tier to a cancellation policy
+func (m *postgresDbRepo) InsertCancellationTier(t models.CancellationTier) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO cancellation_policy_tiers (cancellation_policy_id, hours_before, fee_percent, 
+            created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		t.PolicyID,
+		t.HoursBefore,
+		t.FeePercent,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteCancellationTier removes a tier from its cancellation policy
+func (m *postgresDbRepo) DeleteCancellationTier(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM cancellation_policy_tiers WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetInvoiceForReservation returns the invoice issued for a reservation with its lines
+func (m *postgresDbRepo) GetInvoiceForReservation(id int) (models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var inv models.Invoice
+
+	query := `SELECT id, reservation_id, number, confirmation_code, bill_to, email, room_name, start_date, end_date,
+       		total, paid, issued_at, created_at, updated_at
+			FROM invoices 
+			WHERE reservation_id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&inv.ID,
+		&inv.ReservationID,
+		&inv.Number,
+		&inv.ConfirmationCode,
+		&inv.BillTo,
+		&inv.Email,
+		&inv.RoomName,
+		&inv.StartDate,
+		&inv.EndDate,
+		&inv.Total,
+		&inv.Paid,
+		&inv.IssuedAt,
+		&inv.CreatedAt,
+		&inv.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return inv, repository.ErrNotFound
+	}
+	if err != nil {
+		return inv, err
+	}
+
+	query = `SELECT id, invoice_id, kind, description, quantity, unit_price, amount, created_at, updated_at
+			FROM invoice_lines 
+			WHERE invoice_id = $1
+			ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, inv.ID)
+	if err != nil {
+		return inv, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line models.InvoiceLine
+		err := rows.Scan(
+			&line.ID,
+			&line.InvoiceID,
+			&line.Kind,
+			&line.Description,
+			&line.Quantity,
+			&line.UnitPrice,
+			&line.Amount,
+			&line.CreatedAt,
+			&line.UpdatedAt)
+		if err != nil {
+			return inv, err
+		}
+		inv.Lines = append(inv.Lines, line)
+	}
+
+	if err = rows.Err(); err != nil {
+		return inv, err
+	}
+	return inv, nil
+}
+
+// InsertInvoice stores an invoice with the next invoice number, and returns it as issued.
+// The number is taken from a counter row locked until commit, so numbers are sequential without gaps.
+// If an invoice was issued for the reservation in the meantime, that one is returned instead
+func (m *postgresDbRepo) InsertInvoice(inv models.Invoice) (models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return inv, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`UPDATE invoice_numbers SET last_number = last_number + 1, updated_at = $1 RETURNING last_number`,
+		time.Now()).Scan(&inv.Number)
+	if err != nil {
+		return inv, err
+	}
+
+	inv.IssuedAt = time.Now()
+
+	stmt := `INSERT INTO invoices (reservation_id, number, confirmation_code, bill_to, email, room_name, 
+            start_date, end_date, total, paid, issued_at, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			ON CONFLICT (reservation_id) DO NOTHING
+			RETURNING id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		inv.ReservationID,
+		inv.Number,
+		inv.ConfirmationCode,
+		inv.BillTo,
+		inv.Email,
+		inv.RoomName,
+		inv.StartDate,
+		inv.EndDate,
+		inv.Total,
+		inv.Paid,
+		inv.IssuedAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&inv.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// releases the invoice number by rolling back
+		_ = tx.Rollback()
+		return m.GetInvoiceForReservation(inv.ReservationID)
+	}
+	if err != nil {
+		return inv, err
+	}
+
+	stmt = `INSERT INTO invoice_lines (invoice_id, kind, description, quantity, unit_price, amount, 
+            created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	for i, line := range inv.Lines {
+		_, err = tx.ExecContext(ctx, stmt,
+			inv.ID,
+			line.Kind,
+			line.Description,
+			line.Quantity,
+			line.UnitPrice,
+			line.Amount,
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			return inv, err
+		}
+		inv.Lines[i].InvoiceID = inv.ID
+	}
+
+	return inv, tx.Commit()
+}
+
+// AllTaxRules returns the tax rules, in the order they were added
+func (m *postgresDbRepo) AllTaxRules() ([]models.TaxRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rules []models.TaxRule
+
+	query := `SELECT id, name, kind, basis, amount, start_date, end_date, created_at, updated_at
+			FROM tax_rules 
+			ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.TaxRule
+		var startDate, endDate sql.NullTime
+		err := rows.Scan(
+			&r.ID,
+			&r.Name,
+			&r.Kind,
+			&r.Basis,
+			&r.Amount,
+			&startDate,
+			&endDate,
+			&r.CreatedAt,
+			&r.UpdatedAt)
+		if err != nil {
+			return rules, err
+		}
+		r.StartDate = startDate.Time
+		r.EndDate = endDate.Time
+		rules = append(rules, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+// InsertTaxRule adds a tax rule, zero dates are stored as open ended
+func (m *postgresDbRepo) InsertTaxRule(r models.TaxRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO tax_rules (name, kind, basis, amount, start_date, end_date, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		r.Name,
+		r.Kind,
+		r.Basis,
+		r.Amount,
+		sql.NullTime{Time: r.StartDate, Valid: !r.StartDate.IsZero()},
+		sql.NullTime{Time: r.EndDate, Valid: !r.EndDate.IsZero()},
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteTaxRule removes a tax rule, reservations keep the taxes they were charged
+func (m *postgresDbRepo) DeleteTaxRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM tax_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// promoCodesQuery selects the promo codes with their uses and one row per eligible room,
+// the conditions are appended to it, $1 is the cancelled status
+const promoCodesQuery = `SELECT p.id, p.code, p.kind, p.amount, p.valid_from, p.valid_until, p.max_uses, p.min_nights,
+		(SELECT count(DISTINCT coalesce(r.group_id, r.id)) FROM reservations r 
+			WHERE r.promo_code_id = p.id AND r.status <> $1),
+		p.created_at, p.updated_at, coalesce(pr.room_id, 0)
+		FROM promo_codes p 
+		LEFT JOIN promo_code_rooms pr on (pr.promo_code_id = p.id)`
+
+// queryPromoCodes returns the promo codes matched by the conditions, see promoCodesQuery
+func (m *postgresDbRepo) queryPromoCodes(conditions string, args ...interface{}) ([]models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var promos []models.PromoCode
+
+	args = append([]interface{}{string(models.StatusCancelled)}, args...)
+	rows, err := m.DB.QueryContext(ctx, promoCodesQuery+` `+conditions, args...)
+	if err != nil {
+		return promos, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.PromoCode
+		var validFrom, validUntil sql.NullTime
+		var roomID int
+		err := rows.Scan(
+			&p.ID,
+			&p.Code,
+			&p.Kind,
+			&p.Amount,
+			&validFrom,
+			&validUntil,
+			&p.MaxUses,
+			&p.MinNights,
+			&p.Uses,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+			&roomID)
+		if err != nil {
+			return promos, err
+		}
+		p.ValidFrom = validFrom.Time
+		p.ValidUntil = validUntil.Time
+
+		if len(promos) == 0 || promos[len(promos)-1].ID != p.ID {
+			promos = append(promos, p)
+		}
+		if roomID != 0 {
+			last := &promos[len(promos)-1]
+			last.RoomIDs = append(last.RoomIDs, roomID)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return promos, err
+	}
+	return promos, nil
+}
+
+// AllPromoCodes returns the promo codes with their eligible rooms and how often they were redeemed
+func (m *postgresDbRepo) AllPromoCodes() ([]models.PromoCode, error) {
+	return m.queryPromoCodes(`ORDER BY p.code, pr.room_id`)
+}
+
+// GetPromoCodeByID returns a promo code, or repository.ErrNotFound if there is none
+func (m *postgresDbRepo) GetPromoCodeByID(id int) (models.PromoCode, error) {
+	promos, err := m.queryPromoCodes(`WHERE p.id = $2 ORDER BY pr.room_id`, id)
+	if err != nil {
+		return models.PromoCode{}, err
+	}
+	if len(promos) == 0 {
+		return models.PromoCode{}, repository.ErrNotFound
+	}
+	return promos[0], nil
+}
+
+// GetPromoCodeByCode returns the promo code as entered by a guest, or repository.ErrNotFound if there is none
+func (m *postgresDbRepo) GetPromoCodeByCode(code string) (models.PromoCode, error) {
+	promos, err := m.queryPromoCodes(`WHERE p.code = $2 ORDER BY pr.room_id`, code)
+	if err != nil {
+		return models.PromoCode{}, err
+	}
+	if len(promos) == 0 {
+		return models.PromoCode{}, repository.ErrNotFound
+	}
+	return promos[0], nil
+}
+
+// InsertPromoCode adds a promo code with its eligible rooms, zero dates are stored as open ended.
+// It returns repository.ErrDuplicatePromoCode when the code already exists
+func (m *postgresDbRepo) InsertPromoCode(p models.PromoCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO promo_codes (code, kind, amount, valid_from, valid_until, max_uses, min_nights, 
+            created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) 
+			returning id`
+
+	var newID int
+	err = tx.QueryRowContext(ctx, stmt,
+		p.Code,
+		string(p.Kind),
+		p.Amount,
+		sql.NullTime{Time: p.ValidFrom, Valid: !p.ValidFrom.IsZero()},
+		sql.NullTime{Time: p.ValidUntil, Valid: !p.ValidUntil.IsZero()},
+		p.MaxUses,
+		p.MinNights,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if isUniqueViolation(err) {
+		return repository.ErrDuplicatePromoCode
+	} else if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO promo_code_rooms (promo_code_id, room_id, created_at, updated_at) 
+			VALUES ($1, $2, $3, $4)`
+
+	for _, roomID := range p.RoomIDs {
+		_, err = tx.ExecContext(ctx, stmt, newID, roomID, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeletePromoCode removes a promo code, reservations keep the discount they were given
+func (m *postgresDbRepo) DeletePromoCode(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM promo_codes WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *postgresDbRepo) queryWaitlist(query string, args ...interface{}) ([]models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var entries []models.WaitlistEntry
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.WaitlistEntry
+		err := scanWaitlistEntry(rows, &e)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+	return entries, nil
+}
+
+// AllWaitlistEntries returns the waitlist, the entries still waiting for a room first
+func (m *postgresDbRepo) AllWaitlistEntries() ([]models.WaitlistEntry, error) {
+	return m.queryWaitlist(`SELECT `+waitlistColumns+`
+			FROM waitlist w 
+			LEFT JOIN rooms rm on (w.room_id = rm.id) 
+			ORDER BY w.status = $1 DESC, w.start_date, w.created_at, w.id`, string(models.WaitlistWaiting))
+}
+
+// GetWaitlistEntryByID returns a waitlist entry, or repository.ErrNotFound if there is none
+func (m *postgresDbRepo) GetWaitlistEntryByID(id int) (models.WaitlistEntry, error) {
+	entries, err := m.queryWaitlist(`SELECT `+waitlistColumns+`
+			FROM waitlist w 
+			LEFT JOIN rooms rm on (w.room_id = rm.id) 
+			WHERE w.id = $1`, id)
+	if err != nil {
+		return models.WaitlistEntry{}, err
+	}
+	if len(entries) == 0 {
+		return models.WaitlistEntry{}, repository.ErrNotFound
+	}
+	return entries[0], nil
+}
+
+// InsertWaitlistEntry puts a guest on the waitlist for their dates and party, and returns the id of the entry
+func (m *postgresDbRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO waitlist (first_name, last_name, email, start_date, end_date, adults, children, status, 
+            created_at, updated_at) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) 
+			returning id`
+
+	var newID int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		e.FirstName,
+		e.LastName,
+		e.Email,
+		e.StartDate,
+		e.EndDate,
+		e.Adults,
+		e.Children,
+		string(models.WaitlistWaiting),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// WaitlistEntriesForRoom returns the entries still waiting for a stay which overlaps the dates and whose party
+// fits the room, as booking.Fits, in the order the guests joined the waitlist
+func (m *postgresDbRepo) WaitlistEntriesForRoom(roomID int, start, end time.Time) ([]models.WaitlistEntry, error) {
+	return m.queryWaitlist(`SELECT `+waitlistColumns+`
+			FROM waitlist w, rooms rm 
+			WHERE rm.id = $1 
+			  AND rm.active 
+			  AND w.status = $2 
+			  AND w.start_date < $3 AND w.end_date > $4 
+			  AND w.adults <= rm.max_adults 
+			  AND w.adults + w.children <= rm.max_adults + rm.max_children
+			ORDER BY w.created_at, w.id`, roomID, string(models.WaitlistWaiting), end, start)
+}
+
+// OfferWaitlistEntry records that the room of the entry was offered to the guest, held for them until the
+// claim expires. It returns repository.ErrWaitlistStatusChanged when the entry is no longer waiting
+func (m *postgresDbRepo) OfferWaitlistEntry(e models.WaitlistEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE waitlist 
+			SET status = $1, room_id = $2, hold_token = $3, notified_at = $4, claim_expires_at = $5, updated_at = $6
+			WHERE id = $7 AND status = $8`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		string(models.WaitlistNotified),
+		e.RoomID,
+		e.HoldToken,
+		e.NotifiedAt,
+		e.ClaimExpiresAt,
+		time.Now(),
+		e.ID,
+		string(models.WaitlistWaiting))
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return repository.ErrWaitlistStatusChanged
+	}
+	return nil
+}
+
+// ClaimWaitlistEntry records that the guest took up the offer of the entry, the offer can be taken up again until it
+// expires. It returns repository.ErrWaitlistStatusChanged when the entry wasn't offered or the offer expired by now
+func (m *postgresDbRepo) ClaimWaitlistEntry(id int, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE waitlist 
+			SET status = $1, updated_at = $2
+			WHERE id = $3 AND status IN ($4, $5) AND claim_expires_at > $6`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		string(models.WaitlistClaimed),
+		time.Now(),
+		id,
+		string(models.WaitlistNotified),
+		string(models.WaitlistClaimed),
+		now)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return repository.ErrWaitlistStatusChanged
+	}
+	return nil
+}
+
+// ExpireWaitlistOffers closes the offers which weren't claimed by now, and returns the expired entries
+// so their rooms can be offered to the next guests
+func (m *postgresDbRepo) ExpireWaitlistOffers(now time.Time) ([]models.WaitlistEntry, error) {
+	return m.queryWaitlist(`WITH w AS (
+				UPDATE waitlist 
+				SET status = $1, updated_at = $2 
+				WHERE status = $3 AND claim_expires_at <= $4 
+				RETURNING *
+			)
+			SELECT `+waitlistColumns+`
+			FROM w 
+			LEFT JOIN rooms rm on (w.room_id = rm.id) 
+			ORDER BY w.room_id, w.start_date`,
+		string(models.WaitlistExpired), time.Now(), string(models.WaitlistNotified), now)
+}
+
+// guestsQuery selects the guests with their stays, the reservations they checked in for, and the nights of them.
+// The first argument is the status of checked in reservations, the second the one of checked out reservations
+const guestsQuery = `SELECT g.id, g.first_name, g.last_name, g.email, g.phone, g.created_at, g.updated_at, 
+		count(r.id), coalesce(sum(r.end_date - r.start_date), 0)
+		FROM guests g 
+		LEFT JOIN reservations r on (r.guest_id = g.id AND r.status IN ($1, $2))`
+
+// queryGuests returns the guests matched by the conditions, see guestsQuery
+func (m *postgresDbRepo) queryGuests(conditions string, args ...interface{}) ([]models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var guests []models.Guest
+
+	args = append([]interface{}{string(models.StatusCheckedIn), string(models.StatusCheckedOut)}, args...)
+	rows, err := m.DB.QueryContext(ctx, guestsQuery+` `+conditions, args...)
+	if err != nil {
+		return guests, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g models.Guest
+		err := rows.Scan(
+			&g.ID,
+			&g.FirstName,
+			&g.LastName,
+			&g.Email,
+			&g.Phone,
+			&g.CreatedAt,
+			&g.UpdatedAt,
+			&g.Stays,
+			&g.LifetimeNights)
+		if err != nil {
+			return guests, err
+		}
+		guests = append(guests, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return guests, err
+	}
+	return guests, nil
+}
+
+// AllGuests returns the guest profiles by name
+func (m *postgresDbRepo) AllGuests() ([]models.Guest, error) {
+	return m.queryGuests(`GROUP BY g.id ORDER BY lower(g.last_name), lower(g.first_name), g.id`)
+}
+
+// GetGuestByID returns a guest profile, or repository.ErrNotFound if there is none
+func (m *postgresDbRepo) GetGuestByID(id int) (models.Guest, error) {
+	guests, err := m.queryGuests(`WHERE g.id = $3 GROUP BY g.id`, id)
+	if err != nil {
+		return models.Guest{}, err
+	}
+	if len(guests) == 0 {
+		return models.Guest{}, repository.ErrNotFound
+	}
+	return guests[0], nil
+}
+
+// GetReservationsForGuest returns the stay history of a guest, the latest stay first
+func (m *postgresDbRepo) GetReservationsForGuest(guestID int) ([]models.Reservation, error) {
+	return m.queryReservations(`SELECT `+reservationColumns+`
+			FROM reservations r 
+			LEFT JOIN rooms rm on (r.room_id = rm.id) 
+			WHERE r.guest_id = $1
+			ORDER BY r.start_date DESC, r.id DESC`, guestID)
+}
+
+// GetPossibleDuplicateGuests returns the other guest profiles which might be the same guest,
+// those with the same name, email address or phone number
+func (m *postgresDbRepo) GetPossibleDuplicateGuests(g models.Guest) ([]models.Guest, error) {
+	return m.queryGuests(`WHERE g.id <> $3 
+			AND ((lower(g.first_name) = lower($4) AND lower(g.last_name) = lower($5)) 
+				OR (g.email_normalized = $6 AND $6 <> '') 
+				OR (g.phone_normalized = $7 AND $7 <> '')) 
+			GROUP BY g.id ORDER BY g.id`,
+		g.ID, g.FirstName, g.LastName, booking.NormalizeEmail(g.Email), booking.NormalizePhone(g.Phone))
+}
+
+// MergeGuests merges a duplicate guest profile into the one which is kept: its reservations are moved over,
+// the contact details the kept profile is missing are taken from it, then it is removed.
+// It returns repository.ErrNotFound when either profile doesn't exist
+func (m *postgresDbRepo) MergeGuests(keepID, mergeID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock both profiles in id order, so concurrent merges of the same profiles are serialized without deadlocks
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM guests WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`, keepID, mergeID)
+	if err != nil {
+		return err
+	}
+	locked := 0
+	for rows.Next() {
+		locked++
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if locked != 2 {
+		return repository.ErrNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE reservations SET guest_id = $1, updated_at = $2 WHERE guest_id = $3`,
+		keepID, time.Now(), mergeID)
+	if err != nil {
+		return err
+	}
+
+	// the merged profile is deleted before its contact details are copied, as its email address is unique
+	var email, emailNormalized, phone, phoneNormalized string
+	err = tx.QueryRowContext(ctx, `DELETE FROM guests WHERE id = $1 
+			returning email, email_normalized, phone, phone_normalized`, mergeID).
+		Scan(&email, &emailNormalized, &phone, &phoneNormalized)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE guests 
+			SET email = CASE WHEN email_normalized = '' THEN $1 ELSE email END, 
+			    email_normalized = CASE WHEN email_normalized = '' THEN $2 ELSE email_normalized END, 
+			    phone = CASE WHEN phone_normalized = '' THEN $3 ELSE phone END, 
+			    phone_normalized = CASE WHEN phone_normalized = '' THEN $4 ELSE phone_normalized END, 
+			    updated_at = $5 
+			WHERE id = $6`
+
+	_, err = tx.ExecContext(ctx, stmt, email, emailNormalized, phone, phoneNormalized, time.Now(), keepID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
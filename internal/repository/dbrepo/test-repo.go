@@ -2,8 +2,11 @@ package dbrepo
 
 import (
 	"errors"
+	"github.com/loidinhm31/go-bookings-system/internal/booking"
 	"github.com/loidinhm31/go-bookings-system/internal/constants"
 	"github.com/loidinhm31/go-bookings-system/internal/models"
+	"github.com/loidinhm31/go-bookings-system/internal/repository"
+	"github.com/loidinhm31/go-bookings-system/internal/tokens"
 	"log"
 	"time"
 )
@@ -12,12 +15,69 @@ func (m *testDBRepo) AllUsers() bool {
 	return true
 }
 
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
+// testConfirmationCode is the confirmation code every reservation inserted in tests gets
+const testConfirmationCode = "7K3M9QXA"
+
+func (m *testDBRepo) InsertReservation(res models.Reservation) (int, string, error) {
 	// if the room id is 2, then fail; otherwise, pass
 	if res.RoomID == 2 {
+		return 0, "", errors.New("some error")
+	}
+	return 1, testConfirmationCode, nil
+}
+
+func (m *testDBRepo) PlaceHold(hold models.RoomRestriction) error {
+	// if the room id is 500, then the room was just taken by someone else;
+	// if the room id is 1000, then fail placing the hold
+	if hold.RoomID == 500 {
+		return repository.ErrRoomUnavailable
+	}
+	if hold.RoomID == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) ReleaseHold(token string) error {
+	// the hold "broken" fails to be released
+	if token == "broken" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteExpiredHolds(now time.Time) (int, error) {
+	// after 2060 the query fails, otherwise two holds were expired
+	if now.Year() >= 2060 {
 		return 0, errors.New("some error")
 	}
-	return 1, nil
+	return 2, nil
+}
+
+// CreateReservations fails like CreateReservation when any of the stays does,
+// the reservations get the ids 1, 21, 22, ... and are grouped under the first one
+func (m *testDBRepo) CreateReservations(stays []models.Reservation) ([]models.Reservation, error) {
+	var created []models.Reservation
+	for i, res := range stays {
+		if violation := testClosedToArrival(res.StartDate, res.RoomID); violation != nil {
+			violation.RoomName = res.Room.RoomName
+			return nil, &repository.StayRuleError{Violation: *violation}
+		}
+		_, code, err := m.CreateReservation(res)
+		if err != nil {
+			return nil, err
+		}
+		res.ID = 1
+		if i > 0 {
+			res.ID = 20 + i
+		}
+		res.ConfirmationCode = code
+		if len(stays) > 1 {
+			res.GroupID = 1
+		}
+		created = append(created, res)
+	}
+	return created, nil
 }
 
 func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
@@ -27,8 +87,25 @@ func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+func (m *testDBRepo) CreateReservation(res models.Reservation) (int, string, error) {
+	// if the room id is 2, then fail inserting the reservation;
+	// if the room id is 1000, then fail inserting the room restriction
+	if res.RoomID == 2 || res.RoomID == 1000 {
+		return 0, "", errors.New("some error")
+	}
+	// if the room id is 500, then the room was just booked by someone else
+	if res.RoomID == 500 {
+		return 0, "", repository.ErrRoomUnavailable
+	}
+	// if the promo code id is 3, then the code was just used up by someone else
+	if res.PromoCodeID == 3 {
+		return 0, "", repository.ErrPromoCodeUsedUp
+	}
+	return 1, testConfirmationCode, nil
+}
+
 // SearchAvailabilityByRoomIDAndDates returns true if availability exists for roomID, and false if no availability
-func (m *testDBRepo) SearchAvailabilityByRoomIDAndDates(start, end time.Time, roomID int) (bool, error) {
+func (m *testDBRepo) SearchAvailabilityByRoomIDAndDates(start, end time.Time, roomID int) (bool, *models.RuleViolation, error) {
 	// set up a test time
 	str := "2049-12-31"
 	t, err := time.Parse(constants.Layout, str)
@@ -43,22 +120,70 @@ func (m *testDBRepo) SearchAvailabilityByRoomIDAndDates(start, end time.Time, ro
 	}
 
 	if start == testDateToFail {
-		return false, errors.New("some error")
+		return false, nil, errors.New("some error")
 	}
 
 	// if the start date is after 2049-12-31, then return false,
 	// indicating no availability;
 	if start.After(t) {
-		return false, nil
+		return false, nil, nil
+	}
+
+	// if the start date is 2049-12-24, then the room is closed to arrival
+	if violation := testClosedToArrival(start, roomID); violation != nil {
+		return false, violation, nil
 	}
 
 	// otherwise, we have availability
-	return true, nil
+	return true, nil, nil
+}
+
+func (m *testDBRepo) SearchAvailabilityByRoomIDAndDatesExcluding(start, end time.Time, roomID, reservationID int) (bool, *models.RuleViolation, error) {
+	return m.SearchAvailabilityByRoomIDAndDates(start, end, roomID)
+}
+
+// testClosedToArrival rejects arrivals on 2049-12-24
+func testClosedToArrival(start time.Time, roomID int) *models.RuleViolation {
+	if start.Format(constants.Layout) != "2049-12-24" {
+		return nil
+	}
+	return &models.RuleViolation{
+		RoomID:        roomID,
+		RestrictionID: models.RestrictionClosedToArrival,
+		Reason:        "Arrivals are not possible on 2049-12-24",
+	}
+}
+
+func (m *testDBRepo) SearchFreeGaps(start, end time.Time, nights, adults, children int) ([]models.FreeGap, error) {
+	var gaps []models.FreeGap
+
+	// if the start date is in 2060 or later, then fail the query
+	if start.Year() >= 2060 {
+		return gaps, errors.New("some error")
+	}
+	// no room fits more than 4 adults
+	if adults > 4 {
+		return gaps, nil
+	}
+
+	// room 1 is free all the time, room 2 is taken for the first two nights
+	gaps = append(gaps, models.FreeGap{Room: models.Room{ID: 1, RoomName: "General's Quarters"}, StartDate: start, EndDate: end})
+	if end.Sub(start.AddDate(0, 0, 2)).Hours()/24 >= float64(nights) {
+		gaps = append(gaps, models.FreeGap{Room: models.Room{ID: 2, RoomName: "Major's Suite"}, StartDate: start.AddDate(0, 0, 2), EndDate: end})
+	}
+	return gaps, nil
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+// which fit a party of the given size
+func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, []models.RuleViolation, error) {
 	var rooms []models.Room
+	var violations []models.RuleViolation
+
+	// no room fits more than 4 adults
+	if adults > 4 {
+		return rooms, violations, nil
+	}
 
 	// if the start date is after 2049-12-31, then return empty slice,
 	// indicating no rooms are available;
@@ -74,11 +199,17 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 	}
 
 	if start == testDateToFail {
-		return rooms, errors.New("some error")
+		return rooms, violations, errors.New("some error")
 	}
 
 	if start.After(t) {
-		return rooms, nil
+		return rooms, violations, nil
+	}
+
+	if violation := testClosedToArrival(start, 1); violation != nil {
+		violation.RoomName = "General's Quarters"
+		violations = append(violations, *violation)
+		return rooms, violations, nil
 	}
 
 	// otherwise, put an entry into the slice, indicating that some room is
@@ -88,34 +219,153 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 	}
 	rooms = append(rooms, room)
 
-	return rooms, nil
+	return rooms, violations, nil
 }
 
 func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 	var room models.Room
-	if id > 2 {
+	// room 3 doesn't exist, the query for room 4 fails and room 5 is retired
+	if id == 3 {
+		return room, repository.ErrNotFound
+	}
+	if id == 4 {
+		return room, errors.New("some error")
+	}
+	room.Active = id != 5
+	room.MaxAdults = 2
+	room.MaxChildren = 2
+	return room, nil
+}
+
+func (m *testDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	var room models.Room
+	if slug == "non-existent" {
 		return room, errors.New("some error")
 	}
+	room.ID = 1
+	room.Slug = slug
+	room.Active = true
 	return room, nil
 }
 
 func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
-	var u models.User
-	return u, nil
+	for _, u := range testUsers {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return models.User{}, errors.New("some error")
 }
 
 func (m *testDBRepo) UpdateUser(u models.User) error {
 	return nil
 }
 
+// testUsers are the users known to the test repository, user 1 is staff, the others are guests with an account
+var testUsers = []models.User{
+	{ID: 1, FirstName: "Admin", Email: "me@here.com", AccessLevel: int(models.RoleOwner), EmailVerifiedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{ID: 2, FirstName: "John", LastName: "Smith", Email: "guest@here.com", AccessLevel: int(models.RoleGuest),
+		EmailVerifiedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+	// user 3 hasn't verified the email address yet
+	{ID: 3, FirstName: "Jane", LastName: "Doe", Email: "unverified@here.com", AccessLevel: int(models.RoleGuest)},
+	// the bookings of user 5 fail to load
+	{ID: 5, FirstName: "Bob", LastName: "Broken", Email: "broken@here.com", AccessLevel: int(models.RoleGuest),
+		EmailVerifiedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+}
+
 func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	if email == "me@here.com" {
-		return 1, "", nil
+	// the password doesn't matter, but user 4 doesn't exist
+	if email == "missing@here.com" {
+		return 4, "", nil
+	}
+	for _, u := range testUsers {
+		if u.Email == booking.NormalizeEmail(email) {
+			return u.ID, "", nil
+		}
 	}
 	return 0, "", errors.New("some error")
 }
 
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
+func (m *testDBRepo) InsertUser(u models.User) (int, error) {
+	// if the email is "fail@here.com", then fail inserting the user
+	if u.Email == "fail@here.com" {
+		return 0, errors.New("some error")
+	}
+	for _, other := range testUsers {
+		if other.Email == booking.NormalizeEmail(u.Email) {
+			return 0, repository.ErrDuplicateEmail
+		}
+	}
+	return 6, nil
+}
+
+func (m *testDBRepo) VerifyUserEmail(id int) error {
+	// if the id is 99, then fail the update
+	if id == 99 {
+		return errors.New("some error")
+	}
+	for _, u := range testUsers {
+		if u.ID == id {
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (m *testDBRepo) GetReservationsForAccount(email string) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	if email == "broken@here.com" {
+		return reservations, errors.New("some error")
+	}
+	if email == "guest@here.com" {
+		reservations = append(reservations,
+			models.Reservation{ID: 9, RoomID: 1, Status: models.StatusConfirmed, ConfirmationCode: "UPCOMING",
+				StartDate: time.Date(2050, 6, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 6, 4, 0, 0, 0, 0, time.UTC),
+				Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+			models.Reservation{ID: 3, RoomID: 1, Status: models.StatusCheckedOut, ConfirmationCode: "PASTSTAY",
+				StartDate: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2020, 6, 4, 0, 0, 0, 0, time.UTC),
+				Room: models.Room{ID: 1, RoomName: "General's Quarters"}})
+	}
+	return reservations, nil
+}
+
+func (m *testDBRepo) GetUserByEmail(email string) (models.User, error) {
+	// if the email is "fail@here.com", then fail the lookup
+	if email == "fail@here.com" {
+		return models.User{}, errors.New("some error")
+	}
+	for _, u := range testUsers {
+		if u.Email == booking.NormalizeEmail(email) {
+			return u, nil
+		}
+	}
+	return models.User{}, repository.ErrNotFound
+}
+
+func (m *testDBRepo) InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+	// if the user is 5, then fail storing the token
+	if userID == 5 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// the password reset token "valid-reset" was sent to user 1, and "fail-reset" fails the lookup
+func (m *testDBRepo) GetPasswordResetUserID(tokenHash string, now time.Time) (int, error) {
+	switch tokenHash {
+	case tokens.Hash("valid-reset"):
+		return 1, nil
+	case tokens.Hash("fail-reset"):
+		return 0, errors.New("some error")
+	}
+	return 0, repository.ErrNotFound
+}
+
+func (m *testDBRepo) ResetPassword(tokenHash, password string, now time.Time) (int, error) {
+	return m.GetPasswordResetUserID(tokenHash, now)
+}
+
+func (m *testDBRepo) AllReservations(status models.ReservationStatus) ([]models.Reservation, error) {
 	var reservations []models.Reservation
 	return reservations, nil
 }
@@ -128,7 +378,65 @@ func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
 
 func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
 	var res models.Reservation
+	res.ID = id
+	res.Status = models.StatusPending
 
+	// reservation 3 has already checked out
+	if id == 3 {
+		res.Status = models.StatusCheckedOut
+	}
+	// reservation 7 was cancelled
+	if id == 7 {
+		res.Status = models.StatusCancelled
+	}
+	// reservation 9 is confirmed
+	if id == 9 {
+		res.Status = models.StatusConfirmed
+	}
+	// reservation 1 was charged taxes
+	if id == 1 {
+		res.Taxes = []models.ReservationTax{{ReservationID: id, Name: "VAT", Amount: 1000}}
+	}
+	// reservation 11 redeemed a promo code which is only valid for room 2,
+	// reservation 12 redeemed one which is valid for every room
+	if id == 11 {
+		res.PromoCodeID, res.PromoCode, res.Discount = 2, "ROOM2", 2000
+	}
+	if id == 12 {
+		res.PromoCodeID, res.PromoCode, res.Discount = 1, "SUMMER10", 3450
+	}
+	// reservation 13 was booked together with reservation 14
+	if id == 13 {
+		res.GroupID = 13
+	}
+	// reservation 3 is linked to guest 1
+	if id == 3 {
+		res.GuestID = 1
+	}
+	return res, nil
+}
+
+func (m *testDBRepo) GetReservationsInGroup(groupID int) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	// group 99 fails
+	if groupID == 99 {
+		return reservations, errors.New("some error")
+	}
+	if groupID == 13 {
+		reservations = append(reservations,
+			models.Reservation{ID: 13, GroupID: 13, RoomID: 1, Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+			models.Reservation{ID: 14, GroupID: 13, RoomID: 3, Room: models.Room{ID: 3, RoomName: "Major's Suite"}})
+	}
+	return reservations, nil
+}
+
+func (m *testDBRepo) GetReservationByCode(code string) (models.Reservation, error) {
+	var res models.Reservation
+	if code != testConfirmationCode {
+		return res, repository.ErrNotFound
+	}
+	res.ID = 1
+	res.ConfirmationCode = code
 	return res, nil
 }
 
@@ -140,24 +448,580 @@ func (m *testDBRepo) DeleteReservation(id int) error {
 	return nil
 }
 
-func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
+func (m *testDBRepo) UpdateReservationStatus(id int, from, to models.ReservationStatus) error {
+	// reservation 2 was changed by someone else in the meantime
+	if id == 2 {
+		return repository.ErrStatusChanged
+	}
+	// reservation 22, the third room of a booking, fails to be confirmed
+	if id == 22 && to == models.StatusConfirmed {
+		return errors.New("some error")
+	}
 	return nil
 }
 
+func (m *testDBRepo) GetStatusChangesForReservation(id int) ([]models.ReservationStatusChange, error) {
+	var changes []models.ReservationStatusChange
+	return changes, nil
+}
+
 func (m *testDBRepo) AllRooms() ([]models.Room, error) {
 	var rooms []models.Room
 	return rooms, nil
 }
 
+func (m *testDBRepo) AllRoomsWithRetired() ([]models.Room, error) {
+	var rooms []models.Room
+	return rooms, nil
+}
+
+func (m *testDBRepo) InsertRoom(room models.Room) (int, error) {
+	// if the slug is "taken", then another room already uses it
+	if room.Slug == "taken" {
+		return 0, repository.ErrDuplicateSlug
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) UpdateRoom(room models.Room) error {
+	if room.Slug == "taken" {
+		return repository.ErrDuplicateSlug
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteRoom(id int) error {
+	// if the id is 99, then fail the delete
+	if id == 99 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) GetPhotosForRoom(roomID int) ([]models.RoomPhoto, error) {
+	var photos []models.RoomPhoto
+	photos = append(photos, models.RoomPhoto{
+		ID:            1,
+		RoomID:        roomID,
+		FileName:      "rooms/1/photo.jpg",
+		ThumbnailName: "rooms/1/thumb_photo.jpg",
+		SortOrder:     1,
+	})
+	return photos, nil
+}
+
+func (m *testDBRepo) GetRoomPhotoByID(id int) (models.RoomPhoto, error) {
+	var photo models.RoomPhoto
+	if id > 2 {
+		return photo, errors.New("some error")
+	}
+	photo.ID = id
+	photo.RoomID = 1
+	photo.FileName = "rooms/1/photo.jpg"
+	photo.ThumbnailName = "rooms/1/thumb_photo.jpg"
+	return photo, nil
+}
+
+func (m *testDBRepo) InsertRoomPhoto(photo models.RoomPhoto) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) UpdateRoomPhoto(photo models.RoomPhoto) error {
+	return nil
+}
+
+func (m *testDBRepo) DeleteRoomPhoto(id int) error {
+	return nil
+}
+
 func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
 	var roomRestrictions []models.RoomRestriction
+	// room 1 is reserved for the nights of 2050-01-02 and 2050-01-03, blocked for the night of 2050-01-04
+	// and held for the night of 2050-01-06; for room 2 the query fails
+	if roomID == 1 {
+		roomRestrictions = append(roomRestrictions,
+			models.RoomRestriction{RoomID: 1, RestrictionID: models.RestrictionReservation, ReservationID: 1,
+				StartDate: time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC)},
+			models.RoomRestriction{RoomID: 1, RestrictionID: models.RestrictionOwnerBlock,
+				StartDate: time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC)},
+			models.RoomRestriction{RoomID: 1, RestrictionID: models.RestrictionHold, ExpiresAt: time.Date(2050, 1, 1, 12, 15, 0, 0, time.UTC),
+				StartDate: time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 7, 0, 0, 0, 0, time.UTC)},
+		)
+	}
+	if roomID == 2 {
+		return roomRestrictions, errors.New("some error")
+	}
 	return roomRestrictions, nil
 }
 
 func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+	// if the room id is 500, then the room is already reserved for the date
+	if id == 500 {
+		return repository.ErrRoomUnavailable
+	}
 	return nil
 }
 
 func (m *testDBRepo) DeleteBlockRoomRestrictionByID(id int) error {
 	return nil
 }
+
+func (m *testDBRepo) AllRatePlans() ([]models.RatePlan, error) {
+	var plans []models.RatePlan
+	return plans, nil
+}
+
+func (m *testDBRepo) GetRatePlanByID(id int) (models.RatePlan, error) {
+	var plan models.RatePlan
+	if id > 1 {
+		return plan, errors.New("some error")
+	}
+	return testRatePlan(), nil
+}
+
+func (m *testDBRepo) GetRatePlanForRoom(roomID int) (models.RatePlan, error) {
+	// only room 1 is priced by a rate plan
+	if roomID != 1 {
+		return models.RatePlan{}, nil
+	}
+	return testRatePlan(), nil
+}
+
+// testRatePlan charges 100.00 a night for room 1, and 20% more on fridays and saturdays
+func testRatePlan() models.RatePlan {
+	return models.RatePlan{
+		ID:              1,
+		Name:            "Standard",
+		Active:          true,
+		WeekdayPercents: [7]int{100, 100, 100, 100, 100, 120, 120},
+		RoomPrices:      map[int]int{1: 10000},
+	}
+}
+
+func (m *testDBRepo) InsertRatePlan(p models.RatePlan) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) UpdateRatePlan(p models.RatePlan) error {
+	return nil
+}
+
+func (m *testDBRepo) InsertRateSeason(s models.RateSeason) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteRateSeason(id int) error {
+	// if the id is 99, then fail the delete
+	if id == 99 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) AllStayRules() ([]models.RoomRestriction, error) {
+	var rules []models.RoomRestriction
+	return rules, nil
+}
+
+func (m *testDBRepo) GetStayRulesForRoom(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	// room 1 is closed to arrival on 2050-01-05
+	var rules []models.RoomRestriction
+	if roomID == 1 {
+		rules = append(rules, models.RoomRestriction{RoomID: 1, RestrictionID: models.RestrictionClosedToArrival,
+			StartDate: time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC)})
+	}
+	return rules, nil
+}
+
+func (m *testDBRepo) InsertStayRule(r models.RoomRestriction) error {
+	// if the room id is 2, then fail inserting the rule
+	if r.RoomID == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteStayRule(id int) error {
+	// if the id is 99, then fail the delete
+	if id == 99 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) UpdateReservationDates(res models.Reservation) error {
+	// if the room id is 2, then fail updating the reservation
+	if res.RoomID == 2 {
+		return errors.New("some error")
+	}
+	// if the start date is 2049-12-30, then the room was just booked by someone else
+	if res.StartDate.Format(constants.Layout) == "2049-12-30" {
+		return repository.ErrRoomUnavailable
+	}
+	return nil
+}
+
+func (m *testDBRepo) InsertPayment(p models.Payment) error {
+	// if the reservation id is 4, then fail recording the payment
+	if p.ReservationID == 4 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) GetPaymentsForReservation(id int) ([]models.Payment, error) {
+	var history []models.Payment
+
+	switch id {
+	case 1:
+		// reservation 1 has an authorized deposit
+		history = append(history,
+			models.Payment{ReservationID: id, Kind: models.PaymentAuthorization, Amount: 3000, Reference: "fake_1"})
+	case 2:
+		// reservation 2 has a captured deposit
+		history = append(history,
+			models.Payment{ReservationID: id, Kind: models.PaymentAuthorization, Amount: 3000, Reference: "fake_2"},
+			models.Payment{ReservationID: id, Kind: models.PaymentCapture, Amount: 3000, Reference: "fake_2"})
+	case 5:
+		// reservation 5 has a captured deposit the gateway doesn't know about
+		history = append(history,
+			models.Payment{ReservationID: id, Kind: models.PaymentAuthorization, Amount: 3000, Reference: "fake_unknown"},
+			models.Payment{ReservationID: id, Kind: models.PaymentCapture, Amount: 3000, Reference: "fake_unknown"})
+	case 6:
+		return history, errors.New("some error")
+	}
+	return history, nil
+}
+
+func (m *testDBRepo) CancelReservation(c models.Cancellation, from models.ReservationStatus) error {
+	// reservation 2 was changed by someone else in the meantime
+	if c.ReservationID == 2 {
+		return repository.ErrStatusChanged
+	}
+	// if the reservation id is 4, then fail cancelling the reservation
+	if c.ReservationID == 4 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) GetCancellationForReservation(id int) (models.Cancellation, error) {
+	// reservation 7 was cancelled within 24 hours before check-in
+	if id == 7 {
+		return models.Cancellation{ReservationID: id, PolicyName: "Standard", HoursBefore: 12, FeePercent: 100, Fee: 10000}, nil
+	}
+	return models.Cancellation{}, repository.ErrNotFound
+}
+
+func (m *testDBRepo) AllCancellationPolicies() ([]models.CancellationPolicy, error) {
+	return []models.CancellationPolicy{testCancellationPolicy()}, nil
+}
+
+func (m *testDBRepo) GetCancellationPolicyForRoom(roomID int) (models.CancellationPolicy, error) {
+	// only room 1 has a cancellation policy
+	if roomID != 1 {
+		return models.CancellationPolicy{}, nil
+	}
+	return testCancellationPolicy(), nil
+}
+
+// testCancellationPolicy is free until 7 days before check-in, keeps 50% after, and everything within 24 hours
+func testCancellationPolicy() models.CancellationPolicy {
+	return models.CancellationPolicy{
+		ID:   1,
+		Name: "Standard",
+		Tiers: []models.CancellationTier{
+			{ID: 1, PolicyID: 1, HoursBefore: 168, FeePercent: 50},
+			{ID: 2, PolicyID: 1, HoursBefore: 24, FeePercent: 100},
+		},
+	}
+}
+
+func (m *testDBRepo) InsertCancellationPolicy(p models.CancellationPolicy) (int, error) {
+	// if the name is "fail", then fail inserting the policy
+	if p.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 2, nil
+}
+
+func (m *testDBRepo) InsertCancellationTier(t models.CancellationTier) error {
+	// if the policy id is 2, then fail inserting the tier
+	if t.PolicyID == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteCancellationTier(id int) error {
+	// if the id is 99, then fail the delete
+	if id == 99 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) GetInvoiceForReservation(id int) (models.Invoice, error) {
+	// reservation 3 was invoiced before
+	if id == 3 {
+		return models.Invoice{
+			ID:            1,
+			ReservationID: id,
+			Number:        42,
+			BillTo:        "John Smith",
+			Lines: []models.InvoiceLine{
+				{Kind: models.InvoiceLineCharge, Description: "General's Quarters, night", Quantity: 2, UnitPrice: 10000, Amount: 20000},
+			},
+			Total: 20000,
+		}, nil
+	}
+	return models.Invoice{}, repository.ErrNotFound
+}
+
+func (m *testDBRepo) InsertInvoice(inv models.Invoice) (models.Invoice, error) {
+	// if the reservation id is 9, then fail issuing the invoice
+	if inv.ReservationID == 9 {
+		return inv, errors.New("some error")
+	}
+	inv.ID = 2
+	inv.Number = 43
+	inv.IssuedAt = time.Now()
+	return inv, nil
+}
+
+func (m *testDBRepo) AllTaxRules() ([]models.TaxRule, error) {
+	return []models.TaxRule{
+		{ID: 1, Name: "VAT", Kind: models.TaxPercent, Amount: 1000},
+		{ID: 2, Name: "City tax", Kind: models.TaxFixed, Basis: models.TaxPerPersonNight, Amount: 200},
+	}, nil
+}
+
+func (m *testDBRepo) InsertTaxRule(r models.TaxRule) error {
+	// if the name is "fail", then fail inserting the rule
+	if r.Name == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteTaxRule(id int) error {
+	// if the id is 99, then fail the delete
+	if id == 99 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// testPromoCodes are the promo codes known to the test repository, by code
+var testPromoCodes = map[string]models.PromoCode{
+	"SUMMER10": {ID: 1, Code: "SUMMER10", Kind: models.DiscountPercent, Amount: 1000},
+	"ROOM2":    {ID: 2, Code: "ROOM2", Kind: models.DiscountFixed, Amount: 2000, RoomIDs: []int{2}},
+	"RACE":     {ID: 3, Code: "RACE", Kind: models.DiscountFixed, Amount: 2000, MaxUses: 1},
+	"EXPIRED": {ID: 4, Code: "EXPIRED", Kind: models.DiscountPercent, Amount: 1000,
+		ValidUntil: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	"USEDUP":   {ID: 5, Code: "USEDUP", Kind: models.DiscountPercent, Amount: 1000, MaxUses: 10, Uses: 10},
+	"LONGSTAY": {ID: 6, Code: "LONGSTAY", Kind: models.DiscountPercent, Amount: 1000, MinNights: 30},
+}
+
+func (m *testDBRepo) AllPromoCodes() ([]models.PromoCode, error) {
+	return []models.PromoCode{testPromoCodes["ROOM2"], testPromoCodes["SUMMER10"]}, nil
+}
+
+func (m *testDBRepo) GetPromoCodeByID(id int) (models.PromoCode, error) {
+	for _, p := range testPromoCodes {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return models.PromoCode{}, repository.ErrNotFound
+}
+
+func (m *testDBRepo) GetPromoCodeByCode(code string) (models.PromoCode, error) {
+	// if the code is "BROKEN", then fail the query
+	if code == "BROKEN" {
+		return models.PromoCode{}, errors.New("some error")
+	}
+	p, ok := testPromoCodes[code]
+	if !ok {
+		return p, repository.ErrNotFound
+	}
+	return p, nil
+}
+
+func (m *testDBRepo) InsertPromoCode(p models.PromoCode) error {
+	// if the code is "TAKEN", then it already exists; if it is "FAIL", then fail inserting the code
+	if p.Code == "TAKEN" {
+		return repository.ErrDuplicatePromoCode
+	}
+	if p.Code == "FAIL" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeletePromoCode(id int) error {
+	// if the id is 99, then fail the delete
+	if id == 99 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// testWaitlist are the waitlist entries known to the test repository, in the order the guests joined
+var testWaitlist = []models.WaitlistEntry{
+	{ID: 1, FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", Adults: 2, Status: models.WaitlistWaiting,
+		StartDate: time.Date(2049, 6, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2049, 6, 4, 0, 0, 0, 0, time.UTC)},
+	// arrivals are closed on 2049-12-24
+	{ID: 2, FirstName: "Bob", LastName: "Late", Email: "bob@late.com", Adults: 1, Status: models.WaitlistWaiting,
+		StartDate: time.Date(2049, 12, 24, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2049, 12, 26, 0, 0, 0, 0, time.UTC)},
+	// the room is taken after 2049-12-31
+	{ID: 3, FirstName: "Ann", LastName: "Taken", Email: "ann@taken.com", Adults: 1, Status: models.WaitlistWaiting,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)},
+	{ID: 4, FirstName: "Ole", LastName: "Offered", Email: "ole@offered.com", Adults: 2, Children: 1,
+		Status: models.WaitlistNotified, RoomID: 1, HoldToken: "waitlist-hold",
+		NotifiedAt:     time.Date(2049, 5, 1, 0, 0, 0, 0, time.UTC),
+		ClaimExpiresAt: time.Date(2049, 5, 1, 12, 0, 0, 0, time.UTC),
+		StartDate:      time.Date(2049, 7, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2049, 7, 3, 0, 0, 0, 0, time.UTC),
+		Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+	{ID: 5, FirstName: "Eve", LastName: "Expired", Email: "eve@expired.com", Adults: 1,
+		Status: models.WaitlistExpired, RoomID: 1, HoldToken: "expired-hold",
+		StartDate: time.Date(2049, 8, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2049, 8, 3, 0, 0, 0, 0, time.UTC),
+		Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+	// the entry is offered to the guest by someone else in the meantime
+	{ID: 6, FirstName: "Max", LastName: "Race", Email: "max@race.com", Adults: 1, Status: models.WaitlistWaiting,
+		StartDate: time.Date(2049, 9, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2049, 9, 3, 0, 0, 0, 0, time.UTC)},
+}
+
+func (m *testDBRepo) AllWaitlistEntries() ([]models.WaitlistEntry, error) {
+	return testWaitlist, nil
+}
+
+func (m *testDBRepo) GetWaitlistEntryByID(id int) (models.WaitlistEntry, error) {
+	// if the id is 99, then fail the query
+	if id == 99 {
+		return models.WaitlistEntry{}, errors.New("some error")
+	}
+	for _, e := range testWaitlist {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return models.WaitlistEntry{}, repository.ErrNotFound
+}
+
+func (m *testDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	// if the email is "fail@here.com", then fail inserting the entry
+	if e.Email == "fail@here.com" {
+		return 0, errors.New("some error")
+	}
+	return 7, nil
+}
+
+func (m *testDBRepo) WaitlistEntriesForRoom(roomID int, start, end time.Time) ([]models.WaitlistEntry, error) {
+	// if the room id is 1000, then fail the query; only room 1 has guests waiting for it
+	if roomID == 1000 {
+		return nil, errors.New("some error")
+	}
+	var entries []models.WaitlistEntry
+	if roomID != 1 {
+		return entries, nil
+	}
+	for _, e := range testWaitlist {
+		if e.Status == models.WaitlistWaiting {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (m *testDBRepo) OfferWaitlistEntry(e models.WaitlistEntry) error {
+	// the entry 6 was offered by someone else in the meantime
+	if e.ID == 6 {
+		return repository.ErrWaitlistStatusChanged
+	}
+	return nil
+}
+
+func (m *testDBRepo) ClaimWaitlistEntry(id int, now time.Time) error {
+	for _, e := range testWaitlist {
+		offered := e.Status == models.WaitlistNotified || e.Status == models.WaitlistClaimed
+		if e.ID == id && (!offered || !now.Before(e.ClaimExpiresAt)) {
+			return repository.ErrWaitlistStatusChanged
+		}
+	}
+	return nil
+}
+
+func (m *testDBRepo) ExpireWaitlistOffers(now time.Time) ([]models.WaitlistEntry, error) {
+	// after 2060 the query fails, otherwise the offer of entry 5 expired
+	if now.Year() >= 2060 {
+		return nil, errors.New("some error")
+	}
+	return []models.WaitlistEntry{testWaitlist[4]}, nil
+}
+
+// testGuests are the guest profiles known to the test repository, guest 2 is a duplicate of guest 1
+var testGuests = []models.Guest{
+	{ID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com", Phone: "555-123-4567", Stays: 2, LifetimeNights: 5},
+	{ID: 2, FirstName: "John", LastName: "Smith", Email: "jsmith@work.com", Stays: 1, LifetimeNights: 2},
+	// the stay history of guest 3 fails to load
+	{ID: 3, FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com"},
+}
+
+func (m *testDBRepo) AllGuests() ([]models.Guest, error) {
+	return testGuests, nil
+}
+
+func (m *testDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	// if the id is 99, then fail the query
+	if id == 99 {
+		return models.Guest{}, errors.New("some error")
+	}
+	for _, g := range testGuests {
+		if g.ID == id {
+			return g, nil
+		}
+	}
+	return models.Guest{}, repository.ErrNotFound
+}
+
+func (m *testDBRepo) GetReservationsForGuest(guestID int) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	if guestID == 3 {
+		return reservations, errors.New("some error")
+	}
+	if guestID == 1 {
+		reservations = append(reservations,
+			models.Reservation{ID: 3, GuestID: 1, RoomID: 1, Status: models.StatusCheckedOut,
+				StartDate: time.Date(2049, 6, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2049, 6, 4, 0, 0, 0, 0, time.UTC),
+				Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+			models.Reservation{ID: 7, GuestID: 1, RoomID: 1, Status: models.StatusCancelled,
+				StartDate: time.Date(2049, 3, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2049, 3, 2, 0, 0, 0, 0, time.UTC),
+				Room: models.Room{ID: 1, RoomName: "General's Quarters"}})
+	}
+	return reservations, nil
+}
+
+func (m *testDBRepo) GetPossibleDuplicateGuests(g models.Guest) ([]models.Guest, error) {
+	var guests []models.Guest
+	for _, other := range testGuests {
+		if other.ID != g.ID && other.FirstName == g.FirstName && other.LastName == g.LastName {
+			guests = append(guests, other)
+		}
+	}
+	return guests, nil
+}
+
+func (m *testDBRepo) MergeGuests(keepID, mergeID int) error {
+	// if the merged id is 99, then fail the merge
+	if mergeID == 99 {
+		return errors.New("some error")
+	}
+	if _, err := m.GetGuestByID(keepID); err != nil {
+		return err
+	}
+	if _, err := m.GetGuestByID(mergeID); err != nil {
+		return err
+	}
+	return nil
+}
@@ -1,31 +1,142 @@
 package repository
 
 import (
+	"errors"
 	"github.com/loidinhm31/go-bookings-system/internal/models"
 	"time"
 )
 
+// ErrRoomUnavailable is returned when a room restriction would overlap an existing one for the same room
+var ErrRoomUnavailable = errors.New("room is not available for the given dates")
+
+// ErrStatusChanged is returned when a reservation is no longer in the status it was expected to move from
+var ErrStatusChanged = errors.New("reservation status was changed in the meantime")
+
+// ErrWaitlistStatusChanged is returned when a waitlist entry is no longer in the status it was expected to move from
+var ErrWaitlistStatusChanged = errors.New("waitlist entry status was changed in the meantime")
+
+// ErrNotFound is returned when the looked up record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicateEmail is returned when an account with the email address already exists
+var ErrDuplicateEmail = errors.New("email address is already registered")
+
+// ErrDuplicateSlug is returned when a room slug is already used by another room
+var ErrDuplicateSlug = errors.New("room slug is already taken")
+
+// ErrPromoCodeUsedUp is returned when a reservation would redeem a promo code beyond its usage limit
+var ErrPromoCodeUsedUp = errors.New("promo code has been used up")
+
+// ErrDuplicatePromoCode is returned when a promo code already exists
+var ErrDuplicatePromoCode = errors.New("promo code already exists")
+
+// StayRuleError is returned when a stay rule of the room rejects the dates of a reservation
+type StayRuleError struct {
+	Violation models.RuleViolation
+}
+
+func (e *StayRuleError) Error() string {
+	return e.Violation.Reason
+}
+
 type DatabaseRepo interface {
 	AllUsers() bool
 
-	InsertReservation(res models.Reservation) (int, error)
+	InsertReservation(res models.Reservation) (int, string, error)
 	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByRoomIDAndDates(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
+	CreateReservation(res models.Reservation) (int, string, error)
+	CreateReservations(stays []models.Reservation) ([]models.Reservation, error)
+	PlaceHold(hold models.RoomRestriction) error
+	ReleaseHold(token string) error
+	DeleteExpiredHolds(now time.Time) (int, error)
+	SearchAvailabilityByRoomIDAndDates(start, end time.Time, roomID int) (bool, *models.RuleViolation, error)
+	SearchAvailabilityByRoomIDAndDatesExcluding(start, end time.Time, roomID, reservationID int) (bool, *models.RuleViolation, error)
+	SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, []models.RuleViolation, error)
+	SearchFreeGaps(start, end time.Time, nights, adults, children int) ([]models.FreeGap, error)
 	GetRoomByID(id int) (models.Room, error)
+	GetRoomBySlug(slug string) (models.Room, error)
 
 	GetUserByID(id int) (models.User, error)
 	UpdateUser(u models.User) error
 	Authenticate(email, testPassword string) (int, string, error)
+	InsertUser(u models.User) (int, error)
+	VerifyUserEmail(id int) error
+	GetReservationsForAccount(email string) ([]models.Reservation, error)
+	GetUserByEmail(email string) (models.User, error)
+	InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) error
+	GetPasswordResetUserID(tokenHash string, now time.Time) (int, error)
+	ResetPassword(tokenHash, password string, now time.Time) (int, error)
 
-	AllReservations() ([]models.Reservation, error)
+	AllReservations(status models.ReservationStatus) ([]models.Reservation, error)
 	AllNewReservations() ([]models.Reservation, error)
 	GetReservationByID(id int) (models.Reservation, error)
+	GetReservationByCode(code string) (models.Reservation, error)
+	GetReservationsInGroup(groupID int) ([]models.Reservation, error)
 	UpdateReservation(u models.Reservation) error
+	UpdateReservationDates(res models.Reservation) error
 	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
+	UpdateReservationStatus(id int, from, to models.ReservationStatus) error
+	GetStatusChangesForReservation(id int) ([]models.ReservationStatusChange, error)
+	CancelReservation(c models.Cancellation, from models.ReservationStatus) error
+	GetCancellationForReservation(id int) (models.Cancellation, error)
+
+	InsertPayment(p models.Payment) error
+	GetPaymentsForReservation(id int) ([]models.Payment, error)
+
+	GetInvoiceForReservation(id int) (models.Invoice, error)
+	InsertInvoice(inv models.Invoice) (models.Invoice, error)
+
+	AllTaxRules() ([]models.TaxRule, error)
+	InsertTaxRule(r models.TaxRule) error
+	DeleteTaxRule(id int) error
+
+	AllPromoCodes() ([]models.PromoCode, error)
+	GetPromoCodeByID(id int) (models.PromoCode, error)
+	GetPromoCodeByCode(code string) (models.PromoCode, error)
+	InsertPromoCode(p models.PromoCode) error
+	DeletePromoCode(id int) error
+
+	AllGuests() ([]models.Guest, error)
+	GetGuestByID(id int) (models.Guest, error)
+	GetReservationsForGuest(guestID int) ([]models.Reservation, error)
+	GetPossibleDuplicateGuests(g models.Guest) ([]models.Guest, error)
+	MergeGuests(keepID, mergeID int) error
+
+	AllWaitlistEntries() ([]models.WaitlistEntry, error)
+	GetWaitlistEntryByID(id int) (models.WaitlistEntry, error)
+	InsertWaitlistEntry(e models.WaitlistEntry) (int, error)
+	WaitlistEntriesForRoom(roomID int, start, end time.Time) ([]models.WaitlistEntry, error)
+	OfferWaitlistEntry(e models.WaitlistEntry) error
+	ClaimWaitlistEntry(id int, now time.Time) error
+	ExpireWaitlistOffers(now time.Time) ([]models.WaitlistEntry, error)
+
+	AllCancellationPolicies() ([]models.CancellationPolicy, error)
+	GetCancellationPolicyForRoom(roomID int) (models.CancellationPolicy, error)
+	InsertCancellationPolicy(p models.CancellationPolicy) (int, error)
+	InsertCancellationTier(t models.CancellationTier) error
+	DeleteCancellationTier(id int) error
 	AllRooms() ([]models.Room, error)
+	AllRoomsWithRetired() ([]models.Room, error)
+	InsertRoom(room models.Room) (int, error)
+	UpdateRoom(room models.Room) error
+	DeleteRoom(id int) error
+	GetPhotosForRoom(roomID int) ([]models.RoomPhoto, error)
+	GetRoomPhotoByID(id int) (models.RoomPhoto, error)
+	InsertRoomPhoto(photo models.RoomPhoto) (int, error)
+	UpdateRoomPhoto(photo models.RoomPhoto) error
+	DeleteRoomPhoto(id int) error
+	AllRatePlans() ([]models.RatePlan, error)
+	GetRatePlanByID(id int) (models.RatePlan, error)
+	GetRatePlanForRoom(roomID int) (models.RatePlan, error)
+	InsertRatePlan(p models.RatePlan) (int, error)
+	UpdateRatePlan(p models.RatePlan) error
+	InsertRateSeason(s models.RateSeason) (int, error)
+	DeleteRateSeason(id int) error
 	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
 	InsertBlockForRoom(id int, startDate time.Time) error
 	DeleteBlockRoomRestrictionByID(id int) error
+	AllStayRules() ([]models.RoomRestriction, error)
+	GetStayRulesForRoom(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertStayRule(r models.RoomRestriction) error
+	DeleteStayRule(id int) error
 }
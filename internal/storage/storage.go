@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Storage saves uploaded files and tells where they are served from
+type Storage interface {
+	Save(name string, r io.Reader) error
+	Delete(name string) error
+	URL(name string) string
+}
+
+// LocalStorage keeps files in a directory on local disk
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+// NewLocalStorage creates a storage for files in dir, which are served under baseURL
+func NewLocalStorage(dir, baseURL string) *LocalStorage {
+	return &LocalStorage{
+		Dir:     dir,
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// Save writes the content of r to the file with the given name
+func (s *LocalStorage) Save(name string, r io.Reader) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(p)
+		return err
+	}
+	return f.Close()
+}
+
+// Delete removes the file with the given name, a missing file is not an error
+func (s *LocalStorage) Delete(name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// URL returns the url the file with the given name is served from
+func (s *LocalStorage) URL(name string) string {
+	return s.BaseURL + "/" + path.Clean(name)
+}
+
+// path returns the location of the file on disk, names must not escape the storage directory
+func (s *LocalStorage) path(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if clean == "." || filepath.IsAbs(clean) || strings.HasPrefix(clean, "..") {
+		return "", errors.New("invalid file name")
+	}
+	return filepath.Join(s.Dir, clean), nil
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	s := NewLocalStorage(dir, "/uploads/")
+
+	err := s.Save("rooms/1/photo.jpg", strings.NewReader("content"))
+	if err != nil {
+		t.Fatal("failed to save file", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "rooms", "1", "photo.jpg"))
+	if err != nil || string(data) != "content" {
+		t.Error("saved file does not have the expected content")
+	}
+
+	if url := s.URL("rooms/1/photo.jpg"); url != "/uploads/rooms/1/photo.jpg" {
+		t.Errorf("expected url /uploads/rooms/1/photo.jpg but got %s", url)
+	}
+
+	if err = s.Delete("rooms/1/photo.jpg"); err != nil {
+		t.Error("failed to delete file", err)
+	}
+
+	if err = s.Delete("rooms/1/photo.jpg"); err != nil {
+		t.Error("deleting a missing file should not fail", err)
+	}
+
+	if err = s.Save("../outside.jpg", strings.NewReader("content")); err == nil {
+		t.Error("saved a file outside of the storage directory")
+	}
+}
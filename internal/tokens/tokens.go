@@ -0,0 +1,77 @@
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for tokens which are malformed or were not signed with the key
+var ErrInvalidToken = errors.New("invalid token")
+
+// ErrExpiredToken is returned for correctly signed tokens which have expired
+var ErrExpiredToken = errors.New("token has expired")
+
+// Signer creates and verifies tamper-proof tokens carrying an id and an expiry time, signed with HMAC-SHA256
+type Signer struct {
+	key []byte
+}
+
+// NewSigner creates a signer using the secret key
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// Sign returns a url-safe token for the id, valid for the purpose until expires
+func (s *Signer) Sign(purpose string, id int, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d", id, expires.Unix())
+	return payload + "." + s.mac(purpose, payload)
+}
+
+// Verify checks a token signed for the purpose and returns its id
+func (s *Signer) Verify(purpose, token string) (int, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, ErrInvalidToken
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(s.mac(purpose, payload))) {
+		return 0, ErrInvalidToken
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+
+	if time.Now().After(time.Unix(expires, 0)) {
+		return 0, ErrExpiredToken
+	}
+	return id, nil
+}
+
+// mac signs the payload for the purpose, so a token issued for one purpose can't be used for another
+func (s *Signer) mac(purpose, payload string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(purpose + "|" + payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Hash returns the SHA-256 hash of a random token, to store one-time tokens without being able to use them from the
+// stored value
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
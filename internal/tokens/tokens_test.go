@@ -0,0 +1,62 @@
+package tokens
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var signer = NewSigner([]byte("secret"))
+
+func TestSigner_Verify(t *testing.T) {
+	token := signer.Sign("manage", 42, time.Now().Add(time.Hour))
+
+	id, err := signer.Verify("manage", token)
+	if err != nil {
+		t.Errorf("expected a valid token but got %s", err)
+	}
+	if id != 42 {
+		t.Errorf("expected id 42 but got %d", id)
+	}
+}
+
+func TestSigner_VerifyInvalid(t *testing.T) {
+	token := signer.Sign("manage", 42, time.Now().Add(time.Hour))
+	parts := strings.Split(token, ".")
+
+	var tests = []struct {
+		name    string
+		purpose string
+		token   string
+		signer  *Signer
+		err     error
+	}{
+		{"other-id", "manage", "43." + parts[1] + "." + parts[2], signer, ErrInvalidToken},
+		{"later-expiry", "manage", parts[0] + ".9999999999." + parts[2], signer, ErrInvalidToken},
+		{"other-purpose", "reset", token, signer, ErrInvalidToken},
+		{"other-key", "manage", token, NewSigner([]byte("other")), ErrInvalidToken},
+		{"malformed", "manage", "not-a-token", signer, ErrInvalidToken},
+		{"empty", "manage", "", signer, ErrInvalidToken},
+		{"expired", "manage", signer.Sign("manage", 42, time.Now().Add(-time.Minute)), signer, ErrExpiredToken},
+	}
+
+	for _, e := range tests {
+		_, err := e.signer.Verify(e.purpose, e.token)
+		if err != e.err {
+			t.Errorf("failed %s: expected %v but got %v", e.name, e.err, err)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	hash := Hash("some-token")
+	if hash != Hash("some-token") {
+		t.Error("got different hashes for the same token")
+	}
+	if hash == Hash("other-token") || hash == "some-token" {
+		t.Error("got the same hash for different tokens")
+	}
+	if len(hash) != 64 {
+		t.Errorf("expected a hash of 64 hex characters but got %d", len(hash))
+	}
+}